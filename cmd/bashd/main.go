@@ -8,6 +8,7 @@ import (
 	"slices"
 	"time"
 
+	"github.com/matkrin/bashd/internal/ast"
 	"github.com/matkrin/bashd/internal/lsp"
 	"github.com/matkrin/bashd/internal/server"
 	"github.com/matkrin/bashd/internal/shellcheck"
@@ -25,11 +26,15 @@ func main() {
 	verbosityOpt := pflag.CountP("verbose", "v", "Increase log message verbosity")
 	versionOpt := pflag.BoolP("version", "V", false, "Print version")
 
+	indexCacheDirOpt := pflag.String("index-cache-dir", "", "Persist the workspace index in DIR, e.g. ~/.cache/bashd")
+
 	severityOpt := pflag.StringP("severity", "S", "style", "Minimum severity of errors to consider")
+	shellOpt := pflag.StringP("shell", "s", "", "Dialect of all scripts (bash, sh, dash, busybox, ksh, mksh, bats), detected per script if empty")
 
 	shellcheckIncludeOpt := pflag.StringSlice("shellcheck-include", []string{}, "Only include ShellCheck lints")
 	shellcheckExcludeOpt := pflag.StringSlice("shellcheck-exclude", []string{}, "Exclude ShellCheck lints")
 	shellcheckEnableOpt := pflag.StringSlice("shellcheck-enable", []string{}, "Enable ShellCheck optional lints")
+	sourcePathOpt := pflag.StringSliceP("source-path", "P", []string{}, "Search sourced files in DIR, SCRIPTDIR is the directory of the script")
 
 	fmtBinaryNextLineOpt := pflag.Bool("fmt-binary-next-line", false, "Binary ops start a line")
 	fmtCaseIndentOpt := pflag.Bool("fmt-case-indent", false, "Switch cases will be indented")
@@ -50,6 +55,11 @@ func main() {
 		os.Exit(1)
 	}
 
+	if *shellOpt != "" && !slices.Contains(ast.Dialects, ast.Dialect(*shellOpt)) {
+		fmt.Fprintf(os.Stderr, "illegal shell '%s'\n", *shellOpt)
+		os.Exit(1)
+	}
+
 	if *versionOpt {
 		fmt.Printf("%s %s\n", name, VERSION)
 		os.Exit(0)
@@ -65,10 +75,11 @@ func main() {
 	}
 
 	shellcheckOptions := shellcheck.Options{
-		Include:  *shellcheckIncludeOpt,
-		Exclude:  *shellcheckExcludeOpt,
-		Enable:   *shellcheckEnableOpt,
-		Severity: *severityOpt,
+		Include:     *shellcheckIncludeOpt,
+		Exclude:     *shellcheckExcludeOpt,
+		Enable:      *shellcheckEnableOpt,
+		Severity:    *severityOpt,
+		SourcePaths: *sourcePathOpt,
 	}
 
 	formatOptions := server.FormatOptions{
@@ -81,6 +92,8 @@ func main() {
 	config := server.Config{
 		ExcludeDirs:            []string{".git", ".venv", "node_modules"},
 		DiagnosticDebounceTime: 200 * time.Millisecond,
+		IndexCacheDir:          *indexCacheDirOpt,
+		Dialect:                ast.Dialect(*shellOpt),
 		ShellCheckOptions:      shellcheckOptions,
 		FormatOptions:          formatOptions,
 	}
@@ -1,9 +1,10 @@
 package ast
 
 import (
-	"os"
+	"math"
 	"strings"
 
+	"github.com/matkrin/bashd/internal/lsp"
 	"mvdan.cc/sh/v3/syntax"
 )
 
@@ -16,11 +17,24 @@ func NewCursor(lspLine, lspCol uint) Cursor {
 	return Cursor{Line: lspLine + 1, Col: lspCol + 1}
 }
 
+// Cursor at an LSP position, whose character is counted in the encoding of
+// mapper
+func NewCursorAt(mapper *lsp.Mapper, position lsp.Position) Cursor {
+	return NewCursor(mapper.ToByteColumn(position))
+}
+
 func (c *Cursor) isCursorInNode(node syntax.Node) bool {
+	if !node.Pos().IsValid() {
+		return false
+	}
 	startLine := node.Pos().Line()
 	startCol := node.Pos().Col()
 	endLine := node.End().Line()
 	endCol := node.End().Col()
+	// Nodes whose end was recovered by the parser extend to the end of the file
+	if !node.End().IsValid() {
+		endLine, endCol = math.MaxUint, math.MaxUint
+	}
 
 	if c.Line < startLine || c.Line > endLine {
 		return false
@@ -41,23 +55,47 @@ func (c *Cursor) isCursorInNode(node syntax.Node) bool {
 	}
 }
 
+func (c *Cursor) isAfter(pos syntax.Pos) bool {
+	return c.Line > pos.Line() || (c.Line == pos.Line() && c.Col >= pos.Col())
+}
+
 type Ast struct {
 	File *syntax.File
+	// Regions with syntax errors, if parsed by ParseTolerant
+	Broken []Region
+	scopes *Scope
 }
 
+// Parse the document in the dialect detected from its text and name
 func ParseDocument(documentText, documentName string, fallible bool) (*Ast, error) {
+	return ParseDocumentAs(documentText, documentName, fallible, "")
+}
+
+// Parse the document in dialect, or the dialect detected from its text and
+// name if empty
+func ParseDocumentAs(documentText, documentName string, fallible bool, dialect Dialect) (*Ast, error) {
 	reader := strings.NewReader(documentText)
-	var parser *syntax.Parser
-	if fallible {
-		parser = syntax.NewParser(syntax.KeepComments(true), syntax.RecoverErrors(9999))
-	} else {
-		parser = syntax.NewParser(syntax.KeepComments(true))
-	}
+	parser := newParser(documentText, documentName, fallible, dialect)
 	file, err := parser.Parse(reader, documentName)
 	if err != nil {
 		return nil, err
 	}
-	return &Ast{File: file}, nil
+	return &Ast{File: file, scopes: newScopeTree(file)}, nil
+}
+
+// Syntax tree of the file at path, for finding definitions and references
+// across files
+type FileParser func(path string) (*Ast, error)
+
+func newParser(documentText, documentName string, fallible bool, dialect Dialect) *syntax.Parser {
+	if dialect == "" {
+		dialect = DetectDialect(documentText, documentName)
+	}
+	options := []syntax.ParserOption{syntax.KeepComments(true), syntax.Variant(dialect.LangVariant())}
+	if fallible {
+		options = append(options, syntax.RecoverErrors(9999))
+	}
+	return syntax.NewParser(options...)
 }
 
 func (a *Ast) FindNodeUnderCursor(cursor Cursor) syntax.Node {
@@ -109,52 +147,3 @@ func ExtractIdentifier(node syntax.Node) string {
 	}
 	return ""
 }
-
-func extractAndExpandWord(word *syntax.Word, env map[string]string) string {
-	var b strings.Builder
-	for _, part := range word.Parts {
-		switch p := part.(type) {
-		case *syntax.Lit:
-			b.WriteString(p.Value)
-
-		case *syntax.ParamExp:
-			val := env[p.Param.Value]
-			b.WriteString(val)
-
-		case *syntax.SglQuoted:
-			b.WriteString(p.Value)
-
-		case *syntax.DblQuoted:
-			for _, qpart := range p.Parts {
-				switch qp := qpart.(type) {
-				case *syntax.Lit:
-					b.WriteString(qp.Value)
-				case *syntax.ParamExp:
-					val := env[qp.Param.Value]
-					b.WriteString(val)
-				}
-			}
-		}
-	}
-
-	return os.Expand(b.String(), func(key string) string {
-		return env[key]
-	})
-}
-
-func (a *Ast) findEnclosingFunction(cursor Cursor) *syntax.FuncDecl {
-	var enclosingFunc *syntax.FuncDecl
-
-	syntax.Walk(a.File, func(node syntax.Node) bool {
-		fn, ok := node.(*syntax.FuncDecl)
-		if !ok {
-			return true
-		}
-		if cursor.isCursorInNode(fn) {
-			enclosingFunc = fn
-		}
-		return true
-	})
-
-	return enclosingFunc
-}
@@ -0,0 +1,40 @@
+package ast
+
+import "mvdan.cc/sh/v3/syntax"
+
+// Whether the reference is a call of a command, like a function
+func (r *RefNode) IsCall() bool {
+	_, ok := r.Node.(*syntax.CallExpr)
+	return ok
+}
+
+// Function the reference is in, nil in top-level code
+func (r *RefNode) Caller() *syntax.FuncDecl {
+	if r.Scope == nil {
+		return nil
+	}
+	return r.Scope.Function()
+}
+
+// Calls in the body of funcDecl, or in the top-level code outside of any
+// function if funcDecl is nil. Calls in nested functions belong to these.
+func (a *Ast) CallsIn(funcDecl *syntax.FuncDecl) []RefNode {
+	var calls []RefNode
+	for _, refNode := range a.RefNodes(false) {
+		if refNode.IsCall() && refNode.Caller() == funcDecl {
+			calls = append(calls, refNode)
+		}
+	}
+	return calls
+}
+
+// Declaration of the function whose name is under cursor
+func (a *Ast) FindFuncDecl(cursor Cursor) *syntax.FuncDecl {
+	for _, defNode := range a.DefNodes() {
+		funcDecl, ok := defNode.Node.(*syntax.FuncDecl)
+		if ok && cursor.isCursorInNode(funcDecl.Name) {
+			return funcDecl
+		}
+	}
+	return nil
+}
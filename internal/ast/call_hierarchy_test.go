@@ -0,0 +1,37 @@
+package ast
+
+import "testing"
+
+func Test_CallsIn(t *testing.T) {
+	input := "f() {\n  g\n  h() { i; }\n  (j | k)\n}\nf\nread x\n"
+	fileAst, err := ParseDocument(input, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := func(refNodes []RefNode) []string {
+		var names []string
+		for _, refNode := range refNodes {
+			names = append(names, refNode.Name)
+		}
+		return names
+	}
+
+	f := fileAst.FindFuncDecl(Cursor{1, 1})
+	if f == nil || f.Name.Value != "f" {
+		t.Fatalf("expected function f, got %v", f)
+	}
+	if got := names(fileAst.CallsIn(f)); len(got) != 3 || got[0] != "g" || got[1] != "j" || got[2] != "k" {
+		t.Errorf("CallsIn(f) = %v, want [g j k]", got)
+	}
+	h := fileAst.FindFuncDecl(Cursor{3, 3})
+	if got := names(fileAst.CallsIn(h)); len(got) != 1 || got[0] != "i" {
+		t.Errorf("CallsIn(h) = %v, want [i]", got)
+	}
+	if got := names(fileAst.CallsIn(nil)); len(got) != 1 || got[0] != "f" {
+		t.Errorf("CallsIn(nil) = %v, want [f]", got)
+	}
+	if fileAst.FindFuncDecl(Cursor{2, 3}) != nil {
+		t.Error("expected no function declaration at a call")
+	}
+}
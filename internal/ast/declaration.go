@@ -0,0 +1,75 @@
+package ast
+
+import (
+	"log/slog"
+
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// Find the declaration of the identifier under cursor, which introduces the
+// name instead of assigning the value that reaches the cursor: the closest
+// `local` or `declare` in the enclosing function, else the first global
+// definition, like an assignment, `declare`, `readonly` or `export`
+func (a *Ast) FindDeclInFile(cursor Cursor) *DefNode {
+	targetIdentifier := ExtractIdentifier(a.FindNodeUnderCursor(cursor))
+	if targetIdentifier == "" {
+		return nil
+	}
+
+	cursorScope := a.scopes.scopeAt(cursor)
+	var closestLocalDecl *DefNode
+	for _, defNode := range a.DefNodes() {
+		if defNode.Name == targetIdentifier && defNode.IsScoped && defNode.Scope.encloses(cursorScope) &&
+			defNode.isDeclaration() && defNode.isBeforeCursor(cursor) &&
+			(closestLocalDecl == nil || defNode.isDefinitionAfter(closestLocalDecl)) {
+			closestLocalDecl = &defNode
+		}
+	}
+	if closestLocalDecl != nil {
+		return closestLocalDecl
+	}
+
+	return a.findGlobalDecl(targetIdentifier)
+}
+
+// Find the global declaration of the identifier under cursor in sourcedFiles,
+// which are the files sourced directly or indirectly in source order. The
+// declaration is the one of the first file declaring it.
+func (a *Ast) FindDeclInSourcedFile(cursor Cursor, sourcedFiles []string, parseFile FileParser) (string, *DefNode) {
+	targetIdentifier := ExtractIdentifier(a.FindNodeUnderCursor(cursor))
+	if targetIdentifier == "" {
+		return "", nil
+	}
+
+	for _, sourcedFile := range sourcedFiles {
+		sourcedAst, err := parseFile(sourcedFile)
+		if err != nil {
+			slog.Error("Could not parse file", "file", sourcedFile)
+			continue
+		}
+		if decl := sourcedAst.findGlobalDecl(targetIdentifier); decl != nil {
+			return sourcedFile, decl
+		}
+	}
+
+	return "", nil
+}
+
+// First global definition of name by position, which introduces it whether
+// it is a declaration command or an assignment
+func (a *Ast) findGlobalDecl(name string) *DefNode {
+	var firstDef *DefNode
+	for _, defNode := range a.DefNodes() {
+		if defNode.Name == name && !defNode.IsScoped &&
+			(firstDef == nil || firstDef.isDefinitionAfter(&defNode)) {
+			firstDef = &defNode
+		}
+	}
+	return firstDef
+}
+
+// Whether the definition is a declaration command like `declare` or `export`
+func (d *DefNode) isDeclaration() bool {
+	_, ok := d.Node.(*syntax.DeclClause)
+	return ok
+}
@@ -0,0 +1,47 @@
+package ast
+
+import "testing"
+
+func Test_FindDeclInFile(t *testing.T) {
+	input := `
+readonly CONFIG=/etc/app
+CONFIG=/tmp/app
+count=0
+count=1
+greet() {
+  local name=world
+  name=you
+  echo "$name $count $CONFIG"
+  declare -g greeted=true
+}
+echo "$greeted"
+limit=1
+declare -r limit
+echo "$limit"
+`
+	fileAst, _ := ParseDocument(input, "", false)
+
+	tests := []struct {
+		name      string
+		cursor    Cursor
+		startLine uint
+	}{
+		{"local declaration", NewCursor(8, 9), 7},
+		{"first global assignment", NewCursor(8, 15), 4},
+		{"readonly", NewCursor(8, 22), 2},
+		{"global declaration in function", NewCursor(11, 7), 10},
+		{"assignment before declaration", NewCursor(14, 7), 13},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decl := fileAst.FindDeclInFile(tt.cursor)
+			if decl == nil {
+				t.Fatal("expected a declaration")
+			}
+			if decl.StartLine != tt.startLine {
+				t.Errorf("expected declaration at line %d, got %d", tt.startLine, decl.StartLine)
+			}
+		})
+	}
+}
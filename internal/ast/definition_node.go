@@ -1,15 +1,18 @@
 package ast
 
 import (
+	"cmp"
+	"slices"
 	"strings"
 
+	"github.com/matkrin/bashd/internal/lsp"
 	"mvdan.cc/sh/v3/syntax"
 )
 
 type DefNode struct {
 	Node      syntax.Node
 	Name      string
-	Scope     *syntax.FuncDecl
+	Scope     *Scope
 	IsScoped  bool
 	StartLine uint
 	StartChar uint
@@ -17,14 +20,27 @@ type DefNode struct {
 	EndChar   uint
 }
 
-func (d *DefNode) isBeforeCursor(cursor Cursor) bool {
-	if d.StartLine <= cursor.Line {
-		return true
+// Location of the definition, mapper converts the byte columns of the file
+// with uri to the negotiated position encoding
+func (d *DefNode) ToLspLocation(uri string, mapper *lsp.Mapper) lsp.Location {
+	return lsp.Location{
+		URI: uri,
+		Range: mapper.ByteRange(
+			d.StartLine-1,
+			d.StartChar-1,
+			d.EndLine-1,
+			d.EndChar-1,
+		),
 	}
-	if d.StartLine == cursor.Line && d.StartChar < cursor.Col {
-		return true
-	}
-	return false
+}
+
+// Whether the definition starts before cursor, or at it
+func (d *DefNode) isBeforeCursor(cursor Cursor) bool {
+	return d.StartLine < cursor.Line || d.StartLine == cursor.Line && d.StartChar <= cursor.Col
+}
+
+func (d *DefNode) isAfterCursor(cursor Cursor) bool {
+	return d.StartLine > cursor.Line || d.StartLine == cursor.Line && d.StartChar > cursor.Col
 }
 
 func (d *DefNode) isDefinitionAfter(otherDef *DefNode) bool {
@@ -37,6 +53,21 @@ func (d *DefNode) isDefinitionAfter(otherDef *DefNode) bool {
 	return false
 }
 
+// Whether the definition is a declaration without value, like `export NAME`
+// or `local NAME`
+func (d *DefNode) isNakedDeclaration() bool {
+	declClause, ok := d.Node.(*syntax.DeclClause)
+	if !ok {
+		return false
+	}
+	for _, arg := range declClause.Args {
+		if arg.Name != nil && arg.Name.Pos().Line() == d.StartLine && arg.Name.Pos().Col() == d.StartChar {
+			return arg.Naked
+		}
+	}
+	return false
+}
+
 func (d *DefNode) isSameDefinition(def2 *DefNode) bool {
 	return d.StartLine == def2.StartLine &&
 		d.StartChar == def2.StartChar &&
@@ -55,41 +86,182 @@ func (a *Ast) DefNodes() []DefNode {
 		return descent
 	})
 
+	for i := range defNodes {
+		defNodes[i].Scope = a.defScope(&defNodes[i])
+	}
+
 	return defNodes
 }
 
-func (a *Ast) FindDefInFile(cursor Cursor) *DefNode {
+// Find the definitions of the identifier under cursor that may reach it: the
+// closest local declaration in the enclosing function, or else the global
+// definitions and the local declarations of the calling functions in source
+// order, of which there may be several when the identifier is defined
+// conditionally. Definitions in subshells do not reach the parent shell.
+func (a *Ast) FindDefInFile(cursor Cursor) []DefNode {
 	cursorNode := a.FindNodeUnderCursor(cursor)
 	targetIdentifier := ExtractIdentifier(cursorNode)
 	if targetIdentifier == "" {
 		return nil
 	}
 
-	cursorScope := a.findEnclosingFunction(cursor)
+	if closestLocalDef := a.closestLocalDef(targetIdentifier, cursor); closestLocalDef != nil {
+		return []DefNode{*closestLocalDef}
+	}
 
-	// Find scoped variables in the same function scope as cursor
-	if cursorScope != nil {
-		for _, defNode := range a.DefNodes() {
-			if defNode.Name == targetIdentifier && defNode.IsScoped && defNode.Scope == cursorScope {
-				// Check if the scoped variable is declared before the cursor position (shadowing)
-				if defNode.isBeforeCursor(cursor) {
-					return &defNode
-				}
-			}
+	// Global definitions i.e., functions and non-scoped variables, and due to
+	// dynamic scoping local variables of the calling functions
+	defNodes := append(
+		a.reachingGlobalDefs(targetIdentifier, cursor),
+		a.dynamicLocalDefs(targetIdentifier, cursor)...,
+	)
+	slices.SortStableFunc(defNodes, func(d1, d2 DefNode) int {
+		return cmp.Or(cmp.Compare(d1.StartLine, d2.StartLine), cmp.Compare(d1.StartChar, d2.StartChar))
+	})
+	return defNodes
+}
+
+// Global definitions of name that may reach cursor, in source order. A
+// definition does not reach the cursor if it is made in a subshell the cursor
+// is not in, if it comes after the cursor and cannot run before it, or if a
+// later definition, which always runs after it, comes before the cursor.
+// Definitions in functions run when the function is called, so only later
+// definitions in the same function overwrite them.
+func (a *Ast) reachingGlobalDefs(name string, cursor Cursor) []DefNode {
+	cursorScope := a.scopes.scopeAt(cursor)
+
+	var globalDefs []DefNode
+	for _, defNode := range a.DefNodes() {
+		if defNode.Name == name && !defNode.IsScoped && defNode.Scope.encloses(cursorScope) &&
+			(!defNode.isAfterCursor(cursor) || a.mayRunBefore(&defNode, cursor)) {
+			globalDefs = append(globalDefs, defNode)
 		}
 	}
+	if len(globalDefs) <= 1 {
+		return globalDefs
+	}
 
-	// Find global definitions i.e., functions and non-scoped variables
-	for _, defNode := range a.DefNodes() {
-		if defNode.Name == targetIdentifier {
-			if defNode.IsScoped {
+	stmtLists := a.stmtLists()
+	var reaching []DefNode
+	for i, defNode := range globalDefs {
+		overwritten := false
+		defFunction := a.definingFunction(&defNode)
+		for _, later := range globalDefs[i+1:] {
+			// Like `export NAME`, which keeps the value
+			if later.isNakedDeclaration() {
+				continue
+			}
+			if defFunction != nil && a.definingFunction(&later) != defFunction {
 				continue
 			}
-			return &defNode
+			stmt, list := findDefiningStmt(&later, stmtLists)
+			if stmt == nil || !cursor.isAfter(stmt.End()) {
+				continue
+			}
+			// Overwritten if it runs earlier in the same statement list, or
+			// anywhere earlier if the cursor is in the statement list
+			cursorInList := &list[0] == &a.File.Stmts[0] ||
+				cursor.isAfter(list[0].Pos()) && !cursor.isAfter(list[len(list)-1].End())
+			if (cursorInList || list[0].Pos().Offset() <= defNode.Node.Pos().Offset()) &&
+				defNode.Node.Pos().Offset() < stmt.Pos().Offset() {
+				overwritten = true
+				break
+			}
+		}
+		if !overwritten {
+			reaching = append(reaching, defNode)
+		}
+	}
+	return reaching
+}
+
+// Whether the definition, which comes after cursor, may run before the code at
+// cursor: the definition is made in a function, which may be called before,
+// the cursor is in a function, which may be called after the definition, or
+// both are in a loop, whose next iteration runs after the definition
+func (a *Ast) mayRunBefore(defNode *DefNode, cursor Cursor) bool {
+	if a.definingFunction(defNode) != nil || a.scopes.scopeAt(cursor).Function() != nil {
+		return true
+	}
+
+	defCursor := Cursor{Line: defNode.StartLine, Col: defNode.StartChar}
+	inLoop := false
+	syntax.Walk(a.File, func(node syntax.Node) bool {
+		switch node.(type) {
+		case *syntax.WhileClause, *syntax.ForClause:
+			if cursor.isCursorInNode(node) && defCursor.isCursorInNode(node) {
+				inLoop = true
+			}
 		}
+		return !inLoop
+	})
+	return inLoop
+}
+
+// Function whose body makes the definition, nil for definitions outside of
+// functions
+func (a *Ast) definingFunction(defNode *DefNode) *syntax.FuncDecl {
+	defScope := a.scopes.scopeAt(Cursor{Line: defNode.StartLine, Col: defNode.StartChar})
+	// A function declaration is made in the scope around the function
+	if defScope.Node == defNode.Node {
+		defScope = defScope.Parent
 	}
+	return defScope.Function()
+}
+
+// Statement lists of the file, like the bodies of blocks and branches
+func (a *Ast) stmtLists() [][]*syntax.Stmt {
+	lists := [][]*syntax.Stmt{a.File.Stmts}
+	syntax.Walk(a.File, func(node syntax.Node) bool {
+		switch n := node.(type) {
+		case *syntax.Block:
+			lists = append(lists, n.Stmts)
+		case *syntax.Subshell:
+			lists = append(lists, n.Stmts)
+		case *syntax.IfClause:
+			lists = append(lists, n.Cond, n.Then)
+		case *syntax.WhileClause:
+			lists = append(lists, n.Cond, n.Do)
+		case *syntax.ForClause:
+			lists = append(lists, n.Do)
+		case *syntax.CaseItem:
+			lists = append(lists, n.Stmts)
+		case *syntax.CmdSubst:
+			lists = append(lists, n.Stmts)
+		}
+		return true
+	})
+	return lists
+}
 
-	return nil
+// Statement that always runs the definition when it runs, and the statement
+// list it is part of. Nil for definitions that might not run, like loop
+// variables, or that do not persist, like assignments prefixing a command.
+func findDefiningStmt(defNode *DefNode, stmtLists [][]*syntax.Stmt) (*syntax.Stmt, []*syntax.Stmt) {
+	for _, list := range stmtLists {
+		for _, stmt := range list {
+			if stmt.Background || stmt.Coprocess {
+				continue
+			}
+			switch cmd := stmt.Cmd.(type) {
+			case *syntax.CallExpr:
+				if len(cmd.Args) == 0 {
+					for _, assign := range cmd.Assigns {
+						if syntax.Node(assign) == defNode.Node {
+							return stmt, list
+						}
+					}
+				} else if syntax.Node(cmd) == defNode.Node {
+					return stmt, list
+				}
+			case *syntax.DeclClause, *syntax.FuncDecl:
+				if syntax.Node(cmd) == defNode.Node {
+					return stmt, list
+				}
+			}
+		}
+	}
+	return nil, nil
 }
 
 func syntaxNodeToDefNode(node syntax.Node, scope *syntax.FuncDecl) ([]DefNode, bool) {
@@ -108,12 +280,12 @@ func syntaxNodeToDefNode(node syntax.Node, scope *syntax.FuncDecl) ([]DefNode, b
 		descent = false
 
 	case *syntax.ForClause:
-		if defNode := forClauseToDefNode(n, scope); defNode != nil {
+		if defNode := forClauseToDefNode(n); defNode != nil {
 			defNodes = append(defNodes, *defNode)
 		}
 
 	case *syntax.CallExpr:
-		if nodes := callExprToDefNode(n, scope); len(nodes) > 0 {
+		if nodes := callExprToDefNode(n); len(nodes) > 0 {
 			defNodes = append(defNodes, nodes...)
 		}
 
@@ -146,7 +318,6 @@ func assignToDefNode(assignNode *syntax.Assign) *DefNode {
 	return &DefNode{
 		Node:      assignNode,
 		Name:      name,
-		Scope:     nil,
 		IsScoped:  false,
 		StartLine: startLine,
 		StartChar: startChar,
@@ -168,7 +339,6 @@ func funcDeclToDefNode(funcDecl *syntax.FuncDecl) *DefNode {
 	return &DefNode{
 		Node:      funcDecl,
 		Name:      name,
-		Scope:     nil,
 		IsScoped:  false,
 		StartLine: startLine,
 		StartChar: startChar,
@@ -178,8 +348,15 @@ func funcDeclToDefNode(funcDecl *syntax.FuncDecl) *DefNode {
 }
 
 func declClauseToDefNode(declClause *syntax.DeclClause, scope *syntax.FuncDecl) []DefNode {
-	cmd := declClause.Variant.Value
-	if cmd != "local" && cmd != "declare" && cmd != "typeset" {
+	// `export` and `readonly` declare global variables, like `declare -g`
+	switch declClause.Variant.Value {
+	case "local", "declare", "typeset":
+		if declClauseHasOption(declClause, 'g') {
+			scope = nil
+		}
+	case "export", "readonly":
+		scope = nil
+	default:
 		return nil
 	}
 
@@ -193,7 +370,6 @@ func declClauseToDefNode(declClause *syntax.DeclClause, scope *syntax.FuncDecl)
 			defNodes = append(defNodes, DefNode{
 				Node:      declClause,
 				Name:      name,
-				Scope:     scope,
 				IsScoped:  scope != nil,
 				StartLine: startLine,
 				StartChar: startChar,
@@ -205,7 +381,22 @@ func declClauseToDefNode(declClause *syntax.DeclClause, scope *syntax.FuncDecl)
 	return defNodes
 }
 
-func forClauseToDefNode(forClause *syntax.ForClause, scope *syntax.FuncDecl) *DefNode {
+// Whether the declaration clause has an option like `-g`, possibly combined
+// with others like in `-gr`
+func declClauseHasOption(declClause *syntax.DeclClause, option byte) bool {
+	for _, arg := range declClause.Args {
+		if arg.Name != nil || arg.Value == nil {
+			continue
+		}
+		flag := ExtractIdentifier(arg.Value)
+		if strings.HasPrefix(flag, "-") && strings.IndexByte(flag[1:], option) >= 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func forClauseToDefNode(forClause *syntax.ForClause) *DefNode {
 	var name string
 	var startLine, startChar, endLine, endChar uint
 
@@ -246,11 +437,11 @@ func forClauseToDefNode(forClause *syntax.ForClause, scope *syntax.FuncDecl) *De
 		return nil
 	}
 
+	// Loop variables are global, even in functions
 	return &DefNode{
 		Node:      forClause,
 		Name:      name,
-		Scope:     scope,
-		IsScoped:  scope != nil,
+		IsScoped:  false,
 		StartLine: startLine,
 		StartChar: startChar,
 		EndLine:   endLine,
@@ -258,7 +449,9 @@ func forClauseToDefNode(forClause *syntax.ForClause, scope *syntax.FuncDecl) *De
 	}
 }
 
-func callExprToDefNode(callExpr *syntax.CallExpr, scope *syntax.FuncDecl) []DefNode {
+// Variables assigned by `read`, which are global like plain assignments unless
+// declared local before
+func callExprToDefNode(callExpr *syntax.CallExpr) []DefNode {
 	if len(callExpr.Args) < 2 {
 		return nil
 	}
@@ -279,8 +472,7 @@ func callExprToDefNode(callExpr *syntax.CallExpr, scope *syntax.FuncDecl) []DefN
 		defNodes = append(defNodes, DefNode{
 			Node:      callExpr,
 			Name:      name,
-			Scope:     scope,
-			IsScoped:  scope != nil,
+			IsScoped:  false,
 			StartLine: startLine,
 			StartChar: startChar,
 			EndLine:   endLine,
@@ -1,6 +1,7 @@
 package ast
 
 import (
+	"slices"
 	"testing"
 )
 
@@ -92,7 +93,11 @@ echo "$a $b $c"
 	}
 
 	for _, e := range tests {
-		defNode := fileAst.FindDefInFile(e.cursor)
+		defNodes := fileAst.FindDefInFile(e.cursor)
+		if len(defNodes) != 1 {
+			t.Fatalf("expected 1 definition, got %d", len(defNodes))
+		}
+		defNode := defNodes[0]
 		if defNode.Name != e.name {
 			t.Errorf("expected '%s', got '%s'", e.name, defNode.Name)
 		}
@@ -104,3 +109,98 @@ echo "$a $b $c"
 		}
 	}
 }
+
+func Test_FindDefInFileConditional(t *testing.T) {
+	input := `
+if [[ $OSTYPE == darwin* ]]; then
+  sed_i() { sed -i '' "$@"; }
+  mode=bsd
+else
+  sed_i() { sed -i "$@"; }
+  mode=gnu
+fi
+sed_i "$mode"
+mode=fixed
+echo "$mode"
+`
+	fileAst, _ := ParseDocument(input, "", false)
+
+	tests := []struct {
+		cursor     Cursor
+		startLines []uint
+	}{
+		// Both branches reach
+		{NewCursor(8, 1), []uint{3, 6}},
+		{NewCursor(8, 9), []uint{4, 7}},
+		// Overwritten unconditionally
+		{NewCursor(10, 8), []uint{10}},
+	}
+
+	for _, tt := range tests {
+		var startLines []uint
+		for _, defNode := range fileAst.FindDefInFile(tt.cursor) {
+			startLines = append(startLines, defNode.StartLine)
+		}
+		if !slices.Equal(startLines, tt.startLines) {
+			t.Errorf("expected definitions at lines %v, got %v", tt.startLines, startLines)
+		}
+	}
+}
+
+func Test_FindDefInFileLater(t *testing.T) {
+	input := `
+echo "$count"
+while read -r line; do
+  echo "$count"
+  count=$((count + 1))
+done
+show() {
+  echo "$count"
+}
+count=0
+`
+	fileAst, _ := ParseDocument(input, "", false)
+
+	tests := []struct {
+		name       string
+		cursor     Cursor
+		startLines []uint
+	}{
+		{"top-level", NewCursor(1, 7), nil},
+		{"loop", NewCursor(3, 9), []uint{5}},
+		{"function", NewCursor(7, 9), []uint{5, 10}},
+	}
+
+	for _, tt := range tests {
+		var startLines []uint
+		for _, defNode := range fileAst.FindDefInFile(tt.cursor) {
+			startLines = append(startLines, defNode.StartLine)
+		}
+		if !slices.Equal(startLines, tt.startLines) {
+			t.Errorf("%s: expected definitions at lines %v, got %v", tt.name, tt.startLines, startLines)
+		}
+	}
+}
+
+func Test_FindDefInFileCalledFunction(t *testing.T) {
+	input := `
+f() {
+  x=1
+  x=3
+}
+x=2
+f
+echo "$x"
+`
+	fileAst, _ := ParseDocument(input, "", false)
+
+	// The function runs after the reassignment, only its last definition
+	// overwrites its first one
+	var startLines []uint
+	for _, defNode := range fileAst.FindDefInFile(NewCursor(7, 7)) {
+		startLines = append(startLines, defNode.StartLine)
+	}
+	if want := []uint{4, 6}; !slices.Equal(startLines, want) {
+		t.Errorf("expected definitions at lines %v, got %v", want, startLines)
+	}
+}
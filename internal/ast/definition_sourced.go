@@ -2,63 +2,80 @@ package ast
 
 import (
 	"log/slog"
-	"os"
 )
 
 func (a *Ast) FindDefinitionAcrossFiles(
 	cursor Cursor,
-	env map[string]string,
-	baseDir string,
-) (string, *DefNode) {
-	if def := a.FindDefInFile(cursor); def != nil {
-		return "", def
+	sourcedFiles []string,
+	parseFile FileParser,
+) (string, []DefNode) {
+	if defs := a.FindDefInFile(cursor); len(defs) > 0 {
+		return "", defs
 	}
 
-	return a.FindDefInSourcedFile(cursor, env, baseDir)
+	return a.FindDefInSourcedFile(cursor, sourcedFiles, parseFile)
 }
 
+// Find the global definitions of the identifier under cursor in sourcedFiles,
+// which are the files sourced directly or indirectly in source order. The
+// definitions are the ones reaching the end of the last file defining it.
 func (a *Ast) FindDefInSourcedFile(
 	cursor Cursor,
-	env map[string]string,
-	baseDir string,
-) (string, *DefNode) {
+	sourcedFiles []string,
+	parseFile FileParser,
+) (string, []DefNode) {
 	cursorNode := a.FindNodeUnderCursor(cursor)
 	targetIdentifier := ExtractIdentifier(cursorNode)
 	if targetIdentifier == "" {
 		return "", nil
 	}
 
-	sourcedFiles := a.FindAllSourcedFiles(env, baseDir, map[string]bool{})
-
 	// Search for globals in reverse source order (last sourced file first)
 	for i := len(sourcedFiles) - 1; i >= 0; i-- {
-		file, def := findGlobalInSourcedFile(targetIdentifier, sourcedFiles[i])
-		if def != nil {
-			return file, def
+		defs := findGlobalsInSourcedFile(targetIdentifier, sourcedFiles[i], parseFile)
+		if len(defs) > 0 {
+			return sourcedFiles[i], defs
 		}
 	}
 
 	return "", nil
 }
 
-func findGlobalInSourcedFile(targetIdentifier, sourcedFile string) (string, *DefNode) {
-	fileContent, err := os.ReadFile(sourcedFile)
+func findGlobalsInSourcedFile(targetIdentifier, sourcedFile string, parseFile FileParser) []DefNode {
+	sourcedAst, err := parseFile(sourcedFile)
 	if err != nil {
-		slog.Error("Could not read file", "file", sourcedFile)
-		return "", nil
+		slog.Error("Could not parse file", "file", sourcedFile)
+		return nil
 	}
 
-	sourcedAst, err := ParseDocument(string(fileContent), sourcedFile, false)
-	if err != nil {
-		slog.Error("Could not parse file", "file", sourcedFile)
-		return "", nil
+	end := sourcedAst.File.End()
+	return sourcedAst.reachingGlobalDefs(targetIdentifier, Cursor{Line: end.Line(), Col: end.Col()})
+}
+
+// Find the global definitions of the identifier under cursor in files, which
+// provide the context of the current file at runtime, like the files sourcing
+// it. All definitions are candidates, as it is unknown which file sources the
+// current file.
+func (a *Ast) FindDefsInSourcingFiles(cursor Cursor, files []string, parseFile FileParser) map[string][]DefNode {
+	definitions := map[string][]DefNode{}
+	targetIdentifier := ExtractIdentifier(a.FindNodeUnderCursor(cursor))
+	if targetIdentifier == "" {
+		return definitions
 	}
 
-	for _, defNode := range sourcedAst.DefNodes() {
-		if defNode.Name == targetIdentifier && !defNode.IsScoped {
-			return sourcedFile, &defNode
+	for _, file := range files {
+		fileAst, err := parseFile(file)
+		if err != nil {
+			slog.Error("Could not parse file", "file", file)
+			continue
+		}
+
+		for _, defNode := range fileAst.DefNodes() {
+			if defNode.Name == targetIdentifier && !defNode.IsScoped {
+				definitions[file] = append(definitions[file], defNode)
+			}
 		}
 	}
 
-	return "", nil
+	return definitions
 }
@@ -0,0 +1,157 @@
+package ast
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// Shell dialect of a script. The empty dialect is unknown and parsed as Bash.
+type Dialect string
+
+const (
+	DialectBash    Dialect = "bash"
+	DialectSh      Dialect = "sh"
+	DialectDash    Dialect = "dash"
+	DialectBusybox Dialect = "busybox"
+	DialectKsh     Dialect = "ksh"
+	DialectMksh    Dialect = "mksh"
+	DialectBats    Dialect = "bats"
+)
+
+var Dialects = []Dialect{
+	DialectBash, DialectSh, DialectDash, DialectBusybox, DialectKsh, DialectMksh, DialectBats,
+}
+
+// Dialect of a shell, like the interpreter of a shebang or the value of a
+// `# shellcheck shell=` directive, empty if it is not a known shell
+func dialectOfShell(shell string) Dialect {
+	switch shell {
+	case "bash":
+		return DialectBash
+	case "sh":
+		return DialectSh
+	case "dash":
+		return DialectDash
+	case "ash", "busybox":
+		return DialectBusybox
+	case "ksh", "ksh88", "ksh93":
+		return DialectKsh
+	case "mksh", "pdksh", "oksh", "lksh":
+		return DialectMksh
+	case "bats":
+		return DialectBats
+	}
+	return ""
+}
+
+// Detect the dialect of the script at path, which may also be a URI, from a
+// file-wide `# shellcheck shell=` directive, else the shebang, else the
+// extension, like ShellCheck does. Empty if it is unknown.
+func DetectDialect(documentText, path string) Dialect {
+	if dialect := directiveDialect(documentText); dialect != "" {
+		return dialect
+	}
+	if dialect := shebangDialect(documentText); dialect != "" {
+		return dialect
+	}
+	switch filepath.Ext(path) {
+	case ".bash":
+		return DialectBash
+	case ".dash":
+		return DialectDash
+	case ".ksh":
+		return DialectKsh
+	case ".mksh":
+		return DialectMksh
+	case ".bats":
+		return DialectBats
+	}
+	return ""
+}
+
+// Dialect of the shebang, like `#!/bin/sh` or `#!/usr/bin/env -S bash -e`
+func shebangDialect(documentText string) Dialect {
+	line, _, _ := strings.Cut(documentText, "\n")
+	interpreter, ok := strings.CutPrefix(line, "#!")
+	if !ok {
+		return ""
+	}
+
+	fields := strings.Fields(interpreter)
+	if len(fields) == 0 {
+		return ""
+	}
+	shell := filepath.Base(fields[0])
+	args := fields[1:]
+	if shell == "env" {
+		// The command of env follows its options and variable assignments
+		i := slices.IndexFunc(args, func(arg string) bool {
+			return !strings.HasPrefix(arg, "-") && !strings.Contains(arg, "=")
+		})
+		if i < 0 {
+			return ""
+		}
+		shell = filepath.Base(args[i])
+		args = args[i+1:]
+	}
+	// Like `#!/bin/busybox sh`
+	if shell == "busybox" && (len(args) == 0 || args[0] != "sh" && args[0] != "ash") {
+		return ""
+	}
+	return dialectOfShell(shell)
+}
+
+// Dialect of a `# shellcheck shell=` directive in the comments before the
+// first command, which apply to the whole file
+func directiveDialect(documentText string) Dialect {
+	for line := range strings.Lines(documentText) {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		comment, ok := strings.CutPrefix(line, "#")
+		if !ok {
+			break
+		}
+		fields := strings.Fields(comment)
+		if len(fields) == 0 || fields[0] != "shellcheck" {
+			continue
+		}
+		for _, field := range fields[1:] {
+			if shell, ok := strings.CutPrefix(field, "shell="); ok {
+				return dialectOfShell(shell)
+			}
+		}
+	}
+	return ""
+}
+
+// Language variant the dialect is parsed with
+func (d Dialect) LangVariant() syntax.LangVariant {
+	switch d {
+	case DialectSh, DialectDash, DialectBusybox:
+		return syntax.LangPOSIX
+	case DialectKsh, DialectMksh:
+		return syntax.LangMirBSDKorn
+	case DialectBats:
+		return syntax.LangBats
+	default:
+		return syntax.LangBash
+	}
+}
+
+// Value of ShellCheck's `--shell` option for the dialect, empty for the
+// unknown dialect. ShellCheck checks Bats tests as Bash and knows ksh only.
+func (d Dialect) ShellCheckShell() string {
+	switch d {
+	case DialectBats:
+		return string(DialectBash)
+	case DialectMksh:
+		return string(DialectKsh)
+	default:
+		return string(d)
+	}
+}
@@ -0,0 +1,43 @@
+package ast
+
+import "testing"
+
+func Test_DetectDialect(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		path  string
+		want  Dialect
+	}{
+		{"sh shebang", "#!/bin/sh\necho", "/project/entrypoint", DialectSh},
+		{"env shebang", "#!/usr/bin/env bash\necho", "", DialectBash},
+		{"env options", "#!/usr/bin/env -S mksh -e\necho", "", DialectMksh},
+		{"busybox shebang", "#!/bin/busybox sh\necho", "", DialectBusybox},
+		{"unknown shebang", "#!/usr/bin/python3\nprint()", "/project/x.sh", ""},
+		{"extension", "echo", "file:///project/test.bats", DialectBats},
+		{"no extension", "echo", "/project/x.sh", ""},
+		{"directive", "#!/bin/bash\n# shellcheck shell=dash\necho", "", DialectDash},
+		{"directive after command", "#!/bin/sh\necho\n# shellcheck shell=bash\necho", "", DialectSh},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DetectDialect(tt.input, tt.path); got != tt.want {
+				t.Errorf("DetectDialect() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_ParseDocumentDialect(t *testing.T) {
+	input := "arr=(a b)\n"
+	if _, err := ParseDocument(input, "script.bash", false); err != nil {
+		t.Errorf("expected Bash to parse arrays, got %v", err)
+	}
+	if _, err := ParseDocument("#!/bin/sh\n"+input, "", false); err == nil {
+		t.Error("expected POSIX shell to reject arrays")
+	}
+	if _, err := ParseDocumentAs("#!/bin/sh\n"+input, "", false, DialectBash); err != nil {
+		t.Errorf("expected the dialect to override the shebang, got %v", err)
+	}
+}
@@ -1,26 +1,33 @@
 package ast
 
 import (
+	"slices"
 	"strconv"
+	"strings"
 
 	"github.com/matkrin/bashd/internal/lsp"
 	"mvdan.cc/sh/v3/syntax"
 )
 
 type RefNode struct {
-	Node      syntax.Node
-	Name      string
-	Scope     *syntax.FuncDecl
+	Node  syntax.Node
+	Name  string
+	Scope *Scope
+	// Whether the reference assigns the variable, like an assignment, `read`
+	// or `((x++))`, or declares the variable or function
+	IsWrite   bool
 	StartLine uint
 	StartChar uint
 	EndLine   uint
 	EndChar   uint
 }
 
-func (r *RefNode) ToLspLocation(uri string) lsp.Location {
+// Location of the reference, mapper converts the byte columns of the file with
+// uri to the negotiated position encoding
+func (r *RefNode) ToLspLocation(uri string, mapper *lsp.Mapper) lsp.Location {
 	return lsp.Location{
 		URI: uri,
-		Range: lsp.NewRange(
+		Range: mapper.ByteRange(
 			r.StartLine-1,
 			r.StartChar-1,
 			r.EndLine-1,
@@ -29,9 +36,9 @@ func (r *RefNode) ToLspLocation(uri string) lsp.Location {
 	}
 }
 
-func (r *RefNode) ToLspTextEdit(newText string) lsp.TextEdit {
+func (r *RefNode) ToLspTextEdit(newText string, mapper *lsp.Mapper) lsp.TextEdit {
 	return lsp.TextEdit{
-		Range: lsp.NewRange(
+		Range: mapper.ByteRange(
 			r.StartLine-1,
 			r.StartChar-1,
 			r.EndLine-1,
@@ -50,10 +57,14 @@ func paramExpToRefNode(paramExp *syntax.ParamExp) *RefNode {
 	startLine, startChar := paramExp.Param.Pos().Line(), paramExp.Param.Pos().Col()
 	endLine, endChar := paramExp.Param.End().Line(), paramExp.Param.End().Col()
 
+	// Like `${x:=default}`
+	isWrite := paramExp.Exp != nil &&
+		(paramExp.Exp.Op == syntax.AssignUnset || paramExp.Exp.Op == syntax.AssignUnsetOrNull)
+
 	return &RefNode{
 		Node:      paramExp,
 		Name:      name,
-		Scope:     nil,
+		IsWrite:   isWrite,
 		StartLine: startLine,
 		StartChar: startChar,
 		EndLine:   endLine,
@@ -65,7 +76,7 @@ func (a *Ast) RefNodes(includeDeclaration bool) []RefNode {
 	refNodes := []RefNode{}
 
 	syntax.Walk(a.File, func(node syntax.Node) bool {
-		nodes, descent := syntaxNodeToRefNode(node, nil, includeDeclaration)
+		nodes, descent := syntaxNodeToRefNode(node, includeDeclaration)
 		if len(nodes) > 0 {
 			refNodes = append(refNodes, nodes...)
 		}
@@ -73,6 +84,10 @@ func (a *Ast) RefNodes(includeDeclaration bool) []RefNode {
 		return descent
 	})
 
+	for i := range refNodes {
+		refNodes[i].Scope = a.refScope(&refNodes[i])
+	}
+
 	return refNodes
 }
 
@@ -85,11 +100,12 @@ func (a *Ast) FindRefsInFile(cursor Cursor, includeDeclaration bool) []RefNode {
 
 	references := []RefNode{}
 
-	defNode := a.FindDefInFile(cursor)
+	// All definitions found are of the same variable or function
+	defNodes := a.FindDefInFile(cursor)
 
 	allRefNodes := a.RefNodes(includeDeclaration)
 
-	if defNode == nil {
+	if len(defNodes) == 0 {
 		// No definition found - return all references with same name (fallback)
 		for _, refNode := range allRefNodes {
 			if refNode.Name == targetIdentifier {
@@ -104,7 +120,7 @@ func (a *Ast) FindRefsInFile(cursor Cursor, includeDeclaration bool) []RefNode {
 			continue
 		}
 
-		if a.wouldResolveToSameDefinition(refNode.Node, defNode) {
+		if a.wouldResolveToSameDefinition(&refNode, &defNodes[0]) {
 			references = append(references, refNode)
 		}
 	}
@@ -112,7 +128,7 @@ func (a *Ast) FindRefsInFile(cursor Cursor, includeDeclaration bool) []RefNode {
 	return references
 }
 
-func syntaxNodeToRefNode(node syntax.Node, scope *syntax.FuncDecl, includeDeclaration bool) ([]RefNode, bool) {
+func syntaxNodeToRefNode(node syntax.Node, includeDeclaration bool) ([]RefNode, bool) {
 	descent := true
 	var refNodes []RefNode
 	switch n := node.(type) {
@@ -122,33 +138,25 @@ func syntaxNodeToRefNode(node syntax.Node, scope *syntax.FuncDecl, includeDeclar
 		}
 
 	case *syntax.DeclClause:
-		if nodes := declClauseToRefNode(n, scope, includeDeclaration); len(nodes) > 0 {
+		if nodes := declClauseToRefNode(n, includeDeclaration); len(nodes) > 0 {
 			refNodes = append(refNodes, nodes...)
 		}
 		descent = false
 
 	case *syntax.ForClause:
-		if nodes := forClauseToRefNode(n, scope, includeDeclaration); len(nodes) > 0 {
+		if nodes := forClauseToRefNode(n, includeDeclaration); len(nodes) > 0 {
 			refNodes = append(refNodes, nodes...)
 		}
 
 	case *syntax.CallExpr:
-		if refNode := callExprToRefNode(n, scope, includeDeclaration); refNode != nil {
-			refNodes = append(refNodes, *refNode)
+		if nodes := callExprToRefNode(n, includeDeclaration); len(nodes) > 0 {
+			refNodes = append(refNodes, nodes...)
 		}
 
 	case *syntax.FuncDecl:
 		if refNode := funcDeclToRefNode(n, includeDeclaration); refNode != nil {
 			refNodes = append(refNodes, *refNode)
 		}
-		syntax.Walk(n.Body, func(innerNode syntax.Node) bool {
-			nodes, descent := syntaxNodeToRefNode(innerNode, n, includeDeclaration)
-			if len(nodes) > 0 {
-				refNodes = append(refNodes, nodes...)
-			}
-			return descent
-		})
-		descent = false
 
 	case *syntax.ParamExp:
 		if refNode := paramExpToRefNode(n); refNode != nil {
@@ -156,12 +164,12 @@ func syntaxNodeToRefNode(node syntax.Node, scope *syntax.FuncDecl, includeDeclar
 		}
 
 	case *syntax.ArithmExp:
-		if nodes := arithmExprToRefNode(n.X, scope); len(nodes) > 0 {
+		if nodes := arithmExprToRefNode(n.X); len(nodes) > 0 {
 			refNodes = append(refNodes, nodes...)
 		}
 
 	case *syntax.ArithmCmd:
-		if nodes := arithmExprToRefNode(n.X, scope); len(nodes) > 0 {
+		if nodes := arithmExprToRefNode(n.X); len(nodes) > 0 {
 			refNodes = append(refNodes, nodes...)
 		}
 	}
@@ -169,25 +177,33 @@ func syntaxNodeToRefNode(node syntax.Node, scope *syntax.FuncDecl, includeDeclar
 	return refNodes, descent
 }
 
-func callExprToRefNode(callExpr *syntax.CallExpr, scope *syntax.FuncDecl, includeDeclaration bool) *RefNode {
+func callExprToRefNode(callExpr *syntax.CallExpr, includeDeclaration bool) []RefNode {
 	var name string
 	var startLine, startChar, endLine, endChar uint
 
 	if len(callExpr.Args) > 0 {
 		cmdName := ExtractIdentifier(callExpr.Args[0])
 
-		// Variable assignments as part of read statements
+		// Variable assignments as part of read statements, one for each
+		// variable read into
 		if cmdName == "read" && includeDeclaration {
+			var refNodes []RefNode
 			for _, arg := range callExpr.Args[1:] {
-				for _, wp := range arg.Parts {
-					switch p := wp.(type) {
-					case *syntax.Lit:
-						name = p.Value
-						startLine, startChar = p.Pos().Line(), p.Pos().Col()
-						endLine, endChar = p.End().Line(), p.End().Col()
-					}
+				name := ExtractIdentifier(arg)
+				if name == "" || strings.HasPrefix(name, "-") {
+					continue
 				}
+				refNodes = append(refNodes, RefNode{
+					Node:      callExpr,
+					Name:      name,
+					IsWrite:   true,
+					StartLine: arg.Pos().Line(),
+					StartChar: arg.Pos().Col(),
+					EndLine:   arg.End().Line(),
+					EndChar:   arg.End().Col(),
+				})
 			}
+			return refNodes
 		} else if cmdName != "" && cmdName != "local" && cmdName != "declare" && cmdName != "typeset" && cmdName != "read" {
 			arg := callExpr.Args[0]
 			for _, wp := range arg.Parts {
@@ -205,15 +221,14 @@ func callExprToRefNode(callExpr *syntax.CallExpr, scope *syntax.FuncDecl, includ
 		return nil
 	}
 
-	return &RefNode{
+	return []RefNode{{
 		Node:      callExpr,
 		Name:      name,
-		Scope:     scope,
 		StartLine: startLine,
 		StartChar: startChar,
 		EndLine:   endLine,
 		EndChar:   endChar,
-	}
+	}}
 }
 
 func funcDeclToRefNode(funcDecl *syntax.FuncDecl, includeDeclaration bool) *RefNode {
@@ -228,7 +243,7 @@ func funcDeclToRefNode(funcDecl *syntax.FuncDecl, includeDeclaration bool) *RefN
 	return &RefNode{
 		Node:      funcDecl,
 		Name:      name,
-		Scope:     nil,
+		IsWrite:   true,
 		StartLine: startLine,
 		StartChar: startChar,
 		EndLine:   endLine,
@@ -248,7 +263,7 @@ func assignToRefNode(assignNode *syntax.Assign, includeDeclaration bool) *RefNod
 	return &RefNode{
 		Node:      assignNode,
 		Name:      name,
-		Scope:     nil,
+		IsWrite:   true,
 		StartLine: startLine,
 		StartChar: startChar,
 		EndLine:   endLine,
@@ -256,13 +271,14 @@ func assignToRefNode(assignNode *syntax.Assign, includeDeclaration bool) *RefNod
 	}
 }
 
-func declClauseToRefNode(declClause *syntax.DeclClause, scope *syntax.FuncDecl, includeDeclaration bool) []RefNode {
+func declClauseToRefNode(declClause *syntax.DeclClause, includeDeclaration bool) []RefNode {
 	if !includeDeclaration {
 		return nil
 	}
 
-	cmd := declClause.Variant.Value
-	if cmd != "local" && cmd != "declare" && cmd != "typeset" {
+	switch declClause.Variant.Value {
+	case "local", "declare", "typeset", "export", "readonly":
+	default:
 		return nil
 	}
 
@@ -276,7 +292,7 @@ func declClauseToRefNode(declClause *syntax.DeclClause, scope *syntax.FuncDecl,
 			refNodes = append(refNodes, RefNode{
 				Node:      declClause,
 				Name:      name,
-				Scope:     scope,
+				IsWrite:   true,
 				StartLine: startLine,
 				StartChar: startChar,
 				EndLine:   endLine,
@@ -288,7 +304,7 @@ func declClauseToRefNode(declClause *syntax.DeclClause, scope *syntax.FuncDecl,
 	return refNodes
 }
 
-func forClauseToRefNode(forClause *syntax.ForClause, scope *syntax.FuncDecl, includeDeclaration bool) []RefNode {
+func forClauseToRefNode(forClause *syntax.ForClause, includeDeclaration bool) []RefNode {
 	if !includeDeclaration {
 		return nil
 	}
@@ -303,7 +319,7 @@ func forClauseToRefNode(forClause *syntax.ForClause, scope *syntax.FuncDecl, inc
 			refNodes = append(refNodes, RefNode{
 				Node:      forClause,
 				Name:      name,
-				Scope:     scope,
+				IsWrite:   true,
 				StartLine: startLine,
 				StartChar: startChar,
 				EndLine:   endLine,
@@ -314,15 +330,15 @@ func forClauseToRefNode(forClause *syntax.ForClause, scope *syntax.FuncDecl, inc
 	case *syntax.CStyleLoop:
 		if loop.Init != nil {
 			refNodes = append(refNodes,
-				arithmExprToRefNode(loop.Init, scope)...)
+				arithmExprToRefNode(loop.Init)...)
 		}
 		if loop.Cond != nil {
 			refNodes = append(refNodes,
-				arithmExprToRefNode(loop.Cond, scope)...)
+				arithmExprToRefNode(loop.Cond)...)
 		}
 		if loop.Post != nil {
 			refNodes = append(refNodes,
-				arithmExprToRefNode(loop.Post, scope)...)
+				arithmExprToRefNode(loop.Post)...)
 		}
 
 	}
@@ -330,11 +346,12 @@ func forClauseToRefNode(forClause *syntax.ForClause, scope *syntax.FuncDecl, inc
 	return refNodes
 }
 
-func arithmExprToRefNode(arithmExpr syntax.ArithmExpr, scope *syntax.FuncDecl) []RefNode {
+func arithmExprToRefNode(arithmExpr syntax.ArithmExpr) []RefNode {
 	var refNodes []RefNode
 
-	var walkArithm func(syntax.ArithmExpr)
-	walkArithm = func(expr syntax.ArithmExpr) {
+	// isWrite is whether expr is assigned, like the x of `x = 1` or `x++`
+	var walkArithm func(expr syntax.ArithmExpr, isWrite bool)
+	walkArithm = func(expr syntax.ArithmExpr, isWrite bool) {
 		switch e := expr.(type) {
 
 		case *syntax.Word:
@@ -350,7 +367,7 @@ func arithmExprToRefNode(arithmExpr syntax.ArithmExpr, scope *syntax.FuncDecl) [
 					refNodes = append(refNodes, RefNode{
 						Node:      lit,
 						Name:      name,
-						Scope:     scope,
+						IsWrite:   isWrite,
 						StartLine: lit.Pos().Line(),
 						StartChar: lit.Pos().Col(),
 						EndLine:   lit.End().Line(),
@@ -360,60 +377,55 @@ func arithmExprToRefNode(arithmExpr syntax.ArithmExpr, scope *syntax.FuncDecl) [
 			}
 
 		case *syntax.BinaryArithm:
-			walkArithm(e.X)
-			walkArithm(e.Y)
+			walkArithm(e.X, isArithmAssign(e.Op))
+			walkArithm(e.Y, false)
 
 		case *syntax.UnaryArithm:
-			walkArithm(e.X)
+			walkArithm(e.X, e.Op == syntax.Inc || e.Op == syntax.Dec)
 
 		case *syntax.ParenArithm:
-			walkArithm(e.X)
+			walkArithm(e.X, false)
 		}
 	}
 
-	walkArithm(arithmExpr)
+	walkArithm(arithmExpr, false)
 
 	return refNodes
 }
 
-func (a *Ast) wouldResolveToSameDefinition(refCursorNode syntax.Node, targetDefNode *DefNode) bool {
-	pos := refCursorNode.Pos()
-	cursor := Cursor{Line: pos.Line(), Col: pos.Col()}
-	refScope := a.findEnclosingFunction(cursor)
+func isArithmAssign(op syntax.BinAritOperator) bool {
+	switch op {
+	case syntax.Assgn, syntax.AddAssgn, syntax.SubAssgn, syntax.MulAssgn, syntax.QuoAssgn, syntax.RemAssgn,
+		syntax.AndAssgn, syntax.OrAssgn, syntax.XorAssgn, syntax.ShlAssgn, syntax.ShrAssgn:
+		return true
+	}
+	return false
+}
+
+func (a *Ast) wouldResolveToSameDefinition(refNode *RefNode, targetDefNode *DefNode) bool {
+	cursor := Cursor{Line: refNode.StartLine, Col: refNode.StartChar}
 
 	targetIdentifier := targetDefNode.Name
 
-	// Look for scoped variables in the same function scope
-	if refScope != nil {
-		var closestLocalDef *DefNode
-
-		for _, defNode := range a.DefNodes() {
-			if defNode.Name == targetIdentifier && defNode.IsScoped && defNode.Scope == refScope {
-				// Check if the scoped variable is declared BEFORE the reference position
-				if defNode.isBeforeCursor(cursor) {
-					// Among all local variables declared before this reference,
-					// find the one that's closest (latest declaration)
-					if closestLocalDef == nil || defNode.isDefinitionAfter(closestLocalDef) {
-						closestLocalDef = &defNode
-					}
-				}
-			}
-		}
+	// If a local variable visible at the reference is declared before it, use it
+	if closestLocalDef := a.closestLocalDef(targetIdentifier, cursor); closestLocalDef != nil {
+		return closestLocalDef.isSameDefinition(targetDefNode)
+	}
 
-		// If we found a local variable declared before the reference, use it
-		if closestLocalDef != nil {
-			return closestLocalDef.isSameDefinition(targetDefNode)
-		}
+	// Due to dynamic scoping, the reference may be to a local variable of a
+	// function calling the function the reference is in
+	if targetDefNode.IsScoped {
+		return slices.ContainsFunc(a.dynamicLocalDefs(targetIdentifier, cursor), func(defNode DefNode) bool {
+			return defNode.isSameDefinition(targetDefNode)
+		})
 	}
 
-	// No local variable found that's declared before the reference
-	// Look for global definitions (functions and non-scoped variables)
+	// No local variable found that's declared before the reference. All
+	// global definitions (functions and non-scoped variables) of a name are
+	// definitions of the same variable or function, also in subshells.
 	for _, defNode := range a.DefNodes() {
-		if defNode.Name == targetIdentifier {
-			if defNode.IsScoped {
-				continue
-			}
-			return defNode.isSameDefinition(targetDefNode)
+		if defNode.Name == targetIdentifier && !defNode.IsScoped {
+			return !targetDefNode.IsScoped
 		}
 	}
 
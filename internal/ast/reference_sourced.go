@@ -2,16 +2,14 @@ package ast
 
 import (
 	"log/slog"
-	"os"
-
-	"mvdan.cc/sh/v3/syntax"
 )
 
-// Cross-file reference finding
+// Cross-file reference finding in sourcedFiles, which are the files sourced
+// directly or indirectly in source order
 func (a *Ast) FindRefsinSourcedFile(
 	cursor Cursor,
-	env map[string]string,
-	baseDir string,
+	sourcedFiles []string,
+	parseFile FileParser,
 	includeDeclaration bool,
 ) map[string][]RefNode {
 	cursorNode := a.FindNodeUnderCursor(cursor)
@@ -20,21 +18,16 @@ func (a *Ast) FindRefsinSourcedFile(
 		return map[string][]RefNode{}
 	}
 
-	_, defNode := a.FindDefinitionAcrossFiles(cursor, env, baseDir)
-	if defNode == nil {
+	// All definitions found are of the same variable or function
+	_, defNodes := a.FindDefinitionAcrossFiles(cursor, sourcedFiles, parseFile)
+	if len(defNodes) == 0 {
 		return map[string][]RefNode{}
 	}
 
-	sourcedFiles := a.FindAllSourcedFiles(env, baseDir, map[string]bool{})
 	filesRefNodes := map[string][]RefNode{}
 
 	for _, sourcedFile := range sourcedFiles {
-		fileContent, err := os.ReadFile(sourcedFile)
-		if err != nil {
-			slog.Error("Could not read file", "file", sourcedFile)
-			continue
-		}
-		sourcedFileAst, err := ParseDocument(string(fileContent), sourcedFile, false)
+		sourcedFileAst, err := parseFile(sourcedFile)
 		if err != nil {
 			slog.Error("Could not parse file", "file", sourcedFile)
 			continue
@@ -46,7 +39,7 @@ func (a *Ast) FindRefsinSourcedFile(
 				continue
 			}
 
-			if sourcedFileAst.wouldResolveToSameDefinitionAcrossFiles(refNode.Node, defNode) {
+			if sourcedFileAst.wouldResolveToSameDefinitionAcrossFiles(&refNode, &defNodes[0]) {
 				refs = append(refs, refNode)
 			}
 		}
@@ -58,32 +51,14 @@ func (a *Ast) FindRefsinSourcedFile(
 	return filesRefNodes
 }
 
-func (a *Ast) wouldResolveToSameDefinitionAcrossFiles(refCursorNode syntax.Node, targetDefNode *DefNode) bool {
-	pos := refCursorNode.Pos()
-	cursor := Cursor{Line: pos.Line(), Col: pos.Col()}
-	refScope := a.findEnclosingFunction(cursor)
-
+func (a *Ast) wouldResolveToSameDefinitionAcrossFiles(refNode *RefNode, targetDefNode *DefNode) bool {
+	cursor := Cursor{Line: refNode.StartLine, Col: refNode.StartChar}
 	targetIdentifier := targetDefNode.Name
 
-	// First, look for scoped variables in the same function scope within the reference file
-	if refScope != nil {
-		// Find the closest local declaration that comes BEFORE the reference
-		var closestLocalDef *DefNode
-
-		for _, defNode := range a.DefNodes() {
-			if defNode.Name == targetIdentifier && defNode.IsScoped && defNode.Scope == refScope {
-				if defNode.isBeforeCursor(cursor) {
-					if closestLocalDef == nil || defNode.isDefinitionAfter(closestLocalDef) {
-						closestLocalDef = &defNode
-					}
-				}
-			}
-		}
-
-		// If we found a local variable declared before the reference, use it
-		if closestLocalDef != nil {
-			return closestLocalDef.isSameDefinition(targetDefNode)
-		}
+	// First, look for a local variable visible at the reference, which is
+	// declared BEFORE it
+	if closestLocalDef := a.closestLocalDef(targetIdentifier, cursor); closestLocalDef != nil {
+		return closestLocalDef.isSameDefinition(targetDefNode)
 	}
 
 	if targetDefNode.IsScoped {
@@ -1,20 +1,19 @@
 package ast
 
 import (
+	"context"
 	"log/slog"
-	"os"
-	"path/filepath"
-	"slices"
-
-	"github.com/matkrin/bashd/internal/utils"
 )
 
+// Find references in workspaceFiles, which source the current file or are
+// sourced by it. sourcedFiles are the files sourced by the current file, in
+// which its definitions are searched. Files are parsed with parseFile. Stops early when ctx is done.
 func (a *Ast) FindRefsInWorkspaceFiles(
-	uri string,
-	workspaceShFiles []string,
-	baseDir string,
+	ctx context.Context,
+	workspaceFiles []string,
+	sourcedFiles []string,
+	parseFile FileParser,
 	cursor Cursor,
-	env map[string]string,
 	includeDeclaration bool,
 ) map[string][]RefNode {
 	cursorNode := a.FindNodeUnderCursor(cursor)
@@ -23,62 +22,32 @@ func (a *Ast) FindRefsInWorkspaceFiles(
 		return map[string][]RefNode{}
 	}
 
-	_, defNode := a.FindDefinitionAcrossFiles(cursor, env, baseDir)
-	if defNode == nil {
+	// All definitions found are of the same variable or function
+	_, defNodes := a.FindDefinitionAcrossFiles(cursor, sourcedFiles, parseFile)
+	if len(defNodes) == 0 {
 		return map[string][]RefNode{}
 	}
 
 	referenceNodes := map[string][]RefNode{}
 
-	for _, workspaceShFile := range workspaceShFiles {
-		fileContent, err := os.ReadFile(workspaceShFile)
-		if err != nil {
-			slog.Error("Could not read file", "file", workspaceShFile)
-			continue
+	for _, workspaceShFile := range workspaceFiles {
+		if ctx.Err() != nil {
+			break
 		}
 
-		workspaceFileAst, err := ParseDocument(string(fileContent), workspaceShFile, false)
+		workspaceFileAst, err := parseFile(workspaceShFile)
 		if err != nil {
 			slog.Error("Could not parse file", "file", workspaceShFile)
 			continue
 		}
 
-		// Check if this file sources the target file
-		shouldIncludeFile := false
-		for _, sourceStatement := range workspaceFileAst.FindSourceStatments(env) {
-			baseDir := filepath.Dir(workspaceShFile)
-			path := sourceStatement.SourcedFile
-			resolved := path
-			if !filepath.IsAbs(path) {
-				resolved = filepath.Join(baseDir, path)
-			}
-			resolved = filepath.Clean(resolved)
-
-			if uri == utils.PathToURI(resolved) {
-				shouldIncludeFile = true
-				break
-			}
-		}
-
-		if !shouldIncludeFile {
-			// Check if the workspace file is in the same sourcing chain
-			sourcedFiles := a.FindAllSourcedFiles(env, baseDir, map[string]bool{})
-			if slices.Contains(sourcedFiles, workspaceShFile) {
-				shouldIncludeFile = true
-			}
-		}
-
-		if !shouldIncludeFile {
-			continue
-		}
-
 		var refs []RefNode
 		for _, refNode := range workspaceFileAst.RefNodes(includeDeclaration) {
 			if refNode.Name != targetIdentifier {
 				continue
 			}
 
-			if workspaceFileAst.wouldResolveToSameDefinitionAcrossFiles(refNode.Node, defNode) {
+			if workspaceFileAst.wouldResolveToSameDefinitionAcrossFiles(&refNode, &defNodes[0]) {
 				refs = append(refs, refNode)
 			}
 		}
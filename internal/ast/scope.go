@@ -0,0 +1,208 @@
+package ast
+
+import (
+	"slices"
+
+	"mvdan.cc/sh/v3/syntax"
+)
+
+type ScopeKind int
+
+const (
+	FileScope ScopeKind = iota
+	// Body of a function. Its local variables are visible in the functions it
+	// calls, as bash scopes variables dynamically.
+	FunctionScope
+	// Subshell, command or process substitution, pipeline segment or
+	// background command. Its assignments do not reach the parent shell.
+	SubshellScope
+)
+
+// Node in the scope tree of a file
+type Scope struct {
+	Kind     ScopeKind
+	Node     syntax.Node
+	Parent   *Scope
+	Children []*Scope
+}
+
+func newScopeTree(file *syntax.File) *Scope {
+	root := &Scope{Kind: FileScope, Node: file}
+
+	// Statements of pipelines, which bash runs in subshells. The last one is
+	// a subshell as well, unless `shopt -s lastpipe` is set.
+	pipelineSegments := map[*syntax.Stmt]bool{}
+
+	current := root
+	var visited []syntax.Node
+	syntax.Walk(file, func(node syntax.Node) bool {
+		if node == nil {
+			if visited[len(visited)-1] == current.Node {
+				current = current.Parent
+			}
+			visited = visited[:len(visited)-1]
+			return true
+		}
+		visited = append(visited, node)
+
+		kind := FileScope
+		switch n := node.(type) {
+		case *syntax.FuncDecl:
+			kind = FunctionScope
+		case *syntax.Subshell, *syntax.CmdSubst, *syntax.ProcSubst:
+			kind = SubshellScope
+		case *syntax.BinaryCmd:
+			if n.Op == syntax.Pipe || n.Op == syntax.PipeAll {
+				for _, stmt := range []*syntax.Stmt{n.X, n.Y} {
+					if !isPipeline(stmt) {
+						pipelineSegments[stmt] = true
+					}
+				}
+			}
+		case *syntax.Stmt:
+			if n.Background || n.Coprocess || pipelineSegments[n] {
+				kind = SubshellScope
+			}
+		}
+		if kind != FileScope {
+			scope := &Scope{Kind: kind, Node: node, Parent: current}
+			current.Children = append(current.Children, scope)
+			current = scope
+		}
+		return true
+	})
+
+	return root
+}
+
+func isPipeline(stmt *syntax.Stmt) bool {
+	binaryCmd, ok := stmt.Cmd.(*syntax.BinaryCmd)
+	return ok && (binaryCmd.Op == syntax.Pipe || binaryCmd.Op == syntax.PipeAll)
+}
+
+// Innermost scope containing cursor
+func (s *Scope) scopeAt(cursor Cursor) *Scope {
+	for _, child := range s.Children {
+		if cursor.isCursorInNode(child.Node) {
+			return child.scopeAt(cursor)
+		}
+	}
+	return s
+}
+
+// Function the scope is part of, nil outside of functions
+func (s *Scope) Function() *syntax.FuncDecl {
+	for scope := s; scope != nil; scope = scope.Parent {
+		if funcDecl, ok := scope.Node.(*syntax.FuncDecl); ok {
+			return funcDecl
+		}
+	}
+	return nil
+}
+
+// Whether the scope is other or one of its ancestors, so that its variables
+// are visible in other
+func (s *Scope) encloses(other *Scope) bool {
+	for scope := other; scope != nil; scope = scope.Parent {
+		if scope == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Scope of a definition. Local variables belong to the innermost scope of
+// the declaration, global variables and functions to the innermost shell,
+// i.e. the file or a subshell.
+func (a *Ast) defScope(defNode *DefNode) *Scope {
+	scope := a.scopes.scopeAt(Cursor{Line: defNode.StartLine, Col: defNode.StartChar})
+	if scope.Node == defNode.Node {
+		// The name of a function belongs to the enclosing scope
+		scope = scope.Parent
+	}
+	if defNode.IsScoped {
+		return scope
+	}
+	for scope.Kind == FunctionScope {
+		scope = scope.Parent
+	}
+	return scope
+}
+
+// Innermost scope of a reference
+func (a *Ast) refScope(refNode *RefNode) *Scope {
+	scope := a.scopes.scopeAt(Cursor{Line: refNode.StartLine, Col: refNode.StartChar})
+	if scope.Node == refNode.Node {
+		// The name of a function belongs to the enclosing scope
+		scope = scope.Parent
+	}
+	return scope
+}
+
+// Closest local definition of name visible at cursor, declared before it in
+// the enclosing function
+func (a *Ast) closestLocalDef(name string, cursor Cursor) *DefNode {
+	cursorScope := a.scopes.scopeAt(cursor)
+
+	var closestLocalDef *DefNode
+	for _, defNode := range a.DefNodes() {
+		if defNode.Name == name && defNode.IsScoped && defNode.Scope.encloses(cursorScope) &&
+			defNode.isBeforeCursor(cursor) &&
+			(closestLocalDef == nil || defNode.isDefinitionAfter(closestLocalDef)) {
+			closestLocalDef = &defNode
+		}
+	}
+	return closestLocalDef
+}
+
+// Local definitions of name in the functions calling the function enclosing
+// cursor, which are visible in it due to dynamic scoping. Callers without a
+// local definition are followed to their own callers.
+func (a *Ast) dynamicLocalDefs(name string, cursor Cursor) []DefNode {
+	function := a.scopes.scopeAt(cursor).Function()
+	if function == nil {
+		return nil
+	}
+
+	var defNodes []DefNode
+	visited := map[*syntax.FuncDecl]bool{function: true}
+	callees := []*syntax.FuncDecl{function}
+	for len(callees) > 0 {
+		callee := callees[0]
+		callees = callees[1:]
+
+		for _, call := range a.findCalls(callee.Name.Value) {
+			callCursor := Cursor{Line: call.Pos().Line(), Col: call.Pos().Col()}
+			caller := a.scopes.scopeAt(callCursor).Function()
+			if caller == nil {
+				continue
+			}
+			if localDef := a.closestLocalDef(name, callCursor); localDef != nil {
+				if !slices.ContainsFunc(defNodes, func(defNode DefNode) bool {
+					return defNode.isSameDefinition(localDef)
+				}) {
+					defNodes = append(defNodes, *localDef)
+				}
+				continue
+			}
+			if !visited[caller] {
+				visited[caller] = true
+				callees = append(callees, caller)
+			}
+		}
+	}
+	return defNodes
+}
+
+// Commands calling the function name
+func (a *Ast) findCalls(name string) []*syntax.CallExpr {
+	var calls []*syntax.CallExpr
+	syntax.Walk(a.File, func(node syntax.Node) bool {
+		if callExpr, ok := node.(*syntax.CallExpr); ok && len(callExpr.Args) > 0 &&
+			ExtractIdentifier(callExpr.Args[0]) == name {
+			calls = append(calls, callExpr)
+		}
+		return true
+	})
+	return calls
+}
@@ -0,0 +1,78 @@
+package ast
+
+import (
+	"slices"
+	"testing"
+)
+
+func Test_FindDefInFileScopes(t *testing.T) {
+	tests := []struct {
+		name       string
+		input      string
+		cursor     Cursor
+		startLines []uint
+	}{
+		{"subshell", "x=1\n(x=2)\necho \"$x\"", NewCursor(2, 7), []uint{1}},
+		{"command substitution", "x=1\ny=\"$(x=2)\"\necho \"$x\"", NewCursor(2, 7), []uint{1}},
+		{"pipeline", "x=1\necho | x=2\necho \"$x\"", NewCursor(2, 7), []uint{1}},
+		{"background", "x=1\nx=2 &\necho \"$x\"", NewCursor(2, 7), []uint{1}},
+		{"in subshell", "x=1\n(\n  x=2\n  echo \"$x\"\n)", NewCursor(3, 9), []uint{3}},
+		{"function in subshell", "(f() { :; })\nf", NewCursor(1, 0), nil},
+		{
+			"dynamic scope",
+			"greet() {\n  echo \"$name\"\n}\nmain() {\n  local name=world\n  greet\n}",
+			NewCursor(1, 9),
+			[]uint{5},
+		},
+		{
+			"dynamic scope through callers",
+			"name=global\ngreet() {\n  echo \"$name\"\n}\nhello() {\n  greet\n}\nmain() {\n  local name=world\n  hello\n}",
+			NewCursor(2, 9),
+			[]uint{1, 9},
+		},
+		{"loop variable in function", "f() {\n  for i in 1 2; do :; done\n}\nf\necho \"$i\"", NewCursor(4, 7), []uint{2}},
+		{"read in function", "f() {\n  read -r a b\n}\nf\necho \"$b\"", NewCursor(4, 7), []uint{2}},
+		{"global declaration in function", "f() {\n  declare -g x=1\n}\nf\necho \"$x\"", NewCursor(4, 7), []uint{2}},
+		{"local later on the line", "x=1\nf() {\n  echo \"$x\"; local x=2\n}\nf", NewCursor(2, 9), []uint{1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fileAst, err := ParseDocument(tt.input, "", false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var startLines []uint
+			for _, defNode := range fileAst.FindDefInFile(tt.cursor) {
+				startLines = append(startLines, defNode.StartLine)
+			}
+			if !slices.Equal(startLines, tt.startLines) {
+				t.Errorf("expected definitions at lines %v, got %v", tt.startLines, startLines)
+			}
+		})
+	}
+}
+
+func Test_FindRefsInFileDynamicScope(t *testing.T) {
+	input := `name=global
+greet() {
+  echo "$name"
+}
+main() {
+  local name=world
+  greet
+  echo "$name"
+}
+echo "$name"
+`
+	fileAst, _ := ParseDocument(input, "", false)
+
+	var startLines []uint
+	for _, refNode := range fileAst.FindRefsInFile(NewCursor(5, 8), true) {
+		startLines = append(startLines, refNode.StartLine)
+	}
+	want := []uint{3, 6, 8}
+	if !slices.Equal(startLines, want) {
+		t.Errorf("expected references at lines %v, got %v", want, startLines)
+	}
+}
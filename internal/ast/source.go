@@ -3,115 +3,230 @@ package ast
 import (
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 
 	"mvdan.cc/sh/v3/syntax"
 )
 
 type SourceStatement struct {
+	// Path as written in the script or in a `# shellcheck source=` directive
 	SourcedFile string
-	StartLine   uint
-	StartChar   uint
-	EndLine     uint
-	EndChar     uint
+	// Path of the sourced file, see `ResolveSourcedFile`
+	ResolvedFile string
+	StartLine    uint
+	StartChar    uint
+	EndLine      uint
+	EndChar      uint
 }
 
-// Find `source` statements in AST
-func (a *Ast) FindSourceStatments(env map[string]string) []SourceStatement {
+// Find `source` statements in AST of the script at scriptPath. Paths that
+// cannot be evaluated statically are skipped, unless a `# shellcheck source=`
+// directive names the sourced file. Statements whose directive names
+// `/dev/null` are skipped as well.
+func (a *Ast) FindSourceStatments(
+	env map[string]string,
+	scriptPath string,
+	searchPaths []string,
+) []SourceStatement {
 	sourcedStatements := []SourceStatement{}
-	syntax.Walk(a.File, func(node syntax.Node) bool {
-		call, ok := node.(*syntax.CallExpr)
-		if !ok || len(call.Args) < 2 {
-			return true
-		}
-
-		cmdName := extractAndExpandWord(call.Args[0], env)
-		if cmdName != "source" && cmdName != "." {
-			return true
-		}
-
-		path := extractAndExpandWord(call.Args[1], env)
-		if path == "" {
-			return true
+	a.walkSourceStatements(env, scriptPath, searchPaths, func(call *syntax.CallExpr, path, resolved string) bool {
+		if resolved != "" {
+			sourcedStatements = append(sourcedStatements, newSourceStatement(call, path, resolved))
 		}
-
-		sourcedStatements = append(sourcedStatements, SourceStatement{
-			SourcedFile: path,
-			StartLine:   node.Pos().Line() - 1,
-			StartChar:   node.Pos().Col() - 1,
-			EndLine:     node.End().Line() - 1,
-			EndChar:     node.End().Col() - 1,
-		})
 		return true
 	})
 	return sourcedStatements
 }
 
-// Find sourced files recursively and return their filenames
-func (a *Ast) FindAllSourcedFiles(
+// Find `source` statements in AST of the script at scriptPath whose paths
+// cannot be evaluated statically. Their SourcedFile is the path as written in
+// the script and their ResolvedFile is empty.
+func (a *Ast) FindDynamicSourceStatements(
 	env map[string]string,
-	baseDir string,
-	visited map[string]bool,
-) []string {
-	var sourcedFiles []string
-	for _, sourcedFile := range a.FindSourceStatments(env) {
-		path := sourcedFile.SourcedFile
-		resolved := path
-		if !filepath.IsAbs(path) {
-			resolved = filepath.Join(baseDir, path)
-		}
-		resolved = filepath.Clean(resolved)
-
-		if visited[resolved] {
-			continue
+	scriptPath string,
+	searchPaths []string,
+) []SourceStatement {
+	sourcedStatements := []SourceStatement{}
+	a.walkSourceStatements(env, scriptPath, searchPaths, func(call *syntax.CallExpr, path, resolved string) bool {
+		if resolved == "" {
+			sourcedStatements = append(sourcedStatements, newSourceStatement(call, path, resolved))
 		}
-		visited[resolved] = true
-
-		sourcedFiles = append(sourcedFiles, resolved)
+		return true
+	})
+	return sourcedStatements
+}
 
-		// Recurse
-		if content, err := os.ReadFile(resolved); err == nil {
-			if parsed, err := ParseDocument(string(content), "", false); err == nil {
-				subFiles := parsed.FindAllSourcedFiles(env, filepath.Dir(resolved), visited)
-				sourcedFiles = append(sourcedFiles, subFiles...)
-			}
-		}
+func newSourceStatement(call *syntax.CallExpr, path, resolved string) SourceStatement {
+	return SourceStatement{
+		SourcedFile:  path,
+		ResolvedFile: resolved,
+		StartLine:    call.Pos().Line() - 1,
+		StartChar:    call.Pos().Col() - 1,
+		EndLine:      call.End().Line() - 1,
+		EndChar:      call.End().Col() - 1,
 	}
-	return sourcedFiles
 }
 
 // Find a sourced file itself (cursor over filepath).
 func (a *Ast) FindSourcedFile(
 	cursor Cursor,
 	env map[string]string,
-	baseDir string,
+	scriptPath string,
+	searchPaths []string,
 ) string {
 	var found string
 
+	a.walkSourceStatements(env, scriptPath, searchPaths, func(call *syntax.CallExpr, path, resolved string) bool {
+		if resolved == "" || !cursor.isCursorInNode(call.Args[1]) {
+			return true
+		}
+		found = resolved
+		return false // stop walking
+	})
+
+	return found
+}
+
+// Resolve a sourced path like ShellCheck: a relative path is looked up in the
+// search paths, in which `SCRIPTDIR` stands for the directory of the script,
+// and otherwise taken relative to the directory of the script
+func ResolveSourcedFile(path, scriptPath string, searchPaths []string) string {
+	if filepath.IsAbs(path) {
+		return filepath.Clean(path)
+	}
+
+	scriptDir := filepath.Dir(scriptPath)
+	for _, searchPath := range searchPaths {
+		dir := searchPath
+		if dir == "SCRIPTDIR" || strings.HasPrefix(dir, "SCRIPTDIR/") {
+			dir = scriptDir + strings.TrimPrefix(dir, "SCRIPTDIR")
+		} else if !filepath.IsAbs(dir) {
+			dir = filepath.Join(scriptDir, dir)
+		}
+		candidate := filepath.Join(dir, path)
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate
+		}
+	}
+	return filepath.Join(scriptDir, path)
+}
+
+// ShellCheck directives in the comments before a statement
+type sourceDirectives struct {
+	// Value of `source=`
+	source string
+	// Values of `source-path=`
+	sourcePaths []string
+}
+
+func findSourceDirectives(stmt *syntax.Stmt) sourceDirectives {
+	var directives sourceDirectives
+	for _, comment := range stmt.Comments {
+		// Trailing comments on the line of the statement are no directives
+		if !stmt.Pos().After(comment.Pos()) {
+			continue
+		}
+		fields := strings.Fields(comment.Text)
+		if len(fields) == 0 || fields[0] != "shellcheck" {
+			continue
+		}
+		for _, field := range fields[1:] {
+			key, value, ok := strings.Cut(field, "=")
+			if !ok {
+				continue
+			}
+			switch key {
+			case "source":
+				directives.source = value
+			case "source-path":
+				directives.sourcePaths = append(directives.sourcePaths, value)
+			}
+		}
+	}
+	return directives
+}
+
+// Call fn for each `source` statement, tracking the variables assigned and the
+// ShellCheck directives before it, until fn returns false. For paths that
+// cannot be evaluated statically, fn gets the path as written and an empty
+// resolved path.
+func (a *Ast) walkSourceStatements(
+	env map[string]string,
+	scriptPath string,
+	searchPaths []string,
+	fn func(call *syntax.CallExpr, path, resolved string) bool,
+) {
+	// Directives before the first statement apply to the whole script
+	fileSearchPaths := searchPaths
+	if len(a.File.Stmts) > 0 {
+		fileDirectives := findSourceDirectives(a.File.Stmts[0])
+		fileSearchPaths = slices.Concat(fileDirectives.sourcePaths, searchPaths)
+	}
+
+	type activeDirectives struct {
+		end syntax.Pos
+		sourceDirectives
+	}
+	// Directives of the statements enclosing the current node, innermost last
+	var active []activeDirectives
+
+	evaluator := newEvaluator(env, scriptPath)
+	done := false
 	syntax.Walk(a.File, func(node syntax.Node) bool {
+		if done {
+			return false
+		}
+		if node == nil {
+			return true
+		}
+		for len(active) > 0 && node.Pos().After(active[len(active)-1].end) {
+			active = active[:len(active)-1]
+		}
+		if stmt, ok := node.(*syntax.Stmt); ok {
+			active = append(active, activeDirectives{stmt.End(), findSourceDirectives(stmt)})
+		}
+		evaluator.track(node)
+
 		call, ok := node.(*syntax.CallExpr)
 		if !ok || len(call.Args) < 2 {
 			return true
 		}
 
-		cmdName := extractAndExpandWord(call.Args[0], env)
-		if cmdName != "source" && cmdName != "." {
+		cmdName, ok := evaluator.eval(call.Args[0])
+		if !ok || (cmdName != "source" && cmdName != ".") {
 			return true
 		}
 
-		argNode := call.Args[1]
-		if cursor.isCursorInNode(argNode) {
-			path := extractAndExpandWord(argNode, env)
-
-			if !filepath.IsAbs(path) {
-				path = filepath.Join(baseDir, path)
+		var path string
+		stmtSearchPaths := fileSearchPaths
+		for _, directives := range active {
+			if directives.source != "" {
+				path = directives.source
 			}
-			path = filepath.Clean(path)
-			found = path
-
-			return false // stop walking
+			stmtSearchPaths = slices.Concat(directives.sourcePaths, stmtSearchPaths)
 		}
-		return true
+		if path == "/dev/null" {
+			return true
+		}
+		if path == "" {
+			path, ok = evaluator.eval(call.Args[1])
+			if !ok || path == "" {
+				done = !fn(call, printWord(call.Args[1]), "")
+				return !done
+			}
+		}
+
+		done = !fn(call, path, ResolveSourcedFile(path, scriptPath, stmtSearchPaths))
+		return !done
 	})
+}
 
-	return found
+// Word as written in the script, up to formatting
+func printWord(word *syntax.Word) string {
+	var builder strings.Builder
+	if err := syntax.NewPrinter().Print(&builder, word); err != nil {
+		return ""
+	}
+	return builder.String()
 }
@@ -0,0 +1,300 @@
+package ast
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"mvdan.cc/sh/v3/expand"
+	"mvdan.cc/sh/v3/syntax"
+)
+
+var errNotStatic = errors.New("word cannot be evaluated statically")
+
+// Static evaluation of the words of a script, as far as their values are known
+// without running it: literals, the environment of the server, `$0` and
+// `$BASH_SOURCE` of the script, variables assigned earlier in the script,
+// default values of variables not assigned at all, parameter expansion
+// operators and command substitutions of `dirname`,
+// `basename`, `realpath`, `readlink -f`, `echo` and `cd DIR && pwd`.
+type evaluator struct {
+	env map[string]string
+	// Path of the script, empty if unknown
+	scriptPath string
+	// Variables assigned in the script so far, and those whose value is not
+	// known statically
+	vars    map[string]string
+	unknown map[string]bool
+	// Set when an unknown variable was expanded
+	unresolved bool
+	// Variables whose value the word being evaluated needs
+	required map[string]bool
+}
+
+func newEvaluator(env map[string]string, scriptPath string) *evaluator {
+	return &evaluator{
+		env:        env,
+		scriptPath: scriptPath,
+		vars:       make(map[string]string),
+		unknown:    make(map[string]bool),
+	}
+}
+
+// Value of word, false if it cannot be known statically
+func (e *evaluator) eval(word *syntax.Word) (string, bool) {
+	// Words are evaluated nested in command substitutions
+	outer, outerRequired := e.unresolved, e.required
+	e.unresolved, e.required = false, requiredParams(word)
+	value, err := expand.Literal(e.config(), word)
+	unresolved := e.unresolved
+	e.unresolved, e.required = outer, outerRequired
+	if err != nil || unresolved {
+		return "", false
+	}
+	return value, true
+}
+
+func (e *evaluator) config() *expand.Config {
+	return &expand.Config{
+		Env:      evaluatorEnviron{e},
+		CmdSubst: e.cmdSubst,
+		ProcSubst: func(*syntax.ProcSubst) (string, error) {
+			return "", errNotStatic
+		},
+	}
+}
+
+// Track the variables assigned by node
+func (e *evaluator) track(node syntax.Node) {
+	switch n := node.(type) {
+	case *syntax.CallExpr:
+		// Assignments prefixing a command only apply to the command
+		if len(n.Args) == 0 {
+			for _, assign := range n.Assigns {
+				e.assign(assign)
+			}
+		}
+	case *syntax.DeclClause:
+		for _, assign := range n.Args {
+			if !assign.Naked {
+				e.assign(assign)
+			}
+		}
+	case *syntax.WordIter:
+		e.setUnknown(n.Name.Value)
+	}
+}
+
+func (e *evaluator) assign(assign *syntax.Assign) {
+	if assign.Name == nil {
+		return
+	}
+	name := assign.Name.Value
+	if assign.Index != nil || assign.Array != nil {
+		e.setUnknown(name)
+		return
+	}
+
+	value, ok := e.eval(assign.Value)
+	if !ok {
+		e.setUnknown(name)
+		return
+	}
+	if assign.Append {
+		previous := e.variable(name)
+		if e.unresolved {
+			e.unresolved = false
+			e.setUnknown(name)
+			return
+		}
+		value = previous.String() + value
+	}
+	e.vars[name] = value
+	delete(e.unknown, name)
+}
+
+func (e *evaluator) setUnknown(name string) {
+	delete(e.vars, name)
+	e.unknown[name] = true
+}
+
+// Variable name, marking the evaluation as unresolved if its value is not
+// known statically
+func (e *evaluator) variable(name string) expand.Variable {
+	if e.unknown[name] {
+		e.unresolved = true
+		return expand.Variable{}
+	}
+	if value, ok := e.vars[name]; ok {
+		return stringVariable(value)
+	}
+	switch name {
+	case "0", "BASH_SOURCE":
+		if e.scriptPath == "" {
+			e.unresolved = true
+			return expand.Variable{}
+		}
+		if name == "BASH_SOURCE" {
+			return expand.Variable{Set: true, Kind: expand.Indexed, List: []string{e.scriptPath}}
+		}
+		return stringVariable(e.scriptPath)
+	}
+	if value, ok := e.env[name]; ok {
+		return stringVariable(value)
+	}
+	// Assigned later or by another script, unless only its default is used
+	if e.required[name] {
+		e.unresolved = true
+	}
+	return expand.Variable{}
+}
+
+// Names of the parameters expanded in word that need a value, unlike the ones
+// expanded with a value for when they are unset, like `${DIR:-/usr/lib}`
+func requiredParams(word *syntax.Word) map[string]bool {
+	required := make(map[string]bool)
+	syntax.Walk(word, func(node syntax.Node) bool {
+		paramExp, ok := node.(*syntax.ParamExp)
+		if !ok || paramExp.Param == nil {
+			return true
+		}
+		if paramExp.Exp != nil {
+			switch paramExp.Exp.Op {
+			case syntax.AlternateUnset, syntax.AlternateUnsetOrNull,
+				syntax.DefaultUnset, syntax.DefaultUnsetOrNull,
+				syntax.AssignUnset, syntax.AssignUnsetOrNull:
+				return true
+			}
+		}
+		required[paramExp.Param.Value] = true
+		return true
+	})
+	return required
+}
+
+func stringVariable(value string) expand.Variable {
+	return expand.Variable{Set: true, Kind: expand.String, Str: value}
+}
+
+// Output of the command substitution, for the commands that only depend on
+// their arguments
+func (e *evaluator) cmdSubst(w io.Writer, cs *syntax.CmdSubst) error {
+	if len(cs.Stmts) != 1 {
+		return errNotStatic
+	}
+
+	switch cmd := cs.Stmts[0].Cmd.(type) {
+	case *syntax.CallExpr:
+		output, err := e.evalCall(cmd)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, output)
+		return err
+
+	case *syntax.BinaryCmd:
+		// `cd DIR && pwd` prints DIR as absolute path
+		cd, okCd := cmd.X.Cmd.(*syntax.CallExpr)
+		pwd, okPwd := cmd.Y.Cmd.(*syntax.CallExpr)
+		if cmd.Op != syntax.AndStmt || !okCd || !okPwd {
+			return errNotStatic
+		}
+		name, _, args, err := e.evalArgs(cd)
+		if err != nil || name != "cd" || len(args) != 1 {
+			return errNotStatic
+		}
+		if name, _, _, err := e.evalArgs(pwd); err != nil || name != "pwd" {
+			return errNotStatic
+		}
+		_, err = fmt.Fprintln(w, filepath.Clean(args[0]))
+		return err
+	}
+
+	return errNotStatic
+}
+
+func (e *evaluator) evalCall(call *syntax.CallExpr) (string, error) {
+	name, options, args, err := e.evalArgs(call)
+	if err != nil {
+		return "", err
+	}
+
+	switch name {
+	case "echo":
+		return strings.Join(args, " "), nil
+	case "dirname", "basename", "realpath":
+	case "readlink":
+		// Without `-f` or similar, readlink does not canonicalize
+		if !slices.ContainsFunc(options, func(option string) bool {
+			return strings.ContainsAny(option, "fem")
+		}) {
+			return "", errNotStatic
+		}
+	default:
+		return "", errNotStatic
+	}
+	if len(args) != 1 {
+		return "", errNotStatic
+	}
+
+	switch name {
+	case "dirname":
+		return filepath.Dir(args[0]), nil
+	case "basename":
+		return filepath.Base(args[0]), nil
+	default:
+		return filepath.Clean(args[0]), nil
+	}
+}
+
+// Command name, options and arguments of call
+func (e *evaluator) evalArgs(call *syntax.CallExpr) (string, []string, []string, error) {
+	if len(call.Assigns) > 0 || len(call.Args) == 0 {
+		return "", nil, nil, errNotStatic
+	}
+
+	name, ok := e.eval(call.Args[0])
+	if !ok {
+		return "", nil, nil, errNotStatic
+	}
+	var options, args []string
+	for _, arg := range call.Args[1:] {
+		field, ok := e.eval(arg)
+		if !ok {
+			return "", nil, nil, errNotStatic
+		}
+		// Options end with `--` or the first argument
+		if len(args) == 0 && !slices.Contains(options, "--") &&
+			strings.HasPrefix(field, "-") && len(field) > 1 {
+			options = append(options, field)
+		} else {
+			args = append(args, field)
+		}
+	}
+	return name, options, args, nil
+}
+
+// Variables of the evaluator for the expansion of words
+type evaluatorEnviron struct {
+	e *evaluator
+}
+
+func (env evaluatorEnviron) Get(name string) expand.Variable {
+	return env.e.variable(name)
+}
+
+func (env evaluatorEnviron) Each(fn func(name string, vr expand.Variable) bool) {
+	for name, value := range env.e.env {
+		if !fn(name, stringVariable(value)) {
+			return
+		}
+	}
+	for name, value := range env.e.vars {
+		if !fn(name, stringVariable(value)) {
+			return
+		}
+	}
+}
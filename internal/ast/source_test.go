@@ -0,0 +1,150 @@
+package ast
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func Test_FindSourceStatments(t *testing.T) {
+	env := map[string]string{"HOME": "/home/user"}
+	scriptPath := "/project/bin/main.sh"
+
+	tests := []struct {
+		name  string
+		input string
+		want  []string
+	}{
+		{"literal", `source ./lib.sh`, []string{"./lib.sh"}},
+		{"dot", `. "lib dir/lib.sh"`, []string{"lib dir/lib.sh"}},
+		{"environment", `source "$HOME/.bashrc"`, []string{"/home/user/.bashrc"}},
+		{"dirname of BASH_SOURCE", `source "$(dirname "${BASH_SOURCE[0]}")/lib/common.sh"`, []string{"/project/bin/lib/common.sh"}},
+		{"dirname of $0", `source "$(dirname "$0")/lib.sh"`, []string{"/project/bin/lib.sh"}},
+		{"suffix removal", `source "${0%/*}/y.sh"`, []string{"/project/bin/y.sh"}},
+		{"BASH_SOURCE suffix removal", `source "${BASH_SOURCE%/*}/../lib.sh"`, []string{"/project/bin/../lib.sh"}},
+		{"prefix removal", "name=lib-common.sh\nsource \"${name##*-}\"", []string{"common.sh"}},
+		{
+			"script variable",
+			"SCRIPT_DIR=\"$(cd \"$(dirname \"${BASH_SOURCE[0]}\")\" && pwd)\"\n. \"${SCRIPT_DIR}/x.sh\"",
+			[]string{"/project/bin/x.sh"},
+		},
+		{"readonly", "readonly LIB=\"$(dirname \"$0\")/lib\"\nsource \"$LIB/a.sh\"", []string{"/project/bin/lib/a.sh"}},
+		{"default value", `source "${LIB_DIR:-/usr/lib}/lib.sh"`, []string{"/usr/lib/lib.sh"}},
+		{"assigned after", "source \"$DIR/lib.sh\"\nDIR=/opt", []string{}},
+		{"not assigned", `source "$LIB_DIR/lib.sh"`, []string{}},
+		{"default and plain", `source "${LIB_DIR:-/usr/lib}/$LIB_DIR.sh"`, []string{}},
+		{"unknown command substitution", `source "$(find . -name lib.sh)"`, []string{}},
+		{"unknown variable", "DIR=$(mktemp -d)\nsource \"$DIR/lib.sh\"", []string{}},
+		{"loop variable", "for f in *.sh; do source \"$f\"; done", []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fileAst, err := ParseDocument(tt.input, "", false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := []string{}
+			for _, sourceStatement := range fileAst.FindSourceStatments(env, scriptPath, nil) {
+				got = append(got, sourceStatement.SourcedFile)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("FindSourceStatments() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_FindSourceStatmentsDirectives(t *testing.T) {
+	dir := t.TempDir()
+	scriptPath := filepath.Join(dir, "bin", "main.sh")
+	for _, path := range []string{filepath.Join(dir, "lib", "common.sh"), filepath.Join(dir, "bin", "util.sh")} {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(""), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name        string
+		input       string
+		searchPaths []string
+		want        []string
+	}{
+		{
+			"source directive",
+			"# shellcheck source=../lib/common.sh\nsource \"$(find_lib)\"",
+			nil,
+			[]string{filepath.Join(dir, "lib", "common.sh")},
+		},
+		{
+			"dev null",
+			"# shellcheck source=/dev/null\nsource ./util.sh",
+			nil,
+			[]string{},
+		},
+		{
+			"trailing comment",
+			"source ./util.sh # shellcheck source=other.sh",
+			nil,
+			[]string{filepath.Join(dir, "bin", "util.sh")},
+		},
+		{
+			"file wide source path",
+			"#!/bin/bash\n# shellcheck source-path=SCRIPTDIR/../lib\necho\nsource common.sh",
+			nil,
+			[]string{filepath.Join(dir, "lib", "common.sh")},
+		},
+		{
+			"configured search path",
+			"source common.sh\nsource util.sh",
+			[]string{filepath.Join(dir, "lib")},
+			[]string{filepath.Join(dir, "lib", "common.sh"), filepath.Join(dir, "bin", "util.sh")},
+		},
+		{
+			"directive only for the next command",
+			"echo\n# shellcheck source-path=../lib\nsource common.sh\nsource common.sh",
+			nil,
+			[]string{filepath.Join(dir, "lib", "common.sh"), filepath.Join(dir, "bin", "common.sh")},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fileAst, err := ParseDocument(tt.input, "", false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := []string{}
+			for _, sourceStatement := range fileAst.FindSourceStatments(nil, scriptPath, tt.searchPaths) {
+				got = append(got, sourceStatement.ResolvedFile)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("FindSourceStatments() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_FindDynamicSourceStatements(t *testing.T) {
+	input := "source ./lib.sh\nsource \"$(find . -name lib.sh)\"\nfor f in *.sh; do source \"$f\"; done\n# shellcheck source=lib.sh\nsource \"$LIB\""
+	fileAst, err := ParseDocument(input, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := []string{}
+	for _, sourceStatement := range fileAst.FindDynamicSourceStatements(nil, "/project/main.sh", nil) {
+		if sourceStatement.ResolvedFile != "" {
+			t.Errorf("expected no resolved file, got %s", sourceStatement.ResolvedFile)
+		}
+		got = append(got, sourceStatement.SourcedFile)
+	}
+	want := []string{`"$(find . -name lib.sh)"`, `"$f"`}
+	if !slices.Equal(got, want) {
+		t.Errorf("FindDynamicSourceStatements() = %v, want %v", got, want)
+	}
+}
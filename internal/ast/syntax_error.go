@@ -0,0 +1,347 @@
+package ast
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// Stable code of a syntax error, reported as code of its diagnostic
+type SyntaxErrorCode string
+
+const (
+	// Block, substitution, expansion or quote without its closing token,
+	// like an `if` without `fi`
+	CodeUnclosed SyntaxErrorCode = "unclosed"
+	// Compound command without a mandatory keyword, like a `while` without
+	// `do`
+	CodeMissingKeyword SyntaxErrorCode = "missing-keyword"
+	// Operator or keyword without the command following it, like a trailing
+	// `|`
+	CodeMissingCommand SyntaxErrorCode = "missing-command"
+	// Operator without its operand, like a redirection without file
+	CodeMissingWord SyntaxErrorCode = "missing-word"
+	// Feature of another dialect, like arrays in POSIX shell
+	CodeUnsupported SyntaxErrorCode = "unsupported-feature"
+	// Any other syntax error
+	CodeSyntax SyntaxErrorCode = "syntax-error"
+)
+
+// Syntax error of a document from Start up to End, exclusively
+type SyntaxError struct {
+	Code    SyntaxErrorCode
+	Message string
+	Start   Cursor
+	End     Cursor
+	// Token opening the construct the error spans, like the `if` without
+	// `fi`, if the error extends beyond it
+	Opener *Region
+}
+
+// Find the syntax errors of a document. Errors the parser can recover from,
+// like missing closing tokens, are reported all, followed by the first error
+// it cannot recover from, if any.
+func FindSyntaxErrors(documentText, documentName string, dialect Dialect) []SyntaxError {
+	file, err := newParser(documentText, documentName, true, dialect).
+		Parse(strings.NewReader(documentText), documentName)
+
+	syntaxErrors := recoveredErrors(file, documentText)
+	if err != nil {
+		syntaxErrors = append(syntaxErrors, parseError(err, documentText))
+	}
+	slices.SortStableFunc(syntaxErrors, func(a, b SyntaxError) int {
+		return cmp.Or(cmp.Compare(a.Start.Line, b.Start.Line), cmp.Compare(a.Start.Col, b.Start.Col))
+	})
+	return syntaxErrors
+}
+
+// Token a construct is missing, which the parser recovered
+type missingToken struct {
+	code     SyntaxErrorCode
+	opener   string
+	position syntax.Pos
+	// Closing token or keyword, empty for a command or word
+	missing string
+	// Whether the parser noticed it at the end of the document, like for
+	// quotes, which extend up to there
+	atEOF bool
+}
+
+func recoveredErrors(file *syntax.File, documentText string) []SyntaxError {
+	if file == nil {
+		return nil
+	}
+
+	var syntaxErrors []SyntaxError
+	// Conditions of elif and else share the `fi` of their if
+	elseClauses := map[*syntax.IfClause]bool{}
+	// End of the content parsed so far, which is where the parser noticed
+	// missing closing tokens
+	lastEnd := uint(0)
+
+	var visited []syntax.Node
+	syntax.Walk(file, func(node syntax.Node) bool {
+		if node != nil {
+			visited = append(visited, node)
+			lastEnd = max(lastEnd, parsedEnd(node))
+			if ifClause, ok := node.(*syntax.IfClause); ok && ifClause.Else != nil {
+				elseClauses[ifClause.Else] = true
+			}
+			return true
+		}
+		node = visited[len(visited)-1]
+		visited = visited[:len(visited)-1]
+
+		for _, missing := range missingTokens(node, documentText, elseClauses[asIfClause(node)]) {
+			syntaxErrors = append(syntaxErrors, missing.syntaxError(documentText, lastEnd))
+		}
+		return true
+	})
+	return syntaxErrors
+}
+
+// End of node, or of its last keyword if the parser recovered its end
+func parsedEnd(node syntax.Node) uint {
+	if node.End().IsValid() {
+		return node.End().Offset()
+	}
+	keywordEnd := func(position syntax.Pos, keyword string) uint {
+		if !position.IsValid() {
+			return 0
+		}
+		return position.Offset() + uint(len(keyword))
+	}
+	switch n := node.(type) {
+	case *syntax.IfClause:
+		return keywordEnd(n.ThenPos, "then")
+	case *syntax.WhileClause:
+		return keywordEnd(n.DoPos, "do")
+	case *syntax.ForClause:
+		return keywordEnd(n.DoPos, "do")
+	case *syntax.CaseClause:
+		return keywordEnd(n.In, "in")
+	}
+	return 0
+}
+
+func asIfClause(node syntax.Node) *syntax.IfClause {
+	ifClause, _ := node.(*syntax.IfClause)
+	return ifClause
+}
+
+// Tokens missing in node, which the parser recovered
+func missingTokens(node syntax.Node, documentText string, isElse bool) []missingToken {
+	var missing []missingToken
+	closer := func(opener string, position syntax.Pos, closing syntax.Pos, token string) {
+		if closing.IsRecovered() {
+			missing = append(missing, missingToken{CodeUnclosed, opener, position, token, false})
+		}
+	}
+	quote := func(opener string, position syntax.Pos, closing syntax.Pos, token string) {
+		if closing.IsRecovered() {
+			missing = append(missing, missingToken{CodeUnclosed, opener, position, token, true})
+		}
+	}
+	keyword := func(opener string, position syntax.Pos, keywordPos syntax.Pos, token string) {
+		if keywordPos.IsRecovered() {
+			missing = append(missing, missingToken{CodeMissingKeyword, opener, position, token, false})
+		}
+	}
+	command := func(opener string, position syntax.Pos, stmts ...*syntax.Stmt) {
+		for _, stmt := range stmts {
+			if stmt != nil && stmt.Position.IsRecovered() {
+				missing = append(missing, missingToken{CodeMissingCommand, opener, position, "", false})
+				return
+			}
+		}
+	}
+	word := func(opener string, position syntax.Pos, operand syntax.Node) {
+		if operand != nil && operand.Pos().IsRecovered() {
+			missing = append(missing, missingToken{CodeMissingWord, opener, position, "", false})
+		}
+	}
+
+	switch n := node.(type) {
+	case *syntax.IfClause:
+		opener := "if"
+		if isElse {
+			opener = "else"
+			if strings.HasPrefix(documentText[n.Position.Offset():], "elif") {
+				opener = "elif"
+			}
+		}
+		command(opener, n.Position, n.Cond...)
+		keyword(opener, n.Position, n.ThenPos, "then")
+		if n.ThenPos.IsValid() {
+			command("then", n.ThenPos, n.Then...)
+		} else {
+			command(opener, n.Position, n.Then...)
+		}
+		if !isElse {
+			closer(opener, n.Position, n.FiPos, "fi")
+		}
+	case *syntax.WhileClause:
+		opener := "while"
+		if n.Until {
+			opener = "until"
+		}
+		command(opener, n.WhilePos, n.Cond...)
+		keyword(opener, n.WhilePos, n.DoPos, "do")
+		command("do", n.DoPos, n.Do...)
+		closer(opener, n.WhilePos, n.DonePos, "done")
+	case *syntax.ForClause:
+		opener := "for"
+		if n.Select {
+			opener = "select"
+		}
+		keyword(opener, n.ForPos, n.DoPos, "do")
+		command("do", n.DoPos, n.Do...)
+		closer(opener, n.ForPos, n.DonePos, "done")
+	case *syntax.CaseClause:
+		keyword("case", n.Case, n.In, "in")
+		closer("case", n.Case, n.Esac, "esac")
+	case *syntax.Block:
+		closer("{", n.Lbrace, n.Rbrace, "}")
+	case *syntax.Subshell:
+		closer("(", n.Lparen, n.Rparen, ")")
+	case *syntax.CmdSubst:
+		if n.Backquotes {
+			quote("`", n.Left, n.Right, "`")
+		} else {
+			closer("$(", n.Left, n.Right, ")")
+		}
+	case *syntax.ProcSubst:
+		closer(n.Op.String(), n.OpPos, n.Rparen, ")")
+	case *syntax.ParamExp:
+		closer("${", n.Dollar, n.Rbrace, "}")
+	case *syntax.ArrayExpr:
+		closer("(", n.Lparen, n.Rparen, ")")
+	case *syntax.ParenTest:
+		closer("(", n.Lparen, n.Rparen, ")")
+	case *syntax.SglQuoted:
+		if n.Dollar {
+			quote("$'", n.Left, n.Right, "'")
+		} else {
+			quote("'", n.Left, n.Right, "'")
+		}
+	case *syntax.DblQuoted:
+		if n.Dollar {
+			quote(`$"`, n.Left, n.Right, `"`)
+		} else {
+			quote(`"`, n.Left, n.Right, `"`)
+		}
+	case *syntax.BinaryCmd:
+		command(n.Op.String(), n.OpPos, n.Y)
+	case *syntax.Redirect:
+		if n.Word != nil {
+			word(n.Op.String(), n.OpPos, n.Word)
+		}
+	case *syntax.BinaryTest:
+		word(n.Op.String(), n.OpPos, n.Y)
+	case *syntax.UnaryTest:
+		word(n.Op.String(), n.OpPos, n.X)
+	}
+	return missing
+}
+
+// Syntax error of the missing token, which the parser noticed at the offset
+// failedAt. Unclosed constructs span from their opener to there.
+func (m missingToken) syntaxError(documentText string, failedAt uint) SyntaxError {
+	start := int(m.position.Offset())
+	openerEnd := min(start+len(m.opener), len(documentText))
+	opener := Region{
+		Start: cursorAtOffset(documentText, start),
+		End:   cursorAtOffset(documentText, openerEnd),
+		Text:  m.opener,
+	}
+
+	if m.atEOF {
+		failedAt = uint(len(documentText))
+	}
+	syntaxError := SyntaxError{Code: m.code, Start: opener.Start, End: opener.End}
+	switch m.code {
+	case CodeUnclosed:
+		syntaxError.Message = fmt.Sprintf("`%s` is not closed with `%s`", m.opener, m.missing)
+	case CodeMissingKeyword:
+		syntaxError.Message = fmt.Sprintf("`%s` must be followed by `%s`", m.opener, m.missing)
+	case CodeMissingCommand:
+		syntaxError.Message = fmt.Sprintf("`%s` must be followed by a command", m.opener)
+	case CodeMissingWord:
+		syntaxError.Message = fmt.Sprintf("`%s` must be followed by a word", m.opener)
+	}
+	if (m.code == CodeUnclosed || m.code == CodeMissingKeyword) && int(failedAt) > openerEnd {
+		syntaxError.End = cursorAtOffset(documentText, min(int(failedAt), len(documentText)))
+		syntaxError.Opener = &opener
+	}
+	return syntaxError
+}
+
+// Syntax error of an error returned by the parser
+func parseError(err error, documentText string) SyntaxError {
+	var parseErr syntax.ParseError
+	var langErr syntax.LangError
+	var quoteErr *syntax.QuoteError
+	switch {
+	case errors.As(err, &parseErr):
+		return positionedError(CodeSyntax, parseErr.Text, parseErr.Pos, documentText)
+	case errors.As(err, &langErr):
+		langErr.Filename = ""
+		message := strings.TrimPrefix(langErr.Error(), langErr.Pos.String()+": ")
+		return positionedError(CodeUnsupported, message, langErr.Pos, documentText)
+	case errors.As(err, &quoteErr):
+		offset := min(quoteErr.ByteOffset, len(documentText))
+		cursor := cursorAtOffset(documentText, offset)
+		return SyntaxError{Code: CodeSyntax, Message: quoteErr.Message, Start: cursor, End: cursor}
+	}
+	return SyntaxError{Code: CodeSyntax, Message: err.Error(), Start: Cursor{1, 1}, End: Cursor{1, 1}}
+}
+
+// Syntax error at the token starting at position. Errors on reaching the end
+// of the document, like an unclosed quote, extend to there.
+func positionedError(code SyntaxErrorCode, message string, position syntax.Pos, documentText string) SyntaxError {
+	start := min(int(position.Offset()), len(documentText))
+	end := start + tokenLength(documentText[start:])
+	syntaxError := SyntaxError{
+		Code:    code,
+		Message: message,
+		Start:   cursorAtOffset(documentText, start),
+		End:     cursorAtOffset(documentText, end),
+	}
+	if strings.HasPrefix(message, "reached EOF") {
+		if strings.Contains(message, "quote") || strings.Contains(message, "matching") {
+			syntaxError.Code = CodeUnclosed
+		}
+		opener := Region{Start: syntaxError.Start, End: syntaxError.End, Text: documentText[start:end]}
+		syntaxError.Opener = &opener
+		syntaxError.End = cursorAtOffset(documentText, len(documentText))
+	}
+	return syntaxError
+}
+
+// Length of the word or operator at the start of text, at least one byte
+// unless text is empty
+func tokenLength(text string) int {
+	if text == "" {
+		return 0
+	}
+	for _, operator := range []string{"$((", "$(", "${", "$'", `$"`, "[[", "((", "<(", ">("} {
+		if strings.HasPrefix(text, operator) {
+			return len(operator)
+		}
+	}
+	isWordByte := func(b byte) bool {
+		return b >= 0x80 || unicode.IsLetter(rune(b)) || unicode.IsDigit(rune(b)) || b == '_' || b == '-'
+	}
+	length := 1
+	if isWordByte(text[0]) {
+		for length < len(text) && isWordByte(text[length]) {
+			length++
+		}
+	}
+	return length
+}
@@ -0,0 +1,57 @@
+package ast
+
+import "testing"
+
+func Test_FindSyntaxErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		input      string
+		dialect    Dialect
+		wantCode   SyntaxErrorCode
+		wantStart  Cursor
+		wantEnd    Cursor
+		wantOpener string
+	}{
+		{"unclosed if", "if true; then\n  echo a\n", "", CodeUnclosed, Cursor{1, 1}, Cursor{2, 9}, "if"},
+		{"unclosed for", "for x in a b; do\n", "", CodeUnclosed, Cursor{1, 1}, Cursor{1, 17}, "for"},
+		{"unclosed quote", "echo 'abc\nfoo\n", "", CodeUnclosed, Cursor{1, 6}, Cursor{3, 1}, "'"},
+		{"unclosed test", "[[ -f ]]\n", "", CodeUnclosed, Cursor{1, 1}, Cursor{2, 1}, "[["},
+		{"missing command", "ls |\n", "", CodeMissingCommand, Cursor{1, 4}, Cursor{1, 5}, ""},
+		{"missing word", "echo >\n", "", CodeMissingWord, Cursor{1, 6}, Cursor{1, 7}, ""},
+		{"unsupported", "arr=(a b)\n", DialectSh, CodeUnsupported, Cursor{1, 5}, Cursor{1, 6}, ""},
+		{"unexpected token", "f() {\n  echo\n}\n}\n", "", CodeSyntax, Cursor{4, 1}, Cursor{4, 2}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			syntaxErrors := FindSyntaxErrors(tt.input, "", tt.dialect)
+			if len(syntaxErrors) != 1 {
+				t.Fatalf("expected 1 syntax error, got %+v", syntaxErrors)
+			}
+			got := syntaxErrors[0]
+			if got.Code != tt.wantCode || got.Start != tt.wantStart || got.End != tt.wantEnd {
+				t.Errorf("got %s %v-%v, want %s %v-%v", got.Code, got.Start, got.End, tt.wantCode, tt.wantStart, tt.wantEnd)
+			}
+			if (got.Opener == nil) != (tt.wantOpener == "") || got.Opener != nil && got.Opener.Text != tt.wantOpener {
+				t.Errorf("got opener %+v, want %q", got.Opener, tt.wantOpener)
+			}
+		})
+	}
+}
+
+func Test_FindSyntaxErrorsAll(t *testing.T) {
+	input := "echo \"$(ls\nfoo |\n"
+	syntaxErrors := FindSyntaxErrors(input, "", "")
+	codes := []SyntaxErrorCode{CodeUnclosed, CodeUnclosed, CodeMissingCommand}
+	if len(syntaxErrors) != len(codes) {
+		t.Fatalf("expected %d syntax errors, got %+v", len(codes), syntaxErrors)
+	}
+	for i, syntaxError := range syntaxErrors {
+		if syntaxError.Code != codes[i] {
+			t.Errorf("syntaxErrors[%d].Code = %s, want %s", i, syntaxError.Code, codes[i])
+		}
+	}
+	if FindSyntaxErrors("if true; then\n  echo\nfi\n", "", "") != nil {
+		t.Error("expected no syntax errors for a valid document")
+	}
+}
@@ -0,0 +1,175 @@
+package ast
+
+import (
+	"strings"
+
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// Region of a document from Start up to End, exclusively
+type Region struct {
+	Start Cursor
+	End   Cursor
+	// Source text of the region
+	Text string
+}
+
+func (r Region) contains(line, col uint) bool {
+	cursor := Cursor{Line: line, Col: col}
+	return cursor.isAfterCursor(r.Start) && !cursor.isAfterCursor(r.End)
+}
+
+func (c *Cursor) isAfterCursor(other Cursor) bool {
+	return c.Line > other.Line || (c.Line == other.Line && c.Col >= other.Col)
+}
+
+// Cursor at the byte offset of text
+func cursorAtOffset(text string, offset int) Cursor {
+	line := strings.Count(text[:offset], "\n")
+	lineStart := strings.LastIndexByte(text[:offset], '\n') + 1
+	return Cursor{Line: uint(line) + 1, Col: uint(offset-lineStart) + 1}
+}
+
+// Whether the position is in a region with syntax errors
+func (a *Ast) IsBroken(line, col uint) bool {
+	for _, region := range a.Broken {
+		if region.contains(line, col) {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether the identifier name occurs in a region with syntax errors, where
+// its references cannot be found reliably
+func (a *Ast) IsBrokenName(name string) bool {
+	for _, region := range a.Broken {
+		text := region.Text
+		for {
+			i := strings.Index(text, name)
+			if i < 0 {
+				break
+			}
+			end := i + len(name)
+			if (i == 0 || !isIdentifierByte(text[i-1])) && (end == len(text) || !isIdentifierByte(text[end])) {
+				return true
+			}
+			text = text[end:]
+		}
+	}
+	return false
+}
+
+func isIdentifierByte(b byte) bool {
+	return b == '_' || '0' <= b && b <= '9' || 'a' <= b && b <= 'z' || 'A' <= b && b <= 'Z'
+}
+
+// Parse a document with syntax errors, for navigation. The recovering parse
+// is used if it succeeds, else the document with the statements changed since
+// goodText, the last version of the document without errors with the syntax
+// tree goodAst, blanked out. The regions with errors are recorded in Broken.
+func ParseTolerant(
+	documentText, documentName string,
+	dialect Dialect,
+	goodText string,
+	goodAst *Ast,
+) (*Ast, error) {
+	fileAst, err := ParseDocumentAs(documentText, documentName, true, dialect)
+	if err == nil {
+		fileAst.Broken = recoveredRegions(fileAst, documentText)
+		return fileAst, nil
+	}
+	if goodAst == nil {
+		return nil, err
+	}
+
+	start, end := changedStmts(documentText, goodText, goodAst)
+	blanked := documentText[:start] + blank(documentText[start:end]) + documentText[end:]
+	fileAst, blankedErr := ParseDocumentAs(blanked, documentName, false, dialect)
+	if blankedErr != nil {
+		return nil, err
+	}
+	fileAst.Broken = []Region{{
+		Start: cursorAtOffset(documentText, start),
+		End:   cursorAtOffset(documentText, end),
+		Text:  documentText[start:end],
+	}}
+	return fileAst, nil
+}
+
+// Regions of the top-level statements with positions recovered by the parser,
+// like a missing `fi`. Statements whose end is missing extend to the end of
+// the document.
+func recoveredRegions(fileAst *Ast, documentText string) []Region {
+	var regions []Region
+	for _, stmt := range fileAst.File.Stmts {
+		recovered := false
+		syntax.Walk(stmt, func(node syntax.Node) bool {
+			if node != nil && (node.Pos().IsRecovered() || node.End().IsRecovered()) {
+				recovered = true
+			}
+			return !recovered
+		})
+		if !recovered {
+			continue
+		}
+
+		endOffset := len(documentText)
+		if stmtEnd := stmt.End(); stmtEnd.IsValid() {
+			endOffset = int(stmtEnd.Offset())
+		}
+		regions = append(regions, Region{
+			Start: Cursor{Line: stmt.Pos().Line(), Col: stmt.Pos().Col()},
+			End:   cursorAtOffset(documentText, endOffset),
+			Text:  documentText[stmt.Pos().Offset():endOffset],
+		})
+	}
+	return regions
+}
+
+// Byte offsets of the lines of documentText which changed since goodText,
+// extended to the top-level statements of goodAst they overlap
+func changedStmts(documentText, goodText string, goodAst *Ast) (int, int) {
+	prefix := 0
+	for prefix < len(documentText) && prefix < len(goodText) && documentText[prefix] == goodText[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(documentText)-prefix && suffix < len(goodText)-prefix &&
+		documentText[len(documentText)-1-suffix] == goodText[len(goodText)-1-suffix] {
+		suffix++
+	}
+
+	// The statements before and after the change are at the same offsets of
+	// both texts, apart from the change in length
+	start, goodEnd := prefix, len(goodText)-suffix
+	for _, stmt := range goodAst.File.Stmts {
+		stmtStart, stmtEnd := int(stmt.Pos().Offset()), int(stmt.End().Offset())
+		if stmtStart <= goodEnd && stmtEnd >= prefix {
+			start = min(start, stmtStart)
+			goodEnd = max(goodEnd, stmtEnd)
+		}
+	}
+	end := goodEnd + len(documentText) - len(goodText)
+
+	// Whole lines
+	start = strings.LastIndexByte(documentText[:start], '\n') + 1
+	if i := strings.IndexByte(documentText[end:], '\n'); i >= 0 {
+		end += i
+	} else {
+		end = len(documentText)
+	}
+	return start, end
+}
+
+// Text with all bytes but newlines replaced by spaces, so that the positions
+// after it stay the same
+func blank(text string) string {
+	blanked := []byte(text)
+	for i, b := range blanked {
+		if b != '\n' {
+			blanked[i] = ' '
+		}
+	}
+	return string(blanked)
+}
@@ -0,0 +1,67 @@
+package ast
+
+import "testing"
+
+func Test_ParseTolerant(t *testing.T) {
+	goodText := "a=1\nf() {\n  echo $a\n}\nb=2\n"
+	goodAst, err := ParseDocument(goodText, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		input      string
+		wantBroken []Region
+	}{
+		{
+			"valid",
+			goodText,
+			nil,
+		},
+		{
+			"recovered",
+			"a=1\nif true; then\n  echo $a\n",
+			[]Region{{Cursor{2, 1}, Cursor{4, 1}, "if true; then\n  echo $a\n"}},
+		},
+		{
+			"last good",
+			"a=1\nf() {\n  if true; then\n  echo $a\n}\nb=2\n",
+			[]Region{{Cursor{2, 1}, Cursor{5, 2}, "f() {\n  if true; then\n  echo $a\n}"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fileAst, err := ParseTolerant(tt.input, "", "", goodText, goodAst)
+			if err != nil {
+				t.Fatalf("ParseTolerant() error = %v", err)
+			}
+			if len(fileAst.Broken) != len(tt.wantBroken) {
+				t.Fatalf("Broken = %+v, want %+v", fileAst.Broken, tt.wantBroken)
+			}
+			for i, region := range fileAst.Broken {
+				if region != tt.wantBroken[i] {
+					t.Errorf("Broken[%d] = %+v, want %+v", i, region, tt.wantBroken[i])
+				}
+			}
+			// Definitions outside of the broken regions are still found
+			if defs := fileAst.FindDefInFile(Cursor{1, 1}); len(defs) != 1 {
+				t.Errorf("expected the definition of a, got %v", defs)
+			}
+		})
+	}
+
+	if _, err := ParseTolerant("f() {\n  if true; then\n}\n", "", "", "", nil); err == nil {
+		t.Error("expected an error without a last good version")
+	}
+}
+
+func Test_IsBrokenName(t *testing.T) {
+	fileAst := &Ast{Broken: []Region{{Text: "if [ $abc ]; then\n  f_x"}}}
+	for name, want := range map[string]bool{"abc": true, "f_x": true, "ab": false, "f": false, "x": false} {
+		if got := fileAst.IsBrokenName(name); got != want {
+			t.Errorf("IsBrokenName(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package lsp
+
+// Custom request `bashd/sourceGraph` for the files sourcing each other. With a
+// text document, only the files connected to it are included.
+type SourceGraphRequest struct {
+	Request
+	Params SourceGraphParams `json:"params"`
+}
+
+type SourceGraphParams struct {
+	TextDocument *TextDocumentIdentifier `json:"textDocument,omitempty"`
+}
+
+type SourceGraphResponse struct {
+	Response
+	Result SourceGraph `json:"result"`
+}
+
+type SourceGraph struct {
+	Nodes []SourceGraphNode `json:"nodes"`
+	Edges []SourceGraphEdge `json:"edges"`
+}
+
+type SourceGraphNode struct {
+	URI  string `json:"uri"`
+	Open bool   `json:"open"`
+}
+
+// The file From sources the file To. Unresolved edges point to files that do
+// not exist, or, if To is empty, have the path that cannot be evaluated
+// statically as written in Text.
+type SourceGraphEdge struct {
+	From     string `json:"from"`
+	To       string `json:"to,omitempty"`
+	Text     string `json:"text,omitempty"`
+	Resolved bool   `json:"resolved"`
+}
+
+func NewSourceGraphResponse(id RequestID, graph SourceGraph) SourceGraphResponse {
+	return SourceGraphResponse{
+		Response: Response{
+			RPC: RPC_VERSION,
+			ID:  &id,
+		},
+		Result: graph,
+	}
+}
@@ -0,0 +1,35 @@
+package lsp
+
+// https://microsoft.github.io/language-server-protocol/specifications/lsp/3.17/specification/#callHierarchy_incomingCalls
+type CallHierarchyIncomingCallsRequest struct {
+	Request
+	Params CallHierarchyIncomingCallsParams `json:"params"`
+}
+
+type CallHierarchyIncomingCallsParams struct {
+	Item CallHierarchyItem `json:"item"`
+}
+
+type CallHierarchyIncomingCallsResponse struct {
+	Response
+	Result []CallHierarchyIncomingCall `json:"result"`
+}
+
+// Calls from a function or script, FromRanges are the calls in it
+type CallHierarchyIncomingCall struct {
+	From       CallHierarchyItem `json:"from"`
+	FromRanges []Range           `json:"fromRanges"`
+}
+
+func NewCallHierarchyIncomingCallsResponse(
+	id RequestID,
+	calls []CallHierarchyIncomingCall,
+) CallHierarchyIncomingCallsResponse {
+	return CallHierarchyIncomingCallsResponse{
+		Response: Response{
+			RPC: RPC_VERSION,
+			ID:  &id,
+		},
+		Result: calls,
+	}
+}
@@ -0,0 +1,35 @@
+package lsp
+
+// https://microsoft.github.io/language-server-protocol/specifications/lsp/3.17/specification/#callHierarchy_outgoingCalls
+type CallHierarchyOutgoingCallsRequest struct {
+	Request
+	Params CallHierarchyOutgoingCallsParams `json:"params"`
+}
+
+type CallHierarchyOutgoingCallsParams struct {
+	Item CallHierarchyItem `json:"item"`
+}
+
+type CallHierarchyOutgoingCallsResponse struct {
+	Response
+	Result []CallHierarchyOutgoingCall `json:"result"`
+}
+
+// Calls to a function, FromRanges are the calls in the item of the request
+type CallHierarchyOutgoingCall struct {
+	To         CallHierarchyItem `json:"to"`
+	FromRanges []Range           `json:"fromRanges"`
+}
+
+func NewCallHierarchyOutgoingCallsResponse(
+	id RequestID,
+	calls []CallHierarchyOutgoingCall,
+) CallHierarchyOutgoingCallsResponse {
+	return CallHierarchyOutgoingCallsResponse{
+		Response: Response{
+			RPC: RPC_VERSION,
+			ID:  &id,
+		},
+		Result: calls,
+	}
+}
@@ -0,0 +1,179 @@
+package lsp
+
+// Only the capabilities the server relies on are modeled
+//
+// https://microsoft.github.io/language-server-protocol/specifications/lsp/3.17/specification/#clientCapabilities
+type ClientCapabilities struct {
+	Workspace    *WorkspaceClientCapabilities    `json:"workspace,omitempty"`
+	TextDocument *TextDocumentClientCapabilities `json:"textDocument,omitempty"`
+	Window       *WindowClientCapabilities       `json:"window,omitempty"`
+	General      *GeneralClientCapabilities      `json:"general,omitempty"`
+}
+
+type WorkspaceClientCapabilities struct {
+	WorkspaceEdit          *WorkspaceEditClientCapabilities `json:"workspaceEdit,omitempty"`
+	DidChangeConfiguration *DynamicRegistrationCapabilities `json:"didChangeConfiguration,omitempty"`
+	DidChangeWatchedFiles  *DynamicRegistrationCapabilities `json:"didChangeWatchedFiles,omitempty"`
+	Diagnostics            *DiagnosticWorkspaceCapabilities `json:"diagnostics,omitempty"`
+	WorkspaceFolders       bool                             `json:"workspaceFolders"`
+	Configuration          bool                             `json:"configuration"`
+}
+
+type DiagnosticWorkspaceCapabilities struct {
+	RefreshSupport bool `json:"refreshSupport"`
+}
+
+type WorkspaceEditClientCapabilities struct {
+	DocumentChanges bool `json:"documentChanges"`
+}
+
+type DynamicRegistrationCapabilities struct {
+	DynamicRegistration bool `json:"dynamicRegistration"`
+}
+
+type TextDocumentClientCapabilities struct {
+	Hover          *HoverClientCapabilities          `json:"hover,omitempty"`
+	Completion     *CompletionClientCapabilities     `json:"completion,omitempty"`
+	DocumentSymbol *DocumentSymbolClientCapabilities `json:"documentSymbol,omitempty"`
+	Rename         *RenameClientCapabilities         `json:"rename,omitempty"`
+	Diagnostic     *DiagnosticClientCapabilities     `json:"diagnostic,omitempty"`
+}
+
+type HoverClientCapabilities struct {
+	ContentFormat []MarkupKind `json:"contentFormat,omitempty"`
+}
+
+type CompletionClientCapabilities struct {
+	CompletionItem *CompletionItemClientCapabilities `json:"completionItem,omitempty"`
+}
+
+type CompletionItemClientCapabilities struct {
+	SnippetSupport      bool         `json:"snippetSupport"`
+	DocumentationFormat []MarkupKind `json:"documentationFormat,omitempty"`
+}
+
+type DocumentSymbolClientCapabilities struct {
+	HierarchicalDocumentSymbolSupport bool `json:"hierarchicalDocumentSymbolSupport"`
+}
+
+type RenameClientCapabilities struct {
+	PrepareSupport bool `json:"prepareSupport"`
+}
+
+type DiagnosticClientCapabilities struct {
+	DynamicRegistration    bool `json:"dynamicRegistration"`
+	RelatedDocumentSupport bool `json:"relatedDocumentSupport"`
+}
+
+type WindowClientCapabilities struct {
+	WorkDoneProgress bool `json:"workDoneProgress"`
+}
+
+type GeneralClientCapabilities struct {
+	PositionEncodings []PositionEncodingKind `json:"positionEncodings,omitempty"`
+}
+
+// Markup kind for hover contents, plain text if the client does not state
+// support for markdown
+func (c *ClientCapabilities) HoverMarkupKind() MarkupKind {
+	if c.TextDocument == nil || c.TextDocument.Hover == nil {
+		return MarkupKindPlainText
+	}
+	return preferredMarkupKind(c.TextDocument.Hover.ContentFormat)
+}
+
+// Markup kind for the documentation of completion items
+func (c *ClientCapabilities) CompletionDocumentationMarkupKind() MarkupKind {
+	if item := c.completionItem(); item != nil {
+		return preferredMarkupKind(item.DocumentationFormat)
+	}
+	return MarkupKindPlainText
+}
+
+func (c *ClientCapabilities) SnippetSupport() bool {
+	item := c.completionItem()
+	return item != nil && item.SnippetSupport
+}
+
+func (c *ClientCapabilities) HierarchicalDocumentSymbolSupport() bool {
+	return c.TextDocument != nil &&
+		c.TextDocument.DocumentSymbol != nil &&
+		c.TextDocument.DocumentSymbol.HierarchicalDocumentSymbolSupport
+}
+
+func (c *ClientCapabilities) PrepareRenameSupport() bool {
+	return c.TextDocument != nil &&
+		c.TextDocument.Rename != nil &&
+		c.TextDocument.Rename.PrepareSupport
+}
+
+func (c *ClientCapabilities) DocumentChangesSupport() bool {
+	return c.Workspace != nil &&
+		c.Workspace.WorkspaceEdit != nil &&
+		c.Workspace.WorkspaceEdit.DocumentChanges
+}
+
+func (c *ClientCapabilities) DidChangeConfigurationDynamicRegistration() bool {
+	return c.Workspace != nil &&
+		c.Workspace.DidChangeConfiguration != nil &&
+		c.Workspace.DidChangeConfiguration.DynamicRegistration
+}
+
+func (c *ClientCapabilities) DidChangeWatchedFilesDynamicRegistration() bool {
+	return c.Workspace != nil &&
+		c.Workspace.DidChangeWatchedFiles != nil &&
+		c.Workspace.DidChangeWatchedFiles.DynamicRegistration
+}
+
+// Clients supporting `workspace/configuration` provide settings per workspace
+// folder
+func (c *ClientCapabilities) ConfigurationSupport() bool {
+	return c.Workspace != nil && c.Workspace.Configuration
+}
+
+// Clients supporting pull diagnostics request diagnostics themselves
+func (c *ClientCapabilities) DiagnosticPullSupport() bool {
+	return c.TextDocument != nil && c.TextDocument.Diagnostic != nil
+}
+
+// Clients supporting dynamic registration of pull diagnostics pull them once
+// the server registered them
+func (c *ClientCapabilities) DiagnosticDynamicRegistration() bool {
+	return c.TextDocument != nil &&
+		c.TextDocument.Diagnostic != nil &&
+		c.TextDocument.Diagnostic.DynamicRegistration
+}
+
+func (c *ClientCapabilities) DiagnosticRefreshSupport() bool {
+	return c.Workspace != nil &&
+		c.Workspace.Diagnostics != nil &&
+		c.Workspace.Diagnostics.RefreshSupport
+}
+
+func (c *ClientCapabilities) WorkDoneProgressSupport() bool {
+	return c.Window != nil && c.Window.WorkDoneProgress
+}
+
+func (c *ClientCapabilities) PositionEncodings() []PositionEncodingKind {
+	if c.General == nil {
+		return nil
+	}
+	return c.General.PositionEncodings
+}
+
+func (c *ClientCapabilities) completionItem() *CompletionItemClientCapabilities {
+	if c.TextDocument == nil || c.TextDocument.Completion == nil {
+		return nil
+	}
+	return c.TextDocument.Completion.CompletionItem
+}
+
+// The client lists markup kinds in order of preference
+func preferredMarkupKind(kinds []MarkupKind) MarkupKind {
+	for _, kind := range kinds {
+		if kind == MarkupKindMarkdown || kind == MarkupKindPlainText {
+			return kind
+		}
+	}
+	return MarkupKindPlainText
+}
@@ -0,0 +1,12 @@
+package lsp
+
+// https://microsoft.github.io/language-server-protocol/specifications/lsp/3.17/specification/#client_registerCapability
+type RegistrationParams struct {
+	Registrations []Registration `json:"registrations"`
+}
+
+type Registration struct {
+	ID              string `json:"id"`
+	Method          string `json:"method"`
+	RegisterOptions any    `json:"registerOptions,omitempty"`
+}
@@ -7,15 +7,15 @@ type InitializeRequest struct {
 }
 
 type InitializeRequestParams struct {
-	ProcessID             *int              `json:"processId,omitempty"`
-	ClientInfo            *ClientInfo       `json:"clientInfo,omitempty"`
-	Locale                string            `json:"locale"`
-	RootPath              *string           `json:"rootPath,omitempty"`
-	RootURI               *string           `json:"rootUri,omitempty"`
-	Trace                 *string           `json:"trace,omitempty"`
-	WorkspaceFolders      []WorkspaceFolder `json:"workspaceFolders"`
-	InitializationOptions *any              `json:"initializationOptions,omitempty"`
-	// Capabilities ClientCapabilities `json:"capabilities"`
+	ProcessID             *int               `json:"processId,omitempty"`
+	ClientInfo            *ClientInfo        `json:"clientInfo,omitempty"`
+	Locale                string             `json:"locale"`
+	RootPath              *string            `json:"rootPath,omitempty"`
+	RootURI               *string            `json:"rootUri,omitempty"`
+	Trace                 *string            `json:"trace,omitempty"`
+	WorkspaceFolders      []WorkspaceFolder  `json:"workspaceFolders"`
+	InitializationOptions *any               `json:"initializationOptions,omitempty"`
+	Capabilities          ClientCapabilities `json:"capabilities"`
 }
 
 type ClientInfo struct {
@@ -45,23 +45,54 @@ type ServerInfo struct {
 }
 
 type ServerCapabilities struct {
-	TextDocumentSync                int               `json:"textDocumentSync"`
-	DefinitionProvider              bool              `json:"definitionProvider"`
-	DeclarationProvider             bool              `json:"declarationProvider"`
-	ReferencesProvider              bool              `json:"referencesProvider"`
-	HoverProvider                   bool              `json:"hoverProvider"`
-	DocumentSymbolProvider          bool              `json:"documentSymbolProvider"`
-	WorkspaceSymbolProvider         bool              `json:"workspaceSymbolProvider"`
-	DocumentFormattingProvider      bool              `json:"documentFormattingProvider"`
-	DocumentRangeFormattingProvider bool              `json:"documentRangeFormattingProvider"`
-	CodeActionProvider              bool              `json:"codeActionProvider"`
-	ColorProvider                   bool              `json:"colorProvider"`
-	InlayHintProvider               bool              `json:"inlayHintProvider"`
-	RenameProvider                  RenameOptions     `json:"renameProvider"`
-	CompletionProvider              CompletionOptions `json:"completionProvider"`
-	DiagnosticProvider              DiagnosticOptions `json:"diagnosticProvider"`
+	PositionEncoding                PositionEncodingKind         `json:"positionEncoding,omitempty"`
+	TextDocumentSync                TextDocumentSyncOptions      `json:"textDocumentSync"`
+	DefinitionProvider              bool                         `json:"definitionProvider"`
+	DeclarationProvider             bool                         `json:"declarationProvider"`
+	ReferencesProvider              bool                         `json:"referencesProvider"`
+	DocumentHighlightProvider       bool                         `json:"documentHighlightProvider"`
+	HoverProvider                   bool                         `json:"hoverProvider"`
+	DocumentSymbolProvider          bool                         `json:"documentSymbolProvider"`
+	WorkspaceSymbolProvider         bool                         `json:"workspaceSymbolProvider"`
+	DocumentFormattingProvider      bool                         `json:"documentFormattingProvider"`
+	DocumentRangeFormattingProvider bool                         `json:"documentRangeFormattingProvider"`
+	CodeActionProvider              bool                         `json:"codeActionProvider"`
+	ColorProvider                   bool                         `json:"colorProvider"`
+	InlayHintProvider               bool                         `json:"inlayHintProvider"`
+	RenameProvider                  RenameOptions                `json:"renameProvider"`
+	CompletionProvider              CompletionOptions            `json:"completionProvider"`
+	DiagnosticProvider              *DiagnosticOptions           `json:"diagnosticProvider,omitempty"`
+	CallHierarchyProvider           bool                         `json:"callHierarchyProvider"`
+	Workspace                       *WorkspaceServerCapabilities `json:"workspace,omitempty"`
 }
 
+type WorkspaceServerCapabilities struct {
+	WorkspaceFolders WorkspaceFoldersServerCapabilities `json:"workspaceFolders"`
+}
+
+type WorkspaceFoldersServerCapabilities struct {
+	Supported           bool `json:"supported"`
+	ChangeNotifications bool `json:"changeNotifications"`
+}
+
+type TextDocumentSyncOptions struct {
+	OpenClose bool                 `json:"openClose"`
+	Change    TextDocumentSyncKind `json:"change"`
+	Save      *SaveOptions         `json:"save,omitempty"`
+}
+
+type SaveOptions struct {
+	IncludeText bool `json:"includeText"`
+}
+
+type TextDocumentSyncKind int
+
+const (
+	TextDocumentSyncNone TextDocumentSyncKind = iota
+	TextDocumentSyncFull
+	TextDocumentSyncIncremental
+)
+
 type CompletionOptions struct {
 	TriggerCharacters []string `json:"triggerCharacters"`
 	ResolveProvider   bool     `json:"resolveProvider"`
@@ -73,11 +104,24 @@ type DiagnosticOptions struct {
 	WorkspaceDiagnostics  bool    `json:"workspaceDiagnostics"`
 }
 
+// Options of a pull diagnostics provider registered dynamically. A nil
+// document selector selects the documents of the client's selector.
+type DiagnosticRegistrationOptions struct {
+	DocumentSelector []DocumentFilter `json:"documentSelector"`
+	DiagnosticOptions
+}
+
+type DocumentFilter struct {
+	Language string `json:"language,omitempty"`
+	Scheme   string `json:"scheme,omitempty"`
+	Pattern  string `json:"pattern,omitempty"`
+}
+
 type RenameOptions struct {
 	PrepareProvider bool `json:"prepareProvider"`
 }
 
-func NewInitializeResponse(id int, capabilities *ServerCapabilities, info *ServerInfo) InitializeResponse {
+func NewInitializeResponse(id RequestID, capabilities *ServerCapabilities, info *ServerInfo) InitializeResponse {
 	return InitializeResponse{
 		Response: Response{
 			RPC: RPC_VERSION,
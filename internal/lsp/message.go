@@ -1,17 +1,148 @@
 package lsp
 
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strconv"
+)
+
 type Request struct {
-	RPC    string `json:"jsonrpc"`
-	ID     int    `json:"id"`
-	Method string `json:"mehod"`
+	RPC    string    `json:"jsonrpc"`
+	ID     RequestID `json:"id"`
+	Method string    `json:"method"`
 }
 
 type Response struct {
-	RPC string `json:"jsonrpc"`
-	ID  *int   `json:"id,omitempty"`
+	RPC string     `json:"jsonrpc"`
+	ID  *RequestID `json:"id"` // null if the request ID could not be determined
 }
 
 type Notification struct {
 	RPC    string `json:"jsonrpc"`
 	Method string `json:"method"`
 }
+
+// Request sent from the server to the client
+type ServerRequest struct {
+	Request
+	Params any `json:"params,omitempty"`
+}
+
+func NewServerRequest(id RequestID, method string, params any) ServerRequest {
+	return ServerRequest{
+		Request: Request{
+			RPC:    RPC_VERSION,
+			ID:     id,
+			Method: method,
+		},
+		Params: params,
+	}
+}
+
+// Response of the client to a request of the server
+type ClientResponse struct {
+	Response
+	Result json.RawMessage `json:"result"`
+	Error  *ResponseError  `json:"error"`
+}
+
+// ID of a request, which is either a number or a string
+type RequestID struct {
+	number   int64
+	str      string
+	isString bool
+}
+
+func NewNumberID(number int) RequestID {
+	return RequestID{number: int64(number)}
+}
+
+func NewStringID(str string) RequestID {
+	return RequestID{str: str, isString: true}
+}
+
+func (id RequestID) String() string {
+	if id.isString {
+		return id.str
+	}
+	return strconv.FormatInt(id.number, 10)
+}
+
+func (id RequestID) MarshalJSON() ([]byte, error) {
+	if id.isString {
+		return json.Marshal(id.str)
+	}
+	return json.Marshal(id.number)
+}
+
+func (id *RequestID) UnmarshalJSON(data []byte) error {
+	if bytes.HasPrefix(data, []byte{'"'}) {
+		var str string
+		if err := json.Unmarshal(data, &str); err != nil {
+			return err
+		}
+		*id = NewStringID(str)
+		return nil
+	}
+
+	var number int64
+	if err := json.Unmarshal(data, &number); err != nil {
+		return errors.New("request ID must be a number or a string")
+	}
+	*id = RequestID{number: number}
+	return nil
+}
+
+// https://microsoft.github.io/language-server-protocol/specifications/lsp/3.17/specification/#responseMessage
+type ErrorResponse struct {
+	Response
+	Error ResponseError `json:"error"`
+}
+
+type ResponseError struct {
+	Code    ErrorCode `json:"code"`
+	Message string    `json:"message"`
+}
+
+type ErrorCode int
+
+const (
+	ParseError       ErrorCode = -32700
+	InvalidRequest   ErrorCode = -32600
+	MethodNotFound   ErrorCode = -32601
+	InvalidParams    ErrorCode = -32602
+	InternalError    ErrorCode = -32603
+	RequestCancelled ErrorCode = -32800
+	RequestFailed    ErrorCode = -32803
+)
+
+// Without id, the id of the response is null
+func NewErrorResponse(id *RequestID, code ErrorCode, message string) ErrorResponse {
+	return ErrorResponse{
+		Response: Response{
+			RPC: RPC_VERSION,
+			ID:  id,
+		},
+		Error: ResponseError{
+			Code:    code,
+			Message: message,
+		},
+	}
+}
+
+// Response for requests without result
+type NullResponse struct {
+	Response
+	Result *struct{} `json:"result"`
+}
+
+func NewNullResponse(id RequestID) NullResponse {
+	return NullResponse{
+		Response: Response{
+			RPC: RPC_VERSION,
+			ID:  &id,
+		},
+		Result: nil,
+	}
+}
@@ -0,0 +1,162 @@
+package lsp
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// https://microsoft.github.io/language-server-protocol/specifications/lsp/3.17/specification/#positionEncodingKind
+type PositionEncodingKind string
+
+const (
+	PositionEncodingUTF8  PositionEncodingKind = "utf-8"
+	PositionEncodingUTF16 PositionEncodingKind = "utf-16"
+	PositionEncodingUTF32 PositionEncodingKind = "utf-32"
+)
+
+// Choose the first of the client's preferred encodings. Without preference
+// UTF-16 is mandatory.
+func NegotiatePositionEncoding(clientEncodings []PositionEncodingKind) PositionEncodingKind {
+	for _, encoding := range clientEncodings {
+		switch encoding {
+		case PositionEncodingUTF8, PositionEncodingUTF16, PositionEncodingUTF32:
+			return encoding
+		}
+	}
+	return PositionEncodingUTF16
+}
+
+// Converts between positions in the negotiated encoding and byte columns as
+// used by mvdan.cc/sh. Lines and columns are 0-based on both sides. Only '\n'
+// counts as line break, a '\r' preceding it does not belong to the line.
+type Mapper struct {
+	text       string
+	encoding   PositionEncodingKind
+	lineStarts []int
+}
+
+func NewMapper(text string, encoding PositionEncodingKind) *Mapper {
+	lineStarts := []int{0}
+	for i := 0; i < len(text); i++ {
+		if text[i] == '\n' {
+			lineStarts = append(lineStarts, i+1)
+		}
+	}
+	return &Mapper{text: text, encoding: encoding, lineStarts: lineStarts}
+}
+
+func (m *Mapper) Encoding() PositionEncodingKind {
+	return m.encoding
+}
+
+// Byte offset in the text. Positions beyond the end of a line or the text are
+// clamped to the end of the line or text respectively.
+func (m *Mapper) Offset(position Position) int {
+	if int(position.Line) >= len(m.lineStarts) {
+		return len(m.text)
+	}
+	return m.lineStarts[position.Line] + byteIndex(m.lineText(position.Line), position.Character, m.encoding)
+}
+
+// 0-based line and byte column of position
+func (m *Mapper) ToByteColumn(position Position) (uint, uint) {
+	if int(position.Line) >= len(m.lineStarts) {
+		return position.Line, position.Character
+	}
+	return position.Line, uint(byteIndex(m.lineText(position.Line), position.Character, m.encoding))
+}
+
+// 0-based line and byte column of the end of the text
+func (m *Mapper) EndByteColumn() (uint, uint) {
+	line := len(m.lineStarts) - 1
+	return uint(line), uint(len(m.text) - m.lineStarts[line])
+}
+
+// Position of a 0-based line and byte column
+func (m *Mapper) FromByteColumn(line, col uint) Position {
+	return Position{Line: line, Character: m.convertColumn(line, col, PositionEncodingUTF8)}
+}
+
+// Position of a 0-based line and column counted in Unicode code points
+func (m *Mapper) FromRuneColumn(line, col uint) Position {
+	return Position{Line: line, Character: m.convertColumn(line, col, PositionEncodingUTF32)}
+}
+
+// Range of 0-based lines and byte columns
+func (m *Mapper) ByteRange(startLine, startCol, endLine, endCol uint) Range {
+	return Range{
+		Start: m.FromByteColumn(startLine, startCol),
+		End:   m.FromByteColumn(endLine, endCol),
+	}
+}
+
+// Range of 0-based lines and columns counted in Unicode code points
+func (m *Mapper) RuneRange(startLine, startCol, endLine, endCol uint) Range {
+	return Range{
+		Start: m.FromRuneColumn(startLine, startCol),
+		End:   m.FromRuneColumn(endLine, endCol),
+	}
+}
+
+func (m *Mapper) convertColumn(line, col uint, from PositionEncodingKind) uint {
+	if int(line) >= len(m.lineStarts) || from == m.encoding {
+		return col
+	}
+	lineText := m.lineText(line)
+	index := byteIndex(lineText, col, from)
+	// Columns beyond the end of the line are kept as they are
+	overflow := col - unitCount(lineText[:index], from)
+	return unitCount(lineText[:index], m.encoding) + overflow
+}
+
+// Text of a line without line break
+func (m *Mapper) lineText(line uint) string {
+	start := m.lineStarts[line]
+	end := len(m.text)
+	if int(line)+1 < len(m.lineStarts) {
+		end = m.lineStarts[line+1] - 1
+	}
+	return strings.TrimSuffix(m.text[start:end], "\r")
+}
+
+// Byte index of the character with the given number of code units in s,
+// clamped to the length of s
+func byteIndex(s string, character uint, encoding PositionEncodingKind) int {
+	if encoding == PositionEncodingUTF8 {
+		return min(int(character), len(s))
+	}
+	units := uint(0)
+	for i, r := range s {
+		if units >= character {
+			return i
+		}
+		units += runeUnits(r, encoding)
+	}
+	return len(s)
+}
+
+// Number of code units of s in encoding
+func unitCount(s string, encoding PositionEncodingKind) uint {
+	if encoding == PositionEncodingUTF8 {
+		return uint(len(s))
+	}
+	units := uint(0)
+	for _, r := range s {
+		units += runeUnits(r, encoding)
+	}
+	return units
+}
+
+func runeUnits(r rune, encoding PositionEncodingKind) uint {
+	switch encoding {
+	case PositionEncodingUTF8:
+		return uint(utf8.RuneLen(r))
+	case PositionEncodingUTF32:
+		return 1
+	}
+	// Characters outside the BMP are encoded as surrogate pairs
+	if r >= 0x10000 {
+		return 2
+	}
+	return 1
+}
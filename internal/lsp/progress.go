@@ -0,0 +1,60 @@
+package lsp
+
+// https://microsoft.github.io/language-server-protocol/specifications/lsp/3.17/specification/#window_workDoneProgress_create
+type WorkDoneProgressCreateParams struct {
+	Token string `json:"token"`
+}
+
+// https://microsoft.github.io/language-server-protocol/specifications/lsp/3.17/specification/#window_workDoneProgress_cancel
+type WorkDoneProgressCancelNotification struct {
+	Notification
+	Params WorkDoneProgressCancelParams `json:"params"`
+}
+
+type WorkDoneProgressCancelParams struct {
+	Token string `json:"token"`
+}
+
+// https://microsoft.github.io/language-server-protocol/specifications/lsp/3.17/specification/#progress
+type ProgressNotification struct {
+	Notification
+	Params ProgressParams `json:"params"`
+}
+
+type ProgressParams struct {
+	Token string `json:"token"`
+	Value any    `json:"value"` // WorkDoneProgressBegin, WorkDoneProgressReport or WorkDoneProgressEnd
+}
+
+func NewProgressNotification(token string, value any) ProgressNotification {
+	return ProgressNotification{
+		Notification: Notification{
+			RPC:    RPC_VERSION,
+			Method: "$/progress",
+		},
+		Params: ProgressParams{
+			Token: token,
+			Value: value,
+		},
+	}
+}
+
+type WorkDoneProgressBegin struct {
+	Kind        string `json:"kind"` // "begin"
+	Title       string `json:"title"`
+	Cancellable bool   `json:"cancellable"`
+	Message     string `json:"message,omitempty"`
+	Percentage  *uint  `json:"percentage,omitempty"`
+}
+
+type WorkDoneProgressReport struct {
+	Kind        string `json:"kind"` // "report"
+	Cancellable bool   `json:"cancellable"`
+	Message     string `json:"message,omitempty"`
+	Percentage  *uint  `json:"percentage,omitempty"`
+}
+
+type WorkDoneProgressEnd struct {
+	Kind    string `json:"kind"` // "end"
+	Message string `json:"message,omitempty"`
+}
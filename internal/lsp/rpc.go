@@ -20,7 +20,8 @@ func EncodeMessage(msg any) string {
 }
 
 type BaseMessage struct {
-	Method string `json:"method"`
+	ID     *RequestID `json:"id,omitempty"`
+	Method string     `json:"method"`
 }
 
 func DecodeMessage(msg []byte) (string, []byte, error) {
@@ -35,9 +36,11 @@ func DecodeMessage(msg []byte) (string, []byte, error) {
 		return "", nil, err
 	}
 
+	// Return the content also for invalid messages, so that errors can be
+	// reported to the client
 	var basemessage BaseMessage
 	if err := json.Unmarshal(content[:contentLength], &basemessage); err != nil {
-		return "", nil, err
+		return "", content[:contentLength], err
 	}
 
 	return basemessage.Method, content[:contentLength], nil
@@ -16,6 +16,12 @@ type VersionTextDocumentIdentifier struct {
 	Version int `json:"version"`
 }
 
+// Version is null for documents that are not open
+type OptionalVersionedTextDocumentIdentifier struct {
+	TextDocumentIdentifier
+	Version *int `json:"version"`
+}
+
 type TextDocumentPositionParams struct {
 	TextDocument TextDocumentIdentifier `json:"textDocument"`
 	Position     Position               `json:"position"`
@@ -28,7 +28,7 @@ type CompletionResponse struct {
 	Result []CompletionItem `json:"result"`
 }
 
-func NewCompletionResponse(id int, completionList []CompletionItem) CompletionResponse {
+func NewCompletionResponse(id RequestID, completionList []CompletionItem) CompletionResponse {
 	return CompletionResponse{
 		Response: Response{
 			RPC: RPC_VERSION,
@@ -1,8 +1,9 @@
 package lsp
 
+// https://microsoft.github.io/language-server-protocol/specifications/lsp/3.17/specification/#textDocument_declaration
 type DeclarationRequest struct {
 	Request
-	Params DefinitionParams `json:"params"`
+	Params DeclarationParams `json:"params"`
 }
 
 type DeclarationParams struct {
@@ -14,34 +15,15 @@ type DeclarationResponse struct {
 	Result DeclarationResult `json:"result"`
 }
 
-type DeclarationResult struct {
-	Location
-}
+// Locations of all candidate declarations
+type DeclarationResult = DefinitionResult
 
-func NewDeclarationResponse(
-	id int,
-	documentURI string,
-	startLine, startChar, endLine, endChar uint,
-) *DeclarationResponse {
-	return &DeclarationResponse{
+func NewDeclarationResponse(id RequestID, locations []Location) DeclarationResponse {
+	return DeclarationResponse{
 		Response: Response{
 			RPC: RPC_VERSION,
 			ID:  &id,
 		},
-		Result: DeclarationResult{
-			Location: Location{
-				URI: documentURI,
-				Range: Range{
-					Start: Position{
-						Line:      startLine,
-						Character: startChar,
-					},
-					End: Position{
-						Line:      endLine,
-						Character: endChar,
-					},
-				},
-			},
-		},
+		Result: locations,
 	}
 }
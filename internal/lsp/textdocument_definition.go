@@ -1,5 +1,7 @@
 package lsp
 
+import "encoding/json"
+
 // https://microsoft.github.io/language-server-protocol/specifications/lsp/3.17/specification/#textDocument_definition
 type DefinitionRequest struct {
 	Request
@@ -15,34 +17,23 @@ type DefinitionResponse struct {
 	Result DefinitionResult `json:"result"`
 }
 
-type DefinitionResult struct {
-	Location
+// Locations of all candidate definitions
+type DefinitionResult []Location
+
+// A single definition is sent as plain location, which all clients support
+func (r DefinitionResult) MarshalJSON() ([]byte, error) {
+	if len(r) == 1 {
+		return json.Marshal(r[0])
+	}
+	return json.Marshal([]Location(r))
 }
 
-func NewDefinitionResponse(
-	id int,
-	documentURI string,
-	startLine, startChar, endLine, endChar uint,
-) DefinitionResponse {
+func NewDefinitionResponse(id RequestID, locations []Location) DefinitionResponse {
 	return DefinitionResponse{
 		Response: Response{
 			RPC: RPC_VERSION,
 			ID:  &id,
 		},
-		Result: DefinitionResult{
-			Location: Location{
-				URI: documentURI,
-				Range: Range{
-					Start: Position{
-						Line:      startLine,
-						Character: startChar,
-					},
-					End: Position{
-						Line:      endLine,
-						Character: endChar,
-					},
-				},
-			},
-		},
+		Result: locations,
 	}
 }
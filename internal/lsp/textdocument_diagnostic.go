@@ -0,0 +1,65 @@
+package lsp
+
+// https://microsoft.github.io/language-server-protocol/specifications/lsp/3.17/specification/#textDocument_diagnostic
+type DocumentDiagnosticRequest struct {
+	Request
+	Params DocumentDiagnosticParams `json:"params"`
+}
+
+type DocumentDiagnosticParams struct {
+	TextDocument     TextDocumentIdentifier `json:"textDocument"`
+	Identifier       *string                `json:"identifier,omitempty"`
+	PreviousResultID *string                `json:"previousResultId,omitempty"`
+}
+
+type DocumentDiagnosticResponse struct {
+	Response
+	Result any `json:"result"` // FullDocumentDiagnosticReport or UnchangedDocumentDiagnosticReport
+}
+
+func NewDocumentDiagnosticResponse(id RequestID, report any) DocumentDiagnosticResponse {
+	return DocumentDiagnosticResponse{
+		Response: Response{
+			RPC: RPC_VERSION,
+			ID:  &id,
+		},
+		Result: report,
+	}
+}
+
+type DocumentDiagnosticReportKind string
+
+const (
+	DiagnosticReportFull      DocumentDiagnosticReportKind = "full"
+	DiagnosticReportUnchanged DocumentDiagnosticReportKind = "unchanged"
+)
+
+type FullDocumentDiagnosticReport struct {
+	Kind     DocumentDiagnosticReportKind `json:"kind"`
+	ResultID string                       `json:"resultId,omitempty"`
+	Items    []Diagnostic                 `json:"items"`
+}
+
+func NewFullDocumentDiagnosticReport(resultID string, items []Diagnostic) FullDocumentDiagnosticReport {
+	if items == nil {
+		items = []Diagnostic{}
+	}
+	return FullDocumentDiagnosticReport{
+		Kind:     DiagnosticReportFull,
+		ResultID: resultID,
+		Items:    items,
+	}
+}
+
+// Report for results that did not change since the result with ResultID
+type UnchangedDocumentDiagnosticReport struct {
+	Kind     DocumentDiagnosticReportKind `json:"kind"`
+	ResultID string                       `json:"resultId"`
+}
+
+func NewUnchangedDocumentDiagnosticReport(resultID string) UnchangedDocumentDiagnosticReport {
+	return UnchangedDocumentDiagnosticReport{
+		Kind:     DiagnosticReportUnchanged,
+		ResultID: resultID,
+	}
+}
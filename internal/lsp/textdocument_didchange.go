@@ -6,10 +6,13 @@ type TextDocumentDidChangeNotification struct {
 }
 
 type DidChangeTextDocumentParams struct {
-	TextDocument   TextDocumentIdentifier           `json:"textDocument"`
+	TextDocument   VersionTextDocumentIdentifier    `json:"textDocument"`
 	ContentChanges []TextDocumentContentChangeEvent `json:"contentChanges"`
 }
 
+// Without a range, the event contains the full content of the document.
 type TextDocumentContentChangeEvent struct {
-	Text string `json:"text"`
+	Range       *Range `json:"range,omitempty"`
+	RangeLength *uint  `json:"rangeLength,omitempty"` // Deprecated, use range instead
+	Text        string `json:"text"`
 }
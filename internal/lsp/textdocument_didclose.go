@@ -0,0 +1,11 @@
+package lsp
+
+// https://microsoft.github.io/language-server-protocol/specifications/lsp/3.17/specification/#textDocument_didClose
+type DidCloseTextDocumentNotification struct {
+	Notification
+	Params DidCloseTextDocumentParams `json:"params"`
+}
+
+type DidCloseTextDocumentParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+}
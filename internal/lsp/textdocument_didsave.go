@@ -0,0 +1,12 @@
+package lsp
+
+// https://microsoft.github.io/language-server-protocol/specifications/lsp/3.17/specification/#textDocument_didSave
+type DidSaveTextDocumentNotification struct {
+	Notification
+	Params DidSaveTextDocumentParams `json:"params"`
+}
+
+type DidSaveTextDocumentParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+	Text         *string                `json:"text,omitempty"`
+}
@@ -0,0 +1,39 @@
+package lsp
+
+// https://microsoft.github.io/language-server-protocol/specifications/lsp/3.17/specification/#textDocument_documentHighlight
+type DocumentHighlightRequest struct {
+	Request
+	Params DocumentHighlightParams `json:"params"`
+}
+
+type DocumentHighlightParams struct {
+	TextDocumentPositionParams
+}
+
+type DocumentHighlightResponse struct {
+	Response
+	Result []DocumentHighlight `json:"result"`
+}
+
+type DocumentHighlight struct {
+	Range Range                 `json:"range"`
+	Kind  DocumentHighlightKind `json:"kind"`
+}
+
+type DocumentHighlightKind int
+
+const (
+	DocumentHighlightText DocumentHighlightKind = iota + 1
+	DocumentHighlightRead
+	DocumentHighlightWrite
+)
+
+func NewDocumentHighlightResponse(id RequestID, highlights []DocumentHighlight) DocumentHighlightResponse {
+	return DocumentHighlightResponse{
+		Response: Response{
+			RPC: RPC_VERSION,
+			ID:  &id,
+		},
+		Result: highlights,
+	}
+}
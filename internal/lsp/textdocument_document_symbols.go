@@ -11,11 +11,11 @@ type DocumentSymbolsParams struct {
 
 type DocumentSymbolResponse struct {
 	Response
-	Result []DocumentSymbol `json:"result"`
+	Result any `json:"result"` // []DocumentSymbol or []SymbolInformation
 }
 
 func NewDocumentSymbolResponse(
-	id int,
+	id RequestID,
 	documentSymbols []DocumentSymbol,
 ) DocumentSymbolResponse {
 	return DocumentSymbolResponse{
@@ -38,6 +38,27 @@ type DocumentSymbol struct {
 	Children       []DocumentSymbol `json:"children"`
 }
 
+// Flat symbol for clients without support for hierarchical document symbols
+type SymbolInformation struct {
+	Name          string     `json:"name"`
+	Kind          SymbolKind `json:"kind"`
+	Location      Location   `json:"location"`
+	ContainerName *string    `json:"containerName,omitempty"`
+}
+
+func NewSymbolInformationResponse(
+	id RequestID,
+	symbolInformation []SymbolInformation,
+) DocumentSymbolResponse {
+	return DocumentSymbolResponse{
+		Response: Response{
+			RPC: RPC_VERSION,
+			ID:  &id,
+		},
+		Result: symbolInformation,
+	}
+}
+
 type SymbolKind int
 
 const (
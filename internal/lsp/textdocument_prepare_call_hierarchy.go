@@ -0,0 +1,36 @@
+package lsp
+
+// https://microsoft.github.io/language-server-protocol/specifications/lsp/3.17/specification/#textDocument_prepareCallHierarchy
+type PrepareCallHierarchyRequest struct {
+	Request
+	Params CallHierarchyPrepareParams `json:"params"`
+}
+
+type CallHierarchyPrepareParams struct {
+	TextDocumentPositionParams
+}
+
+type PrepareCallHierarchyResponse struct {
+	Response
+	Result []CallHierarchyItem `json:"result"`
+}
+
+// Function or script in the call hierarchy
+type CallHierarchyItem struct {
+	Name           string     `json:"name"`
+	Kind           SymbolKind `json:"kind"`
+	Detail         string     `json:"detail,omitempty"`
+	URI            string     `json:"uri"`
+	Range          Range      `json:"range"`
+	SelectionRange Range      `json:"selectionRange"`
+}
+
+func NewPrepareCallHierarchyResponse(id RequestID, items []CallHierarchyItem) PrepareCallHierarchyResponse {
+	return PrepareCallHierarchyResponse{
+		Response: Response{
+			RPC: RPC_VERSION,
+			ID:  &id,
+		},
+		Result: items,
+	}
+}
@@ -1,31 +1,11 @@
 package lsp
 
-// type DiagnosticsRequest struct {
-// 	Request
-// 	Params DiagnosticsParams `json:"params"`
-// }
-//
-// type DiagnosticsParams struct {
-// 	TextDocument     TextDocumentIdentifier `json:"textDocument"`
-// 	Identifier       *string                `json:"identifier"`
-// 	PreviousResultID *string                `json:"previousResultId"`
-// }
-//
-// type DiagnosticResponse struct {
-// 	Response
-// 	Result DocumentDiagnosticReport `json:"result"`
-// }
-//
-// type DocumentDiagnosticReport struct  {
-//
-// }
-
 type DiagnosticNotification struct {
 	Notification
 	Params PublishDiagnosticsParams `json:"params"`
 }
 
-func NewDiagnosticNotification(uri string, diagnostics []Diagnostic) DiagnosticNotification {
+func NewDiagnosticNotification(uri string, version *int, diagnostics []Diagnostic) DiagnosticNotification {
 	return DiagnosticNotification{
 		Notification: Notification{
 			RPC:    RPC_VERSION,
@@ -33,7 +13,7 @@ func NewDiagnosticNotification(uri string, diagnostics []Diagnostic) DiagnosticN
 		},
 		Params: PublishDiagnosticsParams{
 			URI:         uri,
-			Version:     nil,
+			Version:     version,
 			Diagnostics: diagnostics,
 		},
 	}
@@ -53,10 +33,17 @@ type Diagnostic struct {
 	Source  string `json:"source"`
 	Message string `json:"message"`
 	// Tags
-	// RelatedInformation
+	RelatedInformation []DiagnosticRelatedInformation `json:"relatedInformation,omitempty"`
 	// Data
 }
 
+// Location related to a diagnostic, like the opening token of an unclosed
+// block
+type DiagnosticRelatedInformation struct {
+	Location Location `json:"location"`
+	Message  string   `json:"message"`
+}
+
 type DiagnosticSeverity int
 
 const (
@@ -16,11 +16,16 @@ type RenameResponse struct {
 }
 
 type WorkspaceEdit struct {
-	Changes map[string][]TextEdit `json:"changes"`
-	// DocumentChanges []TextDocumentEdit `json:"documentChangs"`
+	Changes         map[string][]TextEdit `json:"changes,omitempty"`
+	DocumentChanges []TextDocumentEdit    `json:"documentChanges,omitempty"`
 	// ChangeAnnotations `json:"changeAnnotations"`
 }
 
+type TextDocumentEdit struct {
+	TextDocument OptionalVersionedTextDocumentIdentifier `json:"textDocument"`
+	Edits        []TextEdit                              `json:"edits"`
+}
+
 type TextEdit struct {
 	Range   Range  `json:"range"`
 	NewText string `json:"newText"`
@@ -0,0 +1,11 @@
+package lsp
+
+// https://microsoft.github.io/language-server-protocol/specifications/lsp/3.17/specification/#workspace_configuration
+type ConfigurationParams struct {
+	Items []ConfigurationItem `json:"items"`
+}
+
+type ConfigurationItem struct {
+	ScopeURI *string `json:"scopeUri,omitempty"`
+	Section  string  `json:"section,omitempty"`
+}
@@ -0,0 +1,53 @@
+package lsp
+
+// https://microsoft.github.io/language-server-protocol/specifications/lsp/3.17/specification/#workspace_diagnostic
+type WorkspaceDiagnosticRequest struct {
+	Request
+	Params WorkspaceDiagnosticParams `json:"params"`
+}
+
+type WorkspaceDiagnosticParams struct {
+	Identifier        *string            `json:"identifier,omitempty"`
+	PreviousResultIDs []PreviousResultID `json:"previousResultIds"`
+}
+
+type PreviousResultID struct {
+	URI   string `json:"uri"`
+	Value string `json:"value"`
+}
+
+type WorkspaceDiagnosticResponse struct {
+	Response
+	Result WorkspaceDiagnosticReport `json:"result"`
+}
+
+func NewWorkspaceDiagnosticResponse(id RequestID, items []any) WorkspaceDiagnosticResponse {
+	if items == nil {
+		items = []any{}
+	}
+	return WorkspaceDiagnosticResponse{
+		Response: Response{
+			RPC: RPC_VERSION,
+			ID:  &id,
+		},
+		Result: WorkspaceDiagnosticReport{Items: items},
+	}
+}
+
+type WorkspaceDiagnosticReport struct {
+	// WorkspaceFullDocumentDiagnosticReport or
+	// WorkspaceUnchangedDocumentDiagnosticReport
+	Items []any `json:"items"`
+}
+
+type WorkspaceFullDocumentDiagnosticReport struct {
+	FullDocumentDiagnosticReport
+	URI     string `json:"uri"`
+	Version *int   `json:"version"`
+}
+
+type WorkspaceUnchangedDocumentDiagnosticReport struct {
+	UnchangedDocumentDiagnosticReport
+	URI     string `json:"uri"`
+	Version *int   `json:"version"`
+}
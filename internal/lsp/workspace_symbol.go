@@ -23,7 +23,7 @@ type WorkspaceSymbol struct {
 }
 
 func NewWorkspaceSymbolResponse(
-	id int,
+	id RequestID,
 	workspaceSymbols []WorkspaceSymbol,
 ) WorkspaceSymbolResponse {
 	return WorkspaceSymbolResponse{
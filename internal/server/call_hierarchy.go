@@ -0,0 +1,268 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"path/filepath"
+	"slices"
+
+	"github.com/matkrin/bashd/internal/ast"
+	"github.com/matkrin/bashd/internal/lsp"
+	"github.com/matkrin/bashd/internal/utils"
+	"mvdan.cc/sh/v3/syntax"
+)
+
+func handlePrepareCallHierarchy(
+	request *lsp.PrepareCallHierarchyRequest,
+	state *State,
+) *lsp.PrepareCallHierarchyResponse {
+	uri := request.Params.TextDocument.URI
+	mapper := state.NewMapper(state.Documents[uri].Text)
+	cursor := ast.NewCursorAt(mapper, request.Params.Position)
+
+	fileAst, err := state.Documents[uri].TolerantAst()
+	if err != nil {
+		slog.Error(err.Error())
+		return nil
+	}
+
+	var items []lsp.CallHierarchyItem
+	for _, definition := range findDefinitions(fileAst, cursor, uri, state) {
+		funcDecl, ok := definition.defNode.Node.(*syntax.FuncDecl)
+		if !ok {
+			continue
+		}
+		definitionMapper := mapper
+		if definition.uri != uri {
+			_, definitionMapper, err = fileAstOf(definition.uri, state)
+			if err != nil {
+				continue
+			}
+		}
+		items = append(items, functionItem(definition.uri, funcDecl, definitionMapper))
+	}
+	if len(items) == 0 {
+		return nil
+	}
+
+	response := lsp.NewPrepareCallHierarchyResponse(request.ID, items)
+	return &response
+}
+
+// Callers of the function of the item: the functions and the top-level code of
+// scripts calling it, in its file, the files it sources and the workspace files
+// sourcing it
+func handleIncomingCalls(
+	ctx context.Context,
+	request *lsp.CallHierarchyIncomingCallsRequest,
+	state *State,
+) *lsp.CallHierarchyIncomingCallsResponse {
+	item := request.Params.Item
+	if item.Kind != lsp.SymbolFunction {
+		return nil
+	}
+	fileAst, mapper, err := fileAstOf(item.URI, state)
+	if err != nil {
+		slog.Error(err.Error())
+		return nil
+	}
+	cursor := ast.NewCursorAt(mapper, item.SelectionRange.Start)
+
+	type caller struct {
+		uri      string
+		funcDecl *syntax.FuncDecl
+	}
+	var callers []caller
+	calls := map[caller]*lsp.CallHierarchyIncomingCall{}
+
+	for _, references := range findReferences(ctx, fileAst, cursor, item.URI, state, false) {
+		fileMapper := mapper
+		if references.uri != item.URI {
+			fileMapper = state.FileMapper(references.path)
+		}
+		for _, refNode := range references.refNodes {
+			if !refNode.IsCall() {
+				continue
+			}
+			from := caller{references.uri, refNode.Caller()}
+			call, ok := calls[from]
+			if !ok {
+				fromItem := scriptItem(from.uri, fileMapper)
+				if from.funcDecl != nil {
+					fromItem = functionItem(from.uri, from.funcDecl, fileMapper)
+				}
+				call = &lsp.CallHierarchyIncomingCall{From: fromItem, FromRanges: []lsp.Range{}}
+				calls[from] = call
+				callers = append(callers, from)
+			}
+			fromRange := refNode.ToLspLocation(from.uri, fileMapper).Range
+			if !slices.Contains(call.FromRanges, fromRange) {
+				call.FromRanges = append(call.FromRanges, fromRange)
+			}
+		}
+	}
+
+	incomingCalls := make([]lsp.CallHierarchyIncomingCall, 0, len(callers))
+	for _, from := range callers {
+		incomingCalls = append(incomingCalls, *calls[from])
+	}
+	response := lsp.NewCallHierarchyIncomingCallsResponse(request.ID, incomingCalls)
+	return &response
+}
+
+// Functions called by the function or top-level code of the script of the
+// item, wherever they are defined. Builtins and commands in PATH are no
+// functions, unless a function of the same name is defined in the file or
+// the files it sources.
+func handleOutgoingCalls(
+	ctx context.Context,
+	request *lsp.CallHierarchyOutgoingCallsRequest,
+	state *State,
+) *lsp.CallHierarchyOutgoingCallsResponse {
+	item := request.Params.Item
+	fileAst, mapper, err := fileAstOf(item.URI, state)
+	if err != nil {
+		slog.Error(err.Error())
+		return nil
+	}
+
+	var funcDecl *syntax.FuncDecl
+	if item.Kind == lsp.SymbolFunction {
+		funcDecl = fileAst.FindFuncDecl(ast.NewCursorAt(mapper, item.SelectionRange.Start))
+		if funcDecl == nil {
+			return nil
+		}
+	}
+
+	type callee struct {
+		uri      string
+		funcDecl *syntax.FuncDecl
+	}
+	var callees []callee
+	calls := map[callee]*lsp.CallHierarchyOutgoingCall{}
+	// Definitions by the name of the function called, resolved at its first
+	// call
+	definitionsByName := map[string][]definitionInFile{}
+	var functionNames map[string]bool
+
+	for _, refNode := range fileAst.CallsIn(funcDecl) {
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		definitions, ok := definitionsByName[refNode.Name]
+		if !ok {
+			if slices.Contains(BASH_BUILTINS[:], refNode.Name) || slices.Contains(state.PathItems, refNode.Name) {
+				if functionNames == nil {
+					functionNames = definedFunctionNames(fileAst, item.URI, state)
+				}
+				if !functionNames[refNode.Name] {
+					definitionsByName[refNode.Name] = nil
+					continue
+				}
+			}
+			cursor := ast.Cursor{Line: refNode.StartLine, Col: refNode.StartChar}
+			definitions = findDefinitions(fileAst, cursor, item.URI, state)
+			definitionsByName[refNode.Name] = definitions
+		}
+
+		for _, definition := range definitions {
+			calleeDecl, ok := definition.defNode.Node.(*syntax.FuncDecl)
+			if !ok {
+				continue
+			}
+			to := callee{definition.uri, calleeDecl}
+			call, ok := calls[to]
+			if !ok {
+				definitionMapper := mapper
+				if to.uri != item.URI {
+					if _, definitionMapper, err = fileAstOf(to.uri, state); err != nil {
+						continue
+					}
+				}
+				call = &lsp.CallHierarchyOutgoingCall{
+					To:         functionItem(to.uri, calleeDecl, definitionMapper),
+					FromRanges: []lsp.Range{},
+				}
+				calls[to] = call
+				callees = append(callees, to)
+			}
+			call.FromRanges = append(call.FromRanges, refNode.ToLspLocation(item.URI, mapper).Range)
+		}
+	}
+
+	outgoingCalls := make([]lsp.CallHierarchyOutgoingCall, 0, len(callees))
+	for _, to := range callees {
+		outgoingCalls = append(outgoingCalls, *calls[to])
+	}
+	response := lsp.NewCallHierarchyOutgoingCallsResponse(request.ID, outgoingCalls)
+	return &response
+}
+
+// Names of the functions defined in the file with uri and in the files it
+// sources
+func definedFunctionNames(fileAst *ast.Ast, uri string, state *State) map[string]bool {
+	names := map[string]bool{}
+	addNames := func(fileAst *ast.Ast) {
+		for _, defNode := range fileAst.DefNodes() {
+			if _, ok := defNode.Node.(*syntax.FuncDecl); ok {
+				names[defNode.Name] = true
+			}
+		}
+	}
+
+	addNames(fileAst)
+	for _, sourcedFile := range state.SourcedFiles(uri) {
+		if sourcedAst, err := state.parseFile(sourcedFile); err == nil {
+			addNames(sourcedAst)
+		}
+	}
+	return names
+}
+
+// Syntax tree and mapper of the open document with uri, else of the file
+func fileAstOf(uri string, state *State) (*ast.Ast, *lsp.Mapper, error) {
+	if document, ok := state.Documents[uri]; ok {
+		fileAst, err := document.TolerantAst()
+		return fileAst, state.NewMapper(document.Text), err
+	}
+	path, err := utils.UriToPath(uri)
+	if err != nil {
+		return nil, nil, err
+	}
+	fileAst, fileContent, err := state.FileAst(path)
+	return fileAst, state.NewMapper(fileContent), err
+}
+
+// Call hierarchy item of a function in the file with uri
+func functionItem(uri string, funcDecl *syntax.FuncDecl, mapper *lsp.Mapper) lsp.CallHierarchyItem {
+	endLine, endCol := nodeEnd(funcDecl, mapper)
+	path, _ := utils.UriToPath(uri)
+	return lsp.CallHierarchyItem{
+		Name:   funcDecl.Name.Value,
+		Kind:   lsp.SymbolFunction,
+		Detail: filepath.Base(path),
+		URI:    uri,
+		Range:  mapper.ByteRange(funcDecl.Pos().Line()-1, funcDecl.Pos().Col()-1, endLine, endCol),
+		SelectionRange: mapper.ByteRange(
+			funcDecl.Name.Pos().Line()-1,
+			funcDecl.Name.Pos().Col()-1,
+			funcDecl.Name.End().Line()-1,
+			funcDecl.Name.End().Col()-1,
+		),
+	}
+}
+
+// Call hierarchy item of the top-level code of the script with uri, which is
+// the whole file
+func scriptItem(uri string, mapper *lsp.Mapper) lsp.CallHierarchyItem {
+	endLine, endCol := mapper.EndByteColumn()
+	path, _ := utils.UriToPath(uri)
+	return lsp.CallHierarchyItem{
+		Name:           filepath.Base(path),
+		Kind:           lsp.SymbolFile,
+		URI:            uri,
+		Range:          mapper.ByteRange(0, 0, endLine, endCol),
+		SelectionRange: lsp.NewRange(0, 0, 0, 0),
+	}
+}
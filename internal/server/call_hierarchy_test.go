@@ -0,0 +1,118 @@
+package server
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/matkrin/bashd/internal/ast"
+	"github.com/matkrin/bashd/internal/lsp"
+	"github.com/matkrin/bashd/internal/utils"
+)
+
+func Test_callHierarchy(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"lib.sh":  "helper() {\n\techo\n}\n\ndeploy_service() {\n\thelper\n\thelper\n}\n",
+		"main.sh": "source ./lib.sh\n\nrun() {\n\tdeploy_service\n}\n\ndeploy_service\nrun\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	state := NewState(Config{})
+	state.WorkspaceFolders = []lsp.WorkspaceFolder{{URI: utils.PathToURI(dir), Name: "workspace"}}
+	libURI := utils.PathToURI(filepath.Join(dir, "lib.sh"))
+	mainURI := utils.PathToURI(filepath.Join(dir, "main.sh"))
+	state.SetDocument(mainURI, files["main.sh"], 0)
+
+	// From a call in main.sh to the declaration in lib.sh
+	prepareRequest := &lsp.PrepareCallHierarchyRequest{}
+	prepareRequest.Params.TextDocument.URI = mainURI
+	prepareRequest.Params.Position = lsp.Position{Line: 3, Character: 3}
+	prepared := handlePrepareCallHierarchy(prepareRequest, &state)
+	if prepared == nil || len(prepared.Result) != 1 {
+		t.Fatalf("expected one item, got %v", prepared)
+	}
+	deployService := prepared.Result[0]
+	if deployService.Name != "deploy_service" || deployService.URI != libURI ||
+		deployService.SelectionRange != lsp.NewRange(4, 0, 4, 14) {
+		t.Errorf("unexpected item %+v", deployService)
+	}
+
+	t.Run("incoming calls", func(t *testing.T) {
+		request := &lsp.CallHierarchyIncomingCallsRequest{}
+		request.Params.Item = deployService
+		response := handleIncomingCalls(context.Background(), request, &state)
+		if response == nil || len(response.Result) != 2 {
+			t.Fatalf("expected calls from run and the script, got %v", response)
+		}
+		run, script := response.Result[0], response.Result[1]
+		if run.From.Name != "run" || run.From.URI != mainURI ||
+			len(run.FromRanges) != 1 || run.FromRanges[0] != lsp.NewRange(3, 1, 3, 15) {
+			t.Errorf("unexpected call from run %+v", run)
+		}
+		if script.From.Name != "main.sh" || script.From.Kind != lsp.SymbolFile ||
+			len(script.FromRanges) != 1 || script.FromRanges[0] != lsp.NewRange(6, 0, 6, 14) {
+			t.Errorf("unexpected call from the script %+v", script)
+		}
+	})
+
+	t.Run("outgoing calls", func(t *testing.T) {
+		request := &lsp.CallHierarchyOutgoingCallsRequest{}
+		request.Params.Item = deployService
+		response := handleOutgoingCalls(context.Background(), request, &state)
+		if response == nil || len(response.Result) != 1 {
+			t.Fatalf("expected calls to helper, got %v", response)
+		}
+		helper := response.Result[0]
+		if helper.To.Name != "helper" || helper.To.URI != libURI || len(helper.FromRanges) != 2 {
+			t.Errorf("unexpected call to helper %+v", helper)
+		}
+	})
+
+	t.Run("outgoing calls of the script", func(t *testing.T) {
+		request := &lsp.CallHierarchyOutgoingCallsRequest{}
+		request.Params.Item = scriptItem(mainURI, state.NewMapper(files["main.sh"]))
+		response := handleOutgoingCalls(context.Background(), request, &state)
+		if response == nil || len(response.Result) != 2 {
+			t.Fatalf("expected calls to deploy_service and run, got %v", response)
+		}
+		if response.Result[0].To.Name != "deploy_service" || response.Result[1].To.Name != "run" {
+			t.Errorf("unexpected calls %+v", response.Result)
+		}
+	})
+}
+
+func Test_outgoingCallsCommands(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "main.sh")
+	content := "cd() {\n\tbuiltin cd \"$@\"\n}\n\nrun() {\n\tcd /tmp\n\techo done\n\tls\n}\n"
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	state := NewState(Config{})
+	state.PathItems = []string{"ls"}
+	uri := utils.PathToURI(path)
+	state.SetDocument(uri, content, 0)
+	mapper := state.NewMapper(content)
+	fileAst, _ := state.Documents[uri].Ast()
+
+	request := &lsp.CallHierarchyOutgoingCallsRequest{}
+	request.Params.Item = functionItem(uri, fileAst.FindFuncDecl(ast.Cursor{Line: 5, Col: 1}), mapper)
+
+	// Only the builtin defined as function
+	response := handleOutgoingCalls(context.Background(), request, &state)
+	if response == nil || len(response.Result) != 1 || response.Result[0].To.Name != "cd" {
+		t.Fatalf("expected only the call to cd, got %v", response)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if response := handleOutgoingCalls(ctx, request, &state); response != nil {
+		t.Errorf("expected no response when cancelled, got %v", response)
+	}
+}
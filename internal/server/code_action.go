@@ -2,10 +2,12 @@ package server
 
 import (
 	"bytes"
+	"context"
 
 	"github.com/matkrin/bashd/internal/ast"
 	"github.com/matkrin/bashd/internal/lsp"
 	"github.com/matkrin/bashd/internal/shellcheck"
+	"github.com/matkrin/bashd/internal/utils"
 	"mvdan.cc/sh/v3/fileutil"
 	"mvdan.cc/sh/v3/syntax"
 )
@@ -15,6 +17,7 @@ var SHEBANG = "#!/usr/bin/env bash\n\n"
 func handleCodeAction(request *lsp.CodeActionRequest, state *State) *lsp.CodeActionResponse {
 	uri := request.Params.TextDocument.URI
 	documentText := state.Documents[uri].Text
+	mapper := state.NewMapper(documentText)
 	hasShebang := fileutil.HasShebang([]byte(documentText))
 
 	var actions []lsp.CodeAction
@@ -23,24 +26,29 @@ func handleCodeAction(request *lsp.CodeActionRequest, state *State) *lsp.CodeAct
 		actions = append(actions, *action)
 	}
 
-	shellcheck, err := shellcheck.Run(documentText, state.Config.ShellCheckOptions)
+	path, _ := utils.UriToPath(uri)
+	shellcheckOptions := state.ConfigFor(uri).ShellCheckOptions.ForScript(
+		path,
+		state.Documents[uri].Dialect().ShellCheckShell(),
+	)
+	shellcheck, err := shellcheck.Run(context.Background(), documentText, shellcheckOptions)
 	if err == nil {
 		// Fix all auto-fixable
 		if shellcheck.ContainsFixable() {
-			actions = append(actions, shellcheck.ToCodeActionFlat(uri))
+			actions = append(actions, shellcheck.ToCodeActionFlat(uri, mapper))
 		}
 
 		// Fix for certain lint (position dependent)
 		context := request.Params.Context
 		if len(context.Diagnostics) != 0 {
-			shellcheckCodeActions := shellcheckCodeActions(shellcheck, uri, documentText, context)
+			shellcheckCodeActions := shellcheckCodeActions(shellcheck, uri, documentText, mapper, context)
 			if len(shellcheckCodeActions) > 0 {
 				actions = append(actions, shellcheckCodeActions...)
 			}
 		}
 	}
 
-	if fileAst, err := ast.ParseDocument(documentText, uri, false); err == nil {
+	if fileAst, err := state.Documents[uri].Ast(); err == nil {
 		actions = append(actions, *minifyCodeAction(fileAst, uri))
 	}
 
@@ -97,17 +105,18 @@ func shellcheckCodeActions(
 	shellcheck *shellcheck.ShellCheckResult,
 	uri string,
 	documentText string,
+	mapper *lsp.Mapper,
 	context lsp.CodeActionContext,
 ) []lsp.CodeAction {
 	var actions []lsp.CodeAction
 	for _, comment := range shellcheck.Comments {
-		shellcheckDiagnostic := comment.ToDiagnostic()
+		shellcheckDiagnostic := comment.ToDiagnostic(mapper)
 		for _, contextDiagnostic := range context.Diagnostics {
 			if shellcheckDiagnostic.Range != contextDiagnostic.Range {
 				continue
 			}
 			// Lint fix
-			if actionFixLint := comment.ToCodeActionFixLint(uri); actionFixLint != nil {
+			if actionFixLint := comment.ToCodeActionFixLint(uri, mapper); actionFixLint != nil {
 				actions = append(actions, *actionFixLint)
 			}
 
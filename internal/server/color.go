@@ -4,7 +4,6 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
-	"unicode/utf8"
 
 	"github.com/matkrin/bashd/internal/lsp"
 )
@@ -15,7 +14,7 @@ func handleDocumentColor(request *lsp.DocumentColorRequest, state *State) *lsp.D
 	uri := request.Params.TextDocument.URI
 	documentText := state.Documents[uri].Text
 
-	colorInformation := extractColorsFromDocument(documentText)
+	colorInformation := extractColorsFromDocument(documentText, state.NewMapper(documentText))
 
 	response := &lsp.DocumentColorResponse{
 		Response: lsp.Response{
@@ -27,7 +26,7 @@ func handleDocumentColor(request *lsp.DocumentColorRequest, state *State) *lsp.D
 	return response
 }
 
-func extractColorsFromDocument(documentText string) []lsp.ColorInformation {
+func extractColorsFromDocument(documentText string, mapper *lsp.Mapper) []lsp.ColorInformation {
 	var results []lsp.ColorInformation
 	lines := strings.Split(documentText, "\n")
 
@@ -45,15 +44,12 @@ func extractColorsFromDocument(documentText string) []lsp.ColorInformation {
 				continue
 			}
 
-			startChar := utf8.RuneCountInString(line[:startByte])
-			endChar := utf8.RuneCountInString(line[:endByte])
-
 			results = append(results, lsp.ColorInformation{
-				Range: lsp.NewRange(
+				Range: mapper.ByteRange(
 					uint(lineNum),
-					uint(startChar),
+					uint(startByte),
 					uint(lineNum),
-					uint(endChar),
+					uint(endByte),
 				),
 				Color: color,
 			})
@@ -1,8 +1,9 @@
 package server
 
 import (
-	"fmt"
+	"context"
 	"log/slog"
+	"path/filepath"
 
 	"github.com/matkrin/bashd/internal/ast"
 	"github.com/matkrin/bashd/internal/lsp"
@@ -14,8 +15,7 @@ func handleCompletion(request *lsp.CompletionRequest, state *State) *lsp.Complet
 	var completionList []lsp.CompletionItem
 
 	uri := request.Params.TextDocument.URI
-	document := state.Documents[uri].Text
-	fileAst, err := ast.ParseDocument(document, uri, true)
+	fileAst, err := state.Documents[uri].FallibleAst()
 	if err != nil {
 		slog.Error("Could not parse file", "file", uri)
 	}
@@ -25,14 +25,19 @@ func handleCompletion(request *lsp.CompletionRequest, state *State) *lsp.Complet
 		if fileAst != nil {
 			completionList = append(completionList, completeDollar(fileAst, state)...)
 		}
+		completionList = append(completionList, completionRelatedFiles(uri, state, completionVariables)...)
 	} else {
 		if fileAst != nil {
 			completionList = append(completionList, completionFunctions(fileAst)...)
 		}
+		completionList = append(completionList, completionRelatedFiles(uri, state, completionFunctions)...)
 		completionList = append(completionList, completionKeywords()...)
 		completionList = append(completionList, completionBuiltins()...)
 		completionList = append(completionList, completionPathItem(state)...)
-		completionList = append(completionList, completionSnippets()...)
+		// Snippets would be inserted literally, including their placeholders
+		if state.ClientCapabilities.SnippetSupport() {
+			completionList = append(completionList, completionSnippets()...)
+		}
 	}
 
 	response := lsp.NewCompletionResponse(request.ID, completionList)
@@ -41,15 +46,17 @@ func handleCompletion(request *lsp.CompletionRequest, state *State) *lsp.Complet
 
 // Handler for `completionItem/resolve`
 func handleCompletionItemResolve(
+	ctx context.Context,
 	request *lsp.CompletionItemResolveRequest,
+	state *State,
 ) *lsp.CompletionItemResolveResponse {
 	completionItem := request.Params.CompletionItem
-	documentation := getDocumentation(completionItem.Label)
-	mdDocumentation := fmt.Sprintf("```man\n%s\n```", documentation)
+	documentation := getDocumentation(ctx, completionItem.Label)
+	markupKind := state.ClientCapabilities.CompletionDocumentationMarkupKind()
 
 	completionItem.Documentation = &lsp.MarkupContent{
-		Kind:  lsp.MarkupKindMarkdown,
-		Value: mdDocumentation,
+		Kind:  markupKind,
+		Value: codeBlock("man", documentation, markupKind),
 	}
 
 	response := &lsp.CompletionItemResolveResponse{
@@ -64,9 +71,25 @@ func handleCompletionItemResolve(
 
 // Completion for variables defined in Document and environment variables
 func completeDollar(ast *ast.Ast, state *State) []lsp.CompletionItem {
+	result := completionVariables(ast)
+
+	// Environment variables
+	for envVarName, envVarValue := range state.EnvVars {
+		result = append(result, lsp.CompletionItem{
+			Label:         envVarName,
+			Kind:          lsp.CompletionConstant,
+			Detail:        envVarValue,
+			Documentation: nil,
+		})
+	}
+
+	return result
+}
+
+// Completion for variables assigned in ast
+func completionVariables(ast *ast.Ast) []lsp.CompletionItem {
 	var result []lsp.CompletionItem
 
-	// Variables
 	syntax.Walk(ast.File, func(node syntax.Node) bool {
 		assign, ok := node.(*syntax.Assign)
 		if !ok {
@@ -84,16 +107,28 @@ func completeDollar(ast *ast.Ast, state *State) []lsp.CompletionItem {
 		return true
 	})
 
-	// Environment variables
-	for envVarName, envVarValue := range state.EnvVars {
-		result = append(result, lsp.CompletionItem{
-			Label:         envVarName,
-			Kind:          lsp.CompletionConstant,
-			Detail:        envVarValue,
-			Documentation: nil,
-		})
-	}
+	return result
+}
 
+// Completion for definitions in the files sourced by the document with uri and
+// in the files providing its context, detailed with the file they are defined in
+func completionRelatedFiles(
+	uri string,
+	state *State,
+	complete func(*ast.Ast) []lsp.CompletionItem,
+) []lsp.CompletionItem {
+	var result []lsp.CompletionItem
+	relatedFiles := append(state.SourcedFiles(uri), state.ContextFiles(uri)...)
+	for _, relatedFile := range relatedFiles {
+		relatedAst, _, err := state.FileAst(relatedFile)
+		if err != nil {
+			continue
+		}
+		for _, completionItem := range complete(relatedAst) {
+			completionItem.Detail = filepath.Base(relatedFile)
+			result = append(result, completionItem)
+		}
+	}
 	return result
 }
 
@@ -180,7 +215,7 @@ func completionSnippets() []lsp.CompletionItem {
 			Kind:   lsp.CompletionSnippet,
 			Detail: "",
 			Documentation: &lsp.MarkupContent{
-				Kind:  lsp.MarkupKindMarkdown,
+				Kind:  lsp.MarkupKindPlainText,
 				Value: snippet.documentation,
 			},
 			InsertText:       &snippet.insertText,
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	"github.com/matkrin/bashd/internal/ast"
+)
+
+// Settings of the client under the section `bashd`. Settings that are not set
+// keep the value of the configuration they are applied to.
+type bashdSettings struct {
+	Severity   *string `json:"severity"`
+	Shell      *string `json:"shell"`
+	Shellcheck *struct {
+		Include     *[]string `json:"include"`
+		Exclude     *[]string `json:"exclude"`
+		Enable      *[]string `json:"enable"`
+		SourcePaths *[]string `json:"sourcePaths"`
+	} `json:"shellcheck"`
+	Format *struct {
+		BinaryNextLine *bool `json:"binary_next_line"` // Binary ops like && and | may start a line
+		CaseIndent     *bool `json:"case_indent"`      // Switch cases will be indented
+		SpaceRedirects *bool `json:"space_redirects"`  // Redirect operators will be followed by a space
+		FuncNextLine   *bool `json:"func_next_line"`   // Function opening braces are placed on a separate line
+	} `json:"format"`
+}
+
+func parseSettings(rawSettings any) (bashdSettings, error) {
+	var settings bashdSettings
+	data, err := json.Marshal(rawSettings)
+	if err != nil {
+		return settings, fmt.Errorf("ERROR: Could not marshal settings: %w", err)
+	}
+	if err := json.Unmarshal(data, &settings); err != nil {
+		return settings, fmt.Errorf("ERROR: Could not unmarshal bashd settings: %w", err)
+	}
+	return settings, nil
+}
+
+// Copy of the configuration with the settings applied
+func (c Config) withSettings(settings bashdSettings) Config {
+	if settings.Format != nil {
+		if settings.Format.BinaryNextLine != nil {
+			c.FormatOptions.BinaryNextLine = *settings.Format.BinaryNextLine
+		}
+		if settings.Format.CaseIndent != nil {
+			c.FormatOptions.CaseIndent = *settings.Format.CaseIndent
+		}
+		if settings.Format.SpaceRedirects != nil {
+			c.FormatOptions.SpaceRedirects = *settings.Format.SpaceRedirects
+		}
+		if settings.Format.FuncNextLine != nil {
+			c.FormatOptions.FuncNextLine = *settings.Format.FuncNextLine
+		}
+	}
+	if settings.Severity != nil {
+		c.ShellCheckOptions.Severity = *settings.Severity
+	}
+	// Unknown dialects are ignored, the empty one detects the dialect per script
+	if settings.Shell != nil {
+		if dialect := ast.Dialect(*settings.Shell); dialect == "" || slices.Contains(ast.Dialects, dialect) {
+			c.Dialect = dialect
+		}
+	}
+	if settings.Shellcheck != nil {
+		if settings.Shellcheck.Include != nil {
+			c.ShellCheckOptions.Include = *settings.Shellcheck.Include
+		}
+		if settings.Shellcheck.Exclude != nil {
+			c.ShellCheckOptions.Exclude = *settings.Shellcheck.Exclude
+		}
+		if settings.Shellcheck.Enable != nil {
+			c.ShellCheckOptions.Enable = *settings.Shellcheck.Enable
+		}
+		if settings.Shellcheck.SourcePaths != nil {
+			c.ShellCheckOptions.SourcePaths = *settings.Shellcheck.SourcePaths
+		}
+	}
+	return c
+}
@@ -0,0 +1,45 @@
+package server
+
+import (
+	"log/slog"
+
+	"github.com/matkrin/bashd/internal/ast"
+	"github.com/matkrin/bashd/internal/lsp"
+	"github.com/matkrin/bashd/internal/utils"
+)
+
+func handleDeclaration(request *lsp.DeclarationRequest, state *State) *lsp.DeclarationResponse {
+	uri := request.Params.TextDocument.URI
+	document := state.Documents[uri].Text
+	mapper := state.NewMapper(document)
+	cursor := ast.NewCursorAt(mapper, request.Params.Position)
+
+	fileAst, err := state.Documents[uri].TolerantAst()
+	if err != nil {
+		slog.Error(err.Error())
+		return nil
+	}
+
+	locations := definitionLocations(findDeclarations(fileAst, cursor, uri, state), uri, mapper, state)
+	if len(locations) == 0 {
+		return nil
+	}
+
+	response := lsp.NewDeclarationResponse(request.ID, locations)
+	return &response
+}
+
+// Declarations of the identifier under cursor in the document with uri: the
+// declaration in the document itself, else in the files it sources, else the
+// definitions in the files sourcing it
+func findDeclarations(fileAst *ast.Ast, cursor ast.Cursor, uri string, state *State) []definitionInFile {
+	if decl := fileAst.FindDeclInFile(cursor); decl != nil {
+		return []definitionInFile{{uri, *decl}}
+	}
+
+	if sourcedFile, decl := fileAst.FindDeclInSourcedFile(cursor, state.SourcedFiles(uri), state.parseFile); decl != nil {
+		return []definitionInFile{{utils.PathToURI(sourcedFile), *decl}}
+	}
+
+	return findDefinitions(fileAst, cursor, uri, state)
+}
@@ -2,8 +2,9 @@ package server
 
 import (
 	"log/slog"
+	"maps"
 	"os"
-	"path/filepath"
+	"slices"
 
 	"github.com/matkrin/bashd/internal/ast"
 	"github.com/matkrin/bashd/internal/lsp"
@@ -12,75 +13,99 @@ import (
 
 func handleDefinition(request *lsp.DefinitionRequest, state *State) *lsp.DefinitionResponse {
 	uri := request.Params.TextDocument.URI
-	cursor := ast.NewCursor(
-		request.Params.Position.Line,
-		request.Params.Position.Character,
-	)
-
 	document := state.Documents[uri].Text
-	fileAst, err := ast.ParseDocument(document, uri, false)
+	mapper := state.NewMapper(document)
+	cursor := ast.NewCursorAt(mapper, request.Params.Position)
+
+	fileAst, err := state.Documents[uri].TolerantAst()
 	if err != nil {
 		slog.Error(err.Error())
 		return nil
 	}
-	cursorNode := fileAst.FindNodeUnderCursor(cursor)
-	definition := fileAst.FindDefInFile(cursor)
 
-	if definition == nil {
-		// Check for the definition in a sourced file
+	locations := definitionLocations(findDefinitions(fileAst, cursor, uri, state), uri, mapper, state)
+
+	if len(locations) == 0 {
+		// Check if the cursor is over a filename in a source statement
 		filename, err := utils.UriToPath(uri)
 		if err != nil {
 			slog.Error(err.Error())
 			return nil
 		}
-		baseDir := filepath.Dir(filename)
-		sourcedFile := ""
-		sourcedFile, definition = fileAst.FindDefInSourcedFile(
+		sourcePath := fileAst.FindSourcedFile(
 			cursor,
 			state.EnvVars,
-			baseDir,
+			filename,
+			state.ConfigFor(uri).ShellCheckOptions.SourcePaths,
 		)
-
-		if definition != nil {
-			uri = utils.PathToURI(sourcedFile)
-		}
-	}
-
-	if definition == nil {
-		// Check if the cursor is over a filename in a source statement
-		filename, err := utils.UriToPath(uri)
-		if err != nil {
-			slog.Error(err.Error())
-			return nil
-		}
-		baseDir := filepath.Dir(filename)
-		sourcePath := fileAst.FindSourcedFile(cursor, state.EnvVars, baseDir)
 		// Check if file exists
 		if _, err := os.Stat(sourcePath); err != nil {
 			return nil
 		}
-		definition = &ast.DefNode{
-			Node:      cursorNode,
-			StartLine: 1,
-			StartChar: 1,
-			EndLine:   1,
-			EndChar:   1,
+		locations = append(locations, lsp.Location{
+			URI:   utils.PathToURI(sourcePath),
+			Range: lsp.NewRange(0, 0, 0, 0),
+		})
+	}
+
+	response := lsp.NewDefinitionResponse(request.ID, locations)
+	return &response
+}
+
+// Definition of an identifier in the document or file with uri
+type definitionInFile struct {
+	uri     string
+	defNode ast.DefNode
+}
+
+// Locations of definitions, mapper is the one of the document with uri
+func definitionLocations(
+	definitions []definitionInFile,
+	uri string,
+	mapper *lsp.Mapper,
+	state *State,
+) []lsp.Location {
+	locations := []lsp.Location{}
+	for _, definition := range definitions {
+		definitionMapper := mapper
+		if definition.uri != uri {
+			path, err := utils.UriToPath(definition.uri)
+			if err != nil {
+				continue
+			}
+			definitionMapper = state.FileMapper(path)
 		}
-		uri = utils.PathToURI(sourcePath)
+		locations = append(locations, definition.defNode.ToLspLocation(definition.uri, definitionMapper))
 	}
+	return locations
+}
 
-	if definition == nil {
-		return nil
+// Definitions of the identifier under cursor in the document with uri: the
+// definitions reaching it in the document itself, else in the files it
+// sources, else all candidates in the files sourcing it and the other files
+// they source
+func findDefinitions(fileAst *ast.Ast, cursor ast.Cursor, uri string, state *State) []definitionInFile {
+	var definitions []definitionInFile
+	for _, defNode := range fileAst.FindDefInFile(cursor) {
+		definitions = append(definitions, definitionInFile{uri, defNode})
+	}
+	if len(definitions) > 0 {
+		return definitions
 	}
 
-	response := lsp.NewDefinitionResponse(
-		request.ID,
-		uri,
-		definition.StartLine-1,
-		definition.StartChar-1,
-		definition.EndLine-1,
-		definition.EndChar-1,
-	)
-	return &response
-}
+	sourcedFile, defNodes := fileAst.FindDefInSourcedFile(cursor, state.SourcedFiles(uri), state.parseFile)
+	for _, defNode := range defNodes {
+		definitions = append(definitions, definitionInFile{utils.PathToURI(sourcedFile), defNode})
+	}
+	if len(definitions) > 0 {
+		return definitions
+	}
 
+	definitionsByFile := fileAst.FindDefsInSourcingFiles(cursor, state.ContextFiles(uri), state.parseFile)
+	for _, file := range slices.Sorted(maps.Keys(definitionsByFile)) {
+		for _, defNode := range definitionsByFile[file] {
+			definitions = append(definitions, definitionInFile{utils.PathToURI(file), defNode})
+		}
+	}
+	return definitions
+}
@@ -1,15 +1,19 @@
 package server
 
 import (
+	"os"
+	"path/filepath"
+	"slices"
 	"testing"
 
 	"github.com/matkrin/bashd/internal/ast"
 	"github.com/matkrin/bashd/internal/lsp"
+	"github.com/matkrin/bashd/internal/utils"
 )
 
 func mockState(documentText string) *State {
 	state := NewState(Config{ExcludeDirs: nil})
-	state.SetDocument("file://workspace/test.sh", documentText)
+	state.SetDocument("file://workspace/test.sh", documentText, 0)
 	state.WorkspaceFolders = []lsp.WorkspaceFolder{
 		{URI: "file://workspace", Name: "workspace"},
 	}
@@ -21,7 +25,7 @@ func mockRequest(position lsp.Position) *lsp.DefinitionRequest {
 	return &lsp.DefinitionRequest{
 		Request: lsp.Request{
 			RPC:    lsp.RPC_VERSION,
-			ID:     0,
+			ID:     lsp.NewNumberID(0),
 			Method: "textdocument/definition",
 		},
 		Params: lsp.DefinitionParams{
@@ -35,14 +39,14 @@ func mockRequest(position lsp.Position) *lsp.DefinitionRequest {
 }
 
 func mockResponse(_range lsp.Range) *lsp.DefinitionResponse {
-	id := 0
+	id := lsp.NewNumberID(0)
 	return &lsp.DefinitionResponse{
 		Response: lsp.Response{
 			RPC: lsp.RPC_VERSION,
 			ID:  &id,
 		},
 		Result: lsp.DefinitionResult{
-			Location: lsp.Location{
+			{
 				URI:   "file://workspace/test.sh",
 				Range: _range,
 			},
@@ -84,8 +88,35 @@ foo
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			got := handleDefinition(tt.request, state)
-			if got.Result.Location != tt.want.Result.Location {
-				t.Errorf("handleDefinition() = %v, want %v", got.Result.Location, tt.want.Result.Location)
+			if !slices.Equal(got.Result, tt.want.Result) {
+				t.Errorf("handleDefinition() = %v, want %v", got.Result, tt.want.Result)
+			}
+		})
+	}
+}
+
+func Test_handleDefinitionPositionEncoding(t *testing.T) {
+	state := mockState("echo \"äö 😀\"; b=1\necho \"😀 $b\"\n")
+
+	tests := []struct {
+		encoding lsp.PositionEncodingKind
+		position lsp.Position
+		want     lsp.Range
+	}{
+		{lsp.PositionEncodingUTF8, lsp.Position{Line: 1, Character: 12}, lsp.NewRange(0, 18, 0, 19)},
+		{lsp.PositionEncodingUTF16, lsp.Position{Line: 1, Character: 10}, lsp.NewRange(0, 14, 0, 15)},
+		{lsp.PositionEncodingUTF32, lsp.Position{Line: 1, Character: 9}, lsp.NewRange(0, 13, 0, 14)},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.encoding), func(t *testing.T) {
+			state.PositionEncoding = tt.encoding
+			got := handleDefinition(mockRequest(tt.position), state)
+			if got == nil || len(got.Result) != 1 {
+				t.Fatalf("handleDefinition() = %v, want %v", got, tt.want)
+			}
+			if got.Result[0].Range != tt.want {
+				t.Errorf("handleDefinition() = %v, want %v", got.Result[0].Range, tt.want)
 			}
 		})
 	}
@@ -150,7 +181,11 @@ foo
 	fileAst, _ := ast.ParseDocument(input, "test.sh", false)
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := fileAst.FindDefInFile(tt.cursor)
+			defNodes := fileAst.FindDefInFile(tt.cursor)
+			if len(defNodes) != 1 {
+				t.Fatalf("FindDefInFile() = %v, want 1 definition", defNodes)
+			}
+			got := &defNodes[0]
 			if (*got).Name != tt.want.Name {
 				t.Errorf("Name = %v, want %v", (*got).Name, tt.want.Name)
 			}
@@ -170,3 +205,56 @@ foo
 	}
 
 }
+
+func Test_handleDefinitionInSourcingFiles(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"main.sh":    "CONFIG=main\nsource ./lib.sh\n",
+		"other.sh":   "source ./helpers.sh\nCONFIG=other\nsource ./lib.sh\n",
+		"helpers.sh": "helper() {\n\techo\n}\n",
+		"lib.sh":     "echo \"$CONFIG\"\nhelper\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	state := NewState(Config{})
+	state.WorkspaceFolders = []lsp.WorkspaceFolder{{URI: utils.PathToURI(dir), Name: "workspace"}}
+	libURI := utils.PathToURI(filepath.Join(dir, "lib.sh"))
+	state.SetDocument(libURI, files["lib.sh"], 0)
+
+	tests := []struct {
+		name     string
+		position lsp.Position
+		want     lsp.DefinitionResult
+	}{
+		{
+			"Variable defined by all sourcing files",
+			lsp.Position{Line: 0, Character: 8},
+			lsp.DefinitionResult{
+				{URI: utils.PathToURI(filepath.Join(dir, "main.sh")), Range: lsp.NewRange(0, 0, 0, 6)},
+				{URI: utils.PathToURI(filepath.Join(dir, "other.sh")), Range: lsp.NewRange(1, 0, 1, 6)},
+			},
+		},
+		{
+			"Function in file sourced by sourcing file",
+			lsp.Position{Line: 1, Character: 2},
+			lsp.DefinitionResult{
+				{URI: utils.PathToURI(filepath.Join(dir, "helpers.sh")), Range: lsp.NewRange(0, 0, 0, 6)},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := mockRequest(tt.position)
+			request.Params.TextDocument.URI = libURI
+			got := handleDefinition(request, &state)
+			if got == nil || !slices.Equal(got.Result, tt.want) {
+				t.Errorf("handleDefinition() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,102 +1,175 @@
 package server
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"os"
+	"sync"
 
 	"github.com/matkrin/bashd/internal/ast"
 	"github.com/matkrin/bashd/internal/lsp"
 	"github.com/matkrin/bashd/internal/shellcheck"
 	"github.com/matkrin/bashd/internal/utils"
-	"mvdan.cc/sh/v3/syntax"
 )
 
 func findDiagnostics(
-	documentText string,
+	ctx context.Context,
+	document Document,
 	uri string,
+	positionEncoding lsp.PositionEncodingKind,
 	envVars map[string]string,
 	shellcheckOptions shellcheck.Options,
 ) []lsp.Diagnostic {
 	diagnostics := make([]lsp.Diagnostic, 0)
-
-	shellcheck, err := shellcheck.Run(documentText, shellcheckOptions)
-	if err != nil {
+	mapper := lsp.NewMapper(document.Text, positionEncoding)
+
+	path, _ := utils.UriToPath(uri)
+	scriptOptions := shellcheckOptions.ForScript(path, document.Dialect().ShellCheckShell())
+	shellcheck, err := shellcheck.Run(ctx, document.Text, scriptOptions)
+	if errors.Is(err, context.Canceled) {
+		// Superseded by a newer run of the scheduler
+		slog.Debug("Shellcheck cancelled", "uri", uri)
+	} else if err != nil {
 		slog.Error("ERROR running shellcheck", "err", err)
 	} else {
-		diagnostics = append(diagnostics, shellcheck.ToDiagnostics()...)
+		diagnostics = append(diagnostics, shellcheck.ToDiagnostics(mapper)...)
 	}
 
-	fileAst, err := ast.ParseDocument(documentText, uri, false)
+	fileAst, err := document.Ast()
 	if err != nil {
-		diagnostics = append(diagnostics, diagnosticParseError(err))
+		for _, syntaxError := range ast.FindSyntaxErrors(document.Text, uri, document.Dialect()) {
+			diagnostics = append(diagnostics, diagnosticSyntaxError(syntaxError, uri, mapper))
+		}
 		return diagnostics
 	}
 
-	for _, sourceStatement := range fileAst.FindSourceStatments(envVars) {
-		if _, err := os.Stat(sourceStatement.SourcedFile); err != nil {
-			diagnostics = append(diagnostics, fileNotExistentError(sourceStatement))
+	// Sourced files are resolved like for navigation and by shellcheck
+	sourceStatements := fileAst.FindSourceStatments(envVars, path, shellcheckOptions.SourcePaths)
+	for _, sourceStatement := range sourceStatements {
+		if _, err := os.Stat(sourceStatement.ResolvedFile); err != nil {
+			diagnostics = append(diagnostics, fileNotExistentError(sourceStatement, mapper))
 		}
 	}
 
 	return diagnostics
 }
 
-func findDiagnosticsWorkspace(state *State) map[string][]lsp.Diagnostic {
-	workspaceDiagnostics := make(map[string][]lsp.Diagnostic)
-
-	for _, shFile := range state.WorkspaceShFiles() {
-		fileContent, err := os.ReadFile(shFile)
-		if err != nil {
-			slog.Error("ERROR could not read file content", "file", shFile)
-		}
+// Find diagnostics of shFiles with a bounded number of workers. onResult is
+// called for each file, one call at a time, until ctx is done.
+func findDiagnosticsWorkspace(
+	ctx context.Context,
+	state *State,
+	shFiles []string,
+	workers int,
+	onResult func(uri string, diagnostics []lsp.Diagnostic),
+) {
+	type result struct {
+		uri         string
+		diagnostics []lsp.Diagnostic
+	}
 
-		uri := utils.PathToURI(shFile)
-		diagnostics := findDiagnostics(
-			string(fileContent),
-			uri,
-			state.EnvVars,
-			state.Config.ShellCheckOptions,
-		)
-		workspaceDiagnostics[uri] = diagnostics
+	files := make(chan string)
+	results := make(chan result)
+	var wg sync.WaitGroup
+	for range max(workers, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for shFile := range files {
+				fileContent, err := state.Files.text(shFile)
+				if err != nil {
+					slog.Error("ERROR could not read file content", "file", shFile)
+					continue
+				}
+
+				uri := utils.PathToURI(shFile)
+				diagnostics := findDiagnostics(
+					ctx,
+					NewDocument(uri, fileContent, 0, state.ConfigFor(uri).Dialect),
+					uri,
+					state.PositionEncoding,
+					state.EnvVars,
+					state.ConfigFor(uri).ShellCheckOptions,
+				)
+				results <- result{uri: uri, diagnostics: diagnostics}
+			}
+		}()
 	}
 
-	return workspaceDiagnostics
+	go func() {
+		defer close(files)
+		for _, shFile := range shFiles {
+			select {
+			case files <- shFile:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	for result := range results {
+		// Results of cancelled runs are incomplete
+		if ctx.Err() == nil {
+			onResult(result.uri, result.diagnostics)
+		}
+	}
 }
 
-func diagnosticParseError(err error) lsp.Diagnostic {
-	line := uint(0)
-	col := uint(0)
-	message := ""
-
-	switch e := err.(type) {
-	case syntax.ParseError:
-		line = e.Pos.Line() - 1
-		col = e.Pos.Col() - 1
-		message = e.Text
-	case syntax.LangError:
-		line = e.Pos.Line() - 1
-		col = e.Pos.Col() - 1
-		message = e.Feature
-	case syntax.QuoteError:
-		message = e.Message
-	default:
-		slog.Info("Unknown parser error", "err", err)
+// Find open documents, other than the document itself, that source the
+// document with `uri` directly or indirectly
+func findDependentDocuments(state *State, uri string) map[string]Document {
+	dependents := make(map[string]Document)
 
+	for _, dependent := range state.DependentFiles(uri) {
+		documentUri := utils.PathToURI(dependent)
+		if document, ok := state.Documents[documentUri]; ok && documentUri != uri {
+			dependents[documentUri] = document
+		}
 	}
 
-	return lsp.Diagnostic{
-		Range:    lsp.NewRange(line, col, line, col),
+	return dependents
+}
+
+func diagnosticSyntaxError(syntaxError ast.SyntaxError, uri string, mapper *lsp.Mapper) lsp.Diagnostic {
+	code := string(syntaxError.Code)
+	diagnostic := lsp.Diagnostic{
+		Range: mapper.ByteRange(
+			syntaxError.Start.Line-1,
+			syntaxError.Start.Col-1,
+			syntaxError.End.Line-1,
+			syntaxError.End.Col-1,
+		),
 		Severity: lsp.DiagnosticError,
-		Code:     nil,
+		Code:     &code,
 		Source:   "bashd",
-		Message:  message,
+		Message:  syntaxError.Message,
+	}
+	if opener := syntaxError.Opener; opener != nil {
+		diagnostic.RelatedInformation = []lsp.DiagnosticRelatedInformation{{
+			Location: lsp.Location{
+				URI: uri,
+				Range: mapper.ByteRange(
+					opener.Start.Line-1,
+					opener.Start.Col-1,
+					opener.End.Line-1,
+					opener.End.Col-1,
+				),
+			},
+			Message: fmt.Sprintf("`%s` opened here", opener.Text),
+		}}
 	}
+	return diagnostic
 }
 
-func fileNotExistentError(file ast.SourceStatement) lsp.Diagnostic {
+func fileNotExistentError(file ast.SourceStatement, mapper *lsp.Mapper) lsp.Diagnostic {
 	return lsp.Diagnostic{
-		Range: lsp.NewRange(file.StartLine,
+		Range: mapper.ByteRange(file.StartLine,
 			file.StartChar,
 			file.EndLine,
 			file.EndChar,
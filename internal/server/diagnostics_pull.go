@@ -0,0 +1,220 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+
+	"github.com/matkrin/bashd/internal/lsp"
+	"github.com/matkrin/bashd/internal/utils"
+)
+
+type diagnosticResult struct {
+	resultID    string
+	diagnostics []lsp.Diagnostic
+}
+
+// Latest diagnostics per document for pull diagnostics. Results are valid as
+// long as their result ID matches the result ID of the current inputs.
+type diagnosticCache struct {
+	mu      sync.Mutex
+	results map[string]diagnosticResult
+}
+
+func newDiagnosticCache() *diagnosticCache {
+	return &diagnosticCache{results: make(map[string]diagnosticResult)}
+}
+
+func (c *diagnosticCache) get(uri, resultID string) ([]lsp.Diagnostic, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	result, ok := c.results[uri]
+	if !ok || result.resultID != resultID {
+		return nil, false
+	}
+	return result.diagnostics, true
+}
+
+func (c *diagnosticCache) set(uri, resultID string, diagnostics []lsp.Diagnostic) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.results[uri] = diagnosticResult{resultID: resultID, diagnostics: diagnostics}
+}
+
+func (c *diagnosticCache) remove(uri string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.results, uri)
+}
+
+// Handler for `textDocument/diagnostic`
+func handleDocumentDiagnostic(
+	ctx context.Context,
+	request *lsp.DocumentDiagnosticRequest,
+	state *State,
+	cache *diagnosticCache,
+) *lsp.DocumentDiagnosticResponse {
+	uri := request.Params.TextDocument.URI
+
+	document, ok := documentOrFile(uri, state)
+	if !ok {
+		response := lsp.NewDocumentDiagnosticResponse(
+			request.ID,
+			lsp.NewFullDocumentDiagnosticReport("", nil),
+		)
+		return &response
+	}
+
+	report := documentDiagnosticReport(
+		ctx,
+		uri,
+		document,
+		request.Params.PreviousResultID,
+		state,
+		cache,
+	)
+	if ctx.Err() != nil {
+		return nil
+	}
+
+	response := lsp.NewDocumentDiagnosticResponse(request.ID, report)
+	return &response
+}
+
+// Handler for `workspace/diagnostic`. Open documents are left out, their
+// diagnostics are pulled with `textDocument/diagnostic`.
+func handleWorkspaceDiagnostic(
+	ctx context.Context,
+	request *lsp.WorkspaceDiagnosticRequest,
+	state *State,
+	cache *diagnosticCache,
+) *lsp.WorkspaceDiagnosticResponse {
+	previousResultIDs := make(map[string]string)
+	for _, previous := range request.Params.PreviousResultIDs {
+		previousResultIDs[previous.URI] = previous.Value
+	}
+
+	var items []any
+	for _, shFile := range state.WorkspaceShFiles() {
+		uri := utils.PathToURI(shFile)
+		if _, ok := state.Documents[uri]; ok {
+			continue
+		}
+
+		fileContent, err := state.Files.text(shFile)
+		if err != nil {
+			slog.Error("ERROR could not read file content", "file", shFile)
+			continue
+		}
+
+		var previousResultID *string
+		if value, ok := previousResultIDs[uri]; ok {
+			previousResultID = &value
+		}
+
+		report := documentDiagnosticReport(
+			ctx,
+			uri,
+			NewDocument(uri, fileContent, 0, state.ConfigFor(uri).Dialect),
+			previousResultID,
+			state,
+			cache,
+		)
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		switch r := report.(type) {
+		case lsp.FullDocumentDiagnosticReport:
+			items = append(items, lsp.WorkspaceFullDocumentDiagnosticReport{
+				FullDocumentDiagnosticReport: r,
+				URI:                          uri,
+				Version:                      nil,
+			})
+		case lsp.UnchangedDocumentDiagnosticReport:
+			items = append(items, lsp.WorkspaceUnchangedDocumentDiagnosticReport{
+				UnchangedDocumentDiagnosticReport: r,
+				URI:                               uri,
+				Version:                           nil,
+			})
+		}
+	}
+
+	response := lsp.NewWorkspaceDiagnosticResponse(request.ID, items)
+	return &response
+}
+
+// Unchanged report if the result ID of the inputs matches the previous result
+// ID, otherwise a full report with cached or newly found diagnostics
+func documentDiagnosticReport(
+	ctx context.Context,
+	uri string,
+	document Document,
+	previousResultID *string,
+	state *State,
+	cache *diagnosticCache,
+) any {
+	resultID := diagnosticResultID(uri, document, state)
+	if previousResultID != nil && *previousResultID == resultID {
+		return lsp.NewUnchangedDocumentDiagnosticReport(resultID)
+	}
+
+	diagnostics, ok := cache.get(uri, resultID)
+	if !ok {
+		diagnostics = findDiagnostics(
+			ctx,
+			document,
+			uri,
+			state.PositionEncoding,
+			state.EnvVars,
+			state.ConfigFor(uri).ShellCheckOptions,
+		)
+		// Results of cancelled runs are incomplete
+		if ctx.Err() != nil {
+			return nil
+		}
+		cache.set(uri, resultID, diagnostics)
+	}
+
+	return lsp.NewFullDocumentDiagnosticReport(resultID, diagnostics)
+}
+
+// Identifies the inputs of the diagnostics of a document: its text, the
+// settings and the files it sources, which shellcheck follows as well
+func diagnosticResultID(uri string, document Document, state *State) string {
+	hash := sha256.New()
+	io.WriteString(hash, document.Text)
+	fmt.Fprintf(hash, "\x00%s\x00%s\x00%v", state.PositionEncoding, document.Dialect(), state.ConfigFor(uri).ShellCheckOptions)
+
+	for _, sourcedFile := range state.SourcedFiles(uri) {
+		fmt.Fprintf(hash, "\x00%s", sourcedFile)
+		if info, err := os.Stat(sourcedFile); err == nil {
+			fmt.Fprintf(hash, "\x00%d\x00%d", info.Size(), info.ModTime().UnixNano())
+		}
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)[:16])
+}
+
+// Open document with uri or else the file on disk
+func documentOrFile(uri string, state *State) (Document, bool) {
+	if document, ok := state.Documents[uri]; ok {
+		return document, true
+	}
+
+	path, err := utils.UriToPath(uri)
+	if err != nil {
+		return Document{}, false
+	}
+	fileContent, err := state.Files.text(path)
+	if err != nil {
+		slog.Error("ERROR could not read file content", "file", path)
+		return Document{}, false
+	}
+	return NewDocument(uri, fileContent, 0, state.ConfigFor(uri).Dialect), true
+}
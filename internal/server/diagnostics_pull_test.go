@@ -0,0 +1,140 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/matkrin/bashd/internal/lsp"
+)
+
+func Test_handleDocumentDiagnostic(t *testing.T) {
+	state := mockState("echo \"$(\n")
+	cache := newDiagnosticCache()
+	request := func(previousResultID *string) *lsp.DocumentDiagnosticRequest {
+		return &lsp.DocumentDiagnosticRequest{
+			Params: lsp.DocumentDiagnosticParams{
+				TextDocument:     lsp.TextDocumentIdentifier{URI: "file://workspace/test.sh"},
+				PreviousResultID: previousResultID,
+			},
+		}
+	}
+
+	response := handleDocumentDiagnostic(context.Background(), request(nil), state, cache)
+	full, ok := response.Result.(lsp.FullDocumentDiagnosticReport)
+	if !ok {
+		t.Fatalf("expected full report, got %T", response.Result)
+	}
+	if full.ResultID == "" || len(full.Items) == 0 {
+		t.Errorf("expected result ID and parse error, got %v", full)
+	}
+
+	response = handleDocumentDiagnostic(context.Background(), request(&full.ResultID), state, cache)
+	unchanged, ok := response.Result.(lsp.UnchangedDocumentDiagnosticReport)
+	if !ok || unchanged.ResultID != full.ResultID {
+		t.Errorf("expected unchanged report with result ID %s, got %v", full.ResultID, response.Result)
+	}
+
+	state.SetDocument("file://workspace/test.sh", "echo\n", 1)
+	response = handleDocumentDiagnostic(context.Background(), request(&full.ResultID), state, cache)
+	changed, ok := response.Result.(lsp.FullDocumentDiagnosticReport)
+	if !ok || changed.ResultID == full.ResultID {
+		t.Errorf("expected full report with new result ID, got %v", response.Result)
+	}
+}
+
+func Test_pullDiagnosticsClient(t *testing.T) {
+	var buf bytes.Buffer
+	state := mockState1("echo\n")
+	server := NewServer("", "", *state, &buf)
+
+	server.HandleMessage(
+		"initialize",
+		[]byte(`{"id": 1, "method": "initialize", "params": {"capabilities": {"textDocument": {"diagnostic": {}}, "workspace": {"diagnostics": {"refreshSupport": true}}}}}`),
+	)
+	server.HandleMessage(
+		"textDocument/didOpen",
+		[]byte(`{"method": "textDocument/didOpen", "params": {"textDocument": {"uri": "file://workspace/other.sh", "version": 1, "text": "echo \"$(\n"}}}`),
+	)
+	server.HandleMessage(
+		"workspace/didChangeConfiguration",
+		[]byte(`{"method": "workspace/didChangeConfiguration", "params": {"settings": {"bashd": {}}}}`),
+	)
+	server.Stop()
+
+	response := buf.String()
+	if strings.Contains(response, "textDocument/publishDiagnostics") {
+		t.Errorf("expected no pushed diagnostics in '%s'", response)
+	}
+	if !strings.Contains(response, `"method":"workspace/diagnostic/refresh"`) {
+		t.Errorf("expected refresh request in '%s'", response)
+	}
+}
+
+func Test_pullDiagnosticsRegistration(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		wantPull bool
+	}{
+		{"registered", `{"jsonrpc": "2.0", "id": 1, "result": null}`, true},
+		{"rejected", `{"jsonrpc": "2.0", "id": 1, "error": {"code": -32603, "message": "failed"}}`, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			state := mockState1("echo\n")
+			state.WorkspaceFolders = nil
+			server := NewServer("", "", *state, &buf)
+
+			server.HandleMessage(
+				"initialize",
+				[]byte(`{"id": 1, "method": "initialize", "params": {"capabilities": {"textDocument": {"diagnostic": {"dynamicRegistration": true}}}}}`),
+			)
+			server.HandleMessage("initialized", []byte(`{"method": "initialized", "params": {}}`))
+			server.HandleMessage(
+				"textDocument/didOpen",
+				[]byte(`{"method": "textDocument/didOpen", "params": {"textDocument": {"uri": "file://workspace/other.sh", "version": 1, "text": "echo \"$(\n"}}}`),
+			)
+			server.HandleMessage("", []byte(tt.response))
+			server.Stop()
+
+			response := buf.String()
+			if strings.Contains(response, "diagnosticProvider") {
+				t.Errorf("expected no static diagnostic provider in '%s'", response)
+			}
+			if !strings.Contains(response, `"method":"client/registerCapability"`) ||
+				!strings.Contains(response, `"method":"textDocument/diagnostic"`) {
+				t.Errorf("expected registration of pull diagnostics in '%s'", response)
+			}
+			if tt.wantPull && strings.Contains(response, "textDocument/publishDiagnostics") {
+				t.Errorf("expected no pushed diagnostics in '%s'", response)
+			}
+			if server.registeringDiagnostics || server.pullDiagnostics != tt.wantPull {
+				t.Errorf("expected pull diagnostics %v, got %v", tt.wantPull, server.pullDiagnostics)
+			}
+		})
+	}
+}
+
+func Test_onResponse(t *testing.T) {
+	var buf bytes.Buffer
+	state := mockState1("echo\n")
+	server := NewServer("", "", *state, &buf)
+
+	var got *lsp.ClientResponse
+	server.sendRequest("workspace/configuration", nil, func(response lsp.ClientResponse) {
+		got = &response
+	})
+	server.HandleMessage("", []byte(`{"jsonrpc": "2.0", "id": 1, "result": [{}]}`))
+	server.Stop()
+
+	if !strings.Contains(buf.String(), `"id":1,"method":"workspace/configuration"`) {
+		t.Errorf("expected request in '%s'", buf.String())
+	}
+	if got == nil || string(got.Result) != "[{}]" {
+		t.Errorf("expected response with result [{}], got %v", got)
+	}
+}
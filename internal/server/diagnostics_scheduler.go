@@ -0,0 +1,91 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/matkrin/bashd/internal/lsp"
+)
+
+type findDiagnosticsFunc func(ctx context.Context) []lsp.Diagnostic
+
+type publishDiagnosticsFunc func(uri string, version int, diagnostics []lsp.Diagnostic)
+
+// Debounces diagnostics per document. Scheduling diagnostics for a document
+// supersedes the pending or running diagnostics of that document, so that
+// only results for the latest scheduled version get published.
+type diagnosticScheduler struct {
+	mu      sync.Mutex
+	runs    map[string]*diagnosticRun
+	publish publishDiagnosticsFunc
+}
+
+type diagnosticRun struct {
+	version int
+	timer   *time.Timer
+	cancel  context.CancelFunc
+}
+
+func newDiagnosticScheduler(publish publishDiagnosticsFunc) *diagnosticScheduler {
+	return &diagnosticScheduler{
+		runs:    make(map[string]*diagnosticRun),
+		publish: publish,
+	}
+}
+
+// Run find after delay and publish its result, unless diagnostics for the
+// document get scheduled again or cancelled in the meantime.
+func (d *diagnosticScheduler) schedule(
+	uri string,
+	version int,
+	delay time.Duration,
+	find findDiagnosticsFunc,
+) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.cancelLocked(uri)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	run := &diagnosticRun{version: version, cancel: cancel}
+	run.timer = time.AfterFunc(delay, func() {
+		diagnostics := find(ctx)
+
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		if ctx.Err() != nil || d.runs[uri] != run {
+			return
+		}
+		delete(d.runs, uri)
+		cancel()
+		d.publish(uri, run.version, diagnostics)
+	})
+	d.runs[uri] = run
+}
+
+// Cancel pending or running diagnostics of a document
+func (d *diagnosticScheduler) cancel(uri string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.cancelLocked(uri)
+}
+
+// Cancel all pending or running diagnostics
+func (d *diagnosticScheduler) stop() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for uri := range d.runs {
+		d.cancelLocked(uri)
+	}
+}
+
+func (d *diagnosticScheduler) cancelLocked(uri string) {
+	run, ok := d.runs[uri]
+	if !ok {
+		return
+	}
+	run.timer.Stop()
+	run.cancel()
+	delete(d.runs, uri)
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/matkrin/bashd/internal/lsp"
+)
+
+type publishedDiagnostics struct {
+	mu        sync.Mutex
+	published map[string][]int
+}
+
+func (p *publishedDiagnostics) publish(uri string, version int, _ []lsp.Diagnostic) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.published[uri] = append(p.published[uri], version)
+}
+
+func (p *publishedDiagnostics) versions(uri string) []int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.published[uri]
+}
+
+func noDiagnostics(context.Context) []lsp.Diagnostic {
+	return []lsp.Diagnostic{}
+}
+
+func Test_diagnosticScheduler(t *testing.T) {
+	published := &publishedDiagnostics{published: map[string][]int{}}
+	scheduler := newDiagnosticScheduler(published.publish)
+	delay := 20 * time.Millisecond
+
+	// Debounced per document, scheduling for B does not cancel A
+	scheduler.schedule("file:///a.sh", 1, delay, noDiagnostics)
+	scheduler.schedule("file:///a.sh", 2, delay, noDiagnostics)
+	scheduler.schedule("file:///b.sh", 1, delay, noDiagnostics)
+
+	// Cancelled documents are never published
+	scheduler.schedule("file:///c.sh", 1, delay, noDiagnostics)
+	scheduler.cancel("file:///c.sh")
+
+	time.Sleep(10 * delay)
+
+	if got := published.versions("file:///a.sh"); len(got) != 1 || got[0] != 2 {
+		t.Errorf("expected only version 2 of a.sh to be published, got %v", got)
+	}
+	if got := published.versions("file:///b.sh"); len(got) != 1 || got[0] != 1 {
+		t.Errorf("expected version 1 of b.sh to be published, got %v", got)
+	}
+	if got := published.versions("file:///c.sh"); len(got) != 0 {
+		t.Errorf("expected no diagnostics for cancelled c.sh, got %v", got)
+	}
+}
+
+func Test_diagnosticSchedulerSupersedesRunning(t *testing.T) {
+	published := &publishedDiagnostics{published: map[string][]int{}}
+	scheduler := newDiagnosticScheduler(published.publish)
+
+	started := make(chan struct{})
+	cancelled := make(chan struct{})
+	scheduler.schedule("file:///a.sh", 1, 0, func(ctx context.Context) []lsp.Diagnostic {
+		close(started)
+		<-ctx.Done()
+		close(cancelled)
+		return []lsp.Diagnostic{}
+	})
+
+	<-started
+	scheduler.schedule("file:///a.sh", 2, 0, noDiagnostics)
+
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("expected running diagnostics of version 1 to be cancelled")
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	if got := published.versions("file:///a.sh"); len(got) != 1 || got[0] != 2 {
+		t.Errorf("expected only version 2 to be published, got %v", got)
+	}
+}
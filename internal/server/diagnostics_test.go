@@ -0,0 +1,83 @@
+package server
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/matkrin/bashd/internal/lsp"
+	"github.com/matkrin/bashd/internal/shellcheck"
+	"github.com/matkrin/bashd/internal/utils"
+)
+
+func Test_findDiagnosticsSourcedFile(t *testing.T) {
+	dir := t.TempDir()
+	uri := utils.PathToURI(filepath.Join(dir, "main.sh"))
+	find := func() []lsp.Diagnostic {
+		return findDiagnostics(
+			context.Background(),
+			NewDocument(uri, "source ./lib.sh\n", 0, ""),
+			uri,
+			lsp.PositionEncodingUTF16,
+			map[string]string{},
+			shellcheck.Options{},
+		)
+	}
+
+	notExistent := func(diagnostics []lsp.Diagnostic) bool {
+		for _, diagnostic := range diagnostics {
+			if strings.Contains(diagnostic.Message, "does not exist") {
+				return true
+			}
+		}
+		return false
+	}
+
+	if !notExistent(find()) {
+		t.Errorf("expected diagnostic for not existent file")
+	}
+
+	// Relative to the document, not to the working directory of the server
+	if err := os.WriteFile(filepath.Join(dir, "lib.sh"), []byte("echo\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if notExistent(find()) {
+		t.Errorf("expected no diagnostic for existing file")
+	}
+}
+
+func Test_findDiagnosticsSyntaxErrors(t *testing.T) {
+	uri := "file:///workspace/test.sh"
+	diagnostics := findDiagnostics(
+		context.Background(),
+		NewDocument(uri, "if true; then\n  echo \"a\n", 0, ""),
+		uri,
+		lsp.PositionEncodingUTF16,
+		map[string]string{},
+		shellcheck.Options{},
+	)
+
+	var syntaxErrors []lsp.Diagnostic
+	for _, diagnostic := range diagnostics {
+		if diagnostic.Source == "bashd" {
+			syntaxErrors = append(syntaxErrors, diagnostic)
+		}
+	}
+	if len(syntaxErrors) != 2 {
+		t.Fatalf("expected 2 syntax errors, got %v", syntaxErrors)
+	}
+
+	unclosedIf := syntaxErrors[0]
+	if unclosedIf.Code == nil || *unclosedIf.Code != "unclosed" {
+		t.Errorf("expected code unclosed, got %v", unclosedIf.Code)
+	}
+	if unclosedIf.Range != lsp.NewRange(0, 0, 2, 0) {
+		t.Errorf("expected the range from `if` to the end, got %v", unclosedIf.Range)
+	}
+	if len(unclosedIf.RelatedInformation) != 1 ||
+		unclosedIf.RelatedInformation[0].Location.Range != lsp.NewRange(0, 0, 0, 2) {
+		t.Errorf("expected related information on `if`, got %v", unclosedIf.RelatedInformation)
+	}
+}
@@ -0,0 +1,184 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync"
+
+	"github.com/matkrin/bashd/internal/lsp"
+)
+
+// Diagnostics of all workspace files that are not open, found in the
+// background. Starting a new run cancels the previous one.
+type workspaceDiagnostics struct {
+	mu     sync.Mutex
+	runs   int
+	cancel context.CancelFunc
+	token  string
+	// Documents open during the run, their diagnostics are based on their
+	// current text instead
+	open map[string]bool
+	wg   sync.WaitGroup
+}
+
+func (w *workspaceDiagnostics) start(open map[string]bool) (context.Context, int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.cancel != nil {
+		w.cancel()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	w.runs++
+	w.cancel = cancel
+	w.token = ""
+	w.open = open
+	return ctx, w.runs
+}
+
+func (w *workspaceDiagnostics) setToken(token string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.token = token
+}
+
+func (w *workspaceDiagnostics) markOpen(uri string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.open != nil {
+		w.open[uri] = true
+	}
+}
+
+func (w *workspaceDiagnostics) isOpen(uri string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.open[uri]
+}
+
+// Cancel the run reporting progress with token, e.g. on request of the user
+func (w *workspaceDiagnostics) cancelToken(token string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.cancel != nil && w.token == token {
+		w.cancel()
+	}
+}
+
+func (w *workspaceDiagnostics) stop() {
+	w.mu.Lock()
+	if w.cancel != nil {
+		w.cancel()
+	}
+	w.mu.Unlock()
+	w.wg.Wait()
+}
+
+// Lint the workspace in the background and push the diagnostics. Clients
+// supporting work done progress are asked for a progress token first.
+func (s *Server) startWorkspaceDiagnostics() {
+	state := s.state.Snapshot()
+	open := make(map[string]bool)
+	for uri := range state.Documents {
+		open[uri] = true
+	}
+	ctx, run := s.workspaceDiagnostics.start(open)
+
+	lint := func(progress *workDoneProgress) {
+		s.workspaceDiagnostics.wg.Add(1)
+		go func() {
+			defer s.workspaceDiagnostics.wg.Done()
+			s.runWorkspaceDiagnostics(ctx, &state, progress)
+		}()
+	}
+
+	if !state.ClientCapabilities.WorkDoneProgressSupport() {
+		lint(nil)
+		return
+	}
+
+	token := fmt.Sprintf("bashd/workspaceDiagnostics/%d", run)
+	params := lsp.WorkDoneProgressCreateParams{Token: token}
+	s.sendRequest("window/workDoneProgress/create", params, func(response lsp.ClientResponse) {
+		if ctx.Err() != nil {
+			return
+		}
+		if response.Error != nil {
+			lint(nil)
+			return
+		}
+		s.workspaceDiagnostics.setToken(token)
+		lint(&workDoneProgress{server: s, token: token})
+	})
+}
+
+func (s *Server) runWorkspaceDiagnostics(ctx context.Context, state *State, progress *workDoneProgress) {
+	shFiles := state.WorkspaceShFiles()
+	total := len(shFiles)
+	done := 0
+	progress.begin("Linting workspace", fmt.Sprintf("Linting 0/%d scripts", total))
+
+	findDiagnosticsWorkspace(
+		ctx,
+		state,
+		shFiles,
+		runtime.NumCPU(),
+		func(uri string, diagnostics []lsp.Diagnostic) {
+			done++
+			progress.report(fmt.Sprintf("Linting %d/%d scripts", done, total), done*100/total)
+			if !s.workspaceDiagnostics.isOpen(uri) {
+				s.pushDiagnostic(uri, nil, diagnostics)
+			}
+		},
+	)
+
+	if ctx.Err() != nil {
+		progress.end("Linting cancelled")
+		return
+	}
+	progress.end(fmt.Sprintf("Linted %d scripts", total))
+}
+
+// Reports `$/progress` for a token created by the client. A nil progress
+// reports nothing.
+type workDoneProgress struct {
+	server *Server
+	token  string
+}
+
+func (p *workDoneProgress) begin(title, message string) {
+	if p == nil {
+		return
+	}
+	percentage := uint(0)
+	p.server.writeResponse(lsp.NewProgressNotification(p.token, lsp.WorkDoneProgressBegin{
+		Kind:        "begin",
+		Title:       title,
+		Cancellable: true,
+		Message:     message,
+		Percentage:  &percentage,
+	}))
+}
+
+func (p *workDoneProgress) report(message string, percentage int) {
+	if p == nil {
+		return
+	}
+	reportedPercentage := uint(percentage)
+	p.server.writeResponse(lsp.NewProgressNotification(p.token, lsp.WorkDoneProgressReport{
+		Kind:        "report",
+		Cancellable: true,
+		Message:     message,
+		Percentage:  &reportedPercentage,
+	}))
+}
+
+func (p *workDoneProgress) end(message string) {
+	if p == nil {
+		return
+	}
+	p.server.writeResponse(lsp.NewProgressNotification(p.token, lsp.WorkDoneProgressEnd{
+		Kind:    "end",
+		Message: message,
+	}))
+}
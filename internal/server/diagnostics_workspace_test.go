@@ -0,0 +1,59 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/matkrin/bashd/internal/utils"
+)
+
+func Test_workspaceDiagnosticsProgress(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"broken.sh": "echo \"$(\n",
+		"open.sh":   "echo \"$(\n",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	brokenURI := utils.PathToURI(filepath.Join(dir, "broken.sh"))
+	openURI := utils.PathToURI(filepath.Join(dir, "open.sh"))
+
+	var buf bytes.Buffer
+	server := NewServer("", "", NewState(Config{}), &buf)
+	server.HandleMessage(
+		"initialize",
+		[]byte(fmt.Sprintf(`{"id": 1, "method": "initialize", "params": {"workspaceFolders": [{"uri": %q, "name": "workspace"}], "capabilities": {"window": {"workDoneProgress": true}}}}`, utils.PathToURI(dir))),
+	)
+	server.HandleMessage(
+		"textDocument/didOpen",
+		[]byte(fmt.Sprintf(`{"method": "textDocument/didOpen", "params": {"textDocument": {"uri": %q, "version": 1, "text": "echo\n"}}}`, openURI)),
+	)
+	server.HandleMessage("initialized", []byte(`{"method": "initialized", "params": {}}`))
+	server.HandleMessage("", []byte(`{"jsonrpc": "2.0", "id": 1, "result": null}`))
+	// The next message is received after the response was handled
+	server.HandleMessage("$/sync", []byte(`{"method": "$/sync"}`))
+	server.workspaceDiagnostics.wg.Wait()
+	server.Stop()
+
+	response := buf.String()
+	for _, want := range []string{
+		`"id":1,"method":"window/workDoneProgress/create","params":{"token":"bashd/workspaceDiagnostics/1"}`,
+		`"kind":"begin"`,
+		`"message":"Linting 2/2 scripts","percentage":100`,
+		`"kind":"end"`,
+		fmt.Sprintf(`"method":"textDocument/publishDiagnostics","params":{"uri":%q`, brokenURI),
+	} {
+		if !strings.Contains(response, want) {
+			t.Errorf("expected %s in '%s'", want, response)
+		}
+	}
+	if strings.Count(response, fmt.Sprintf(`"uri":%q`, openURI)) != 1 {
+		t.Errorf("expected only diagnostics of the open document for %s in '%s'", openURI, response)
+	}
+}
@@ -0,0 +1,42 @@
+package server
+
+import (
+	"log/slog"
+
+	"github.com/matkrin/bashd/internal/ast"
+	"github.com/matkrin/bashd/internal/lsp"
+)
+
+// Highlight the references in the document of the variable or function under
+// cursor. Assignments and declarations are writes, all other uses reads.
+func handleDocumentHighlight(request *lsp.DocumentHighlightRequest, state *State) *lsp.DocumentHighlightResponse {
+	uri := request.Params.TextDocument.URI
+	mapper := state.NewMapper(state.Documents[uri].Text)
+	cursor := ast.NewCursorAt(mapper, request.Params.Position)
+
+	fileAst, err := state.Documents[uri].TolerantAst()
+	if err != nil {
+		slog.Error(err.Error())
+		return nil
+	}
+
+	refNodes := fileAst.FindRefsInFile(cursor, true)
+	if len(refNodes) == 0 {
+		return nil
+	}
+
+	highlights := make([]lsp.DocumentHighlight, 0, len(refNodes))
+	for _, refNode := range refNodes {
+		kind := lsp.DocumentHighlightRead
+		if refNode.IsWrite {
+			kind = lsp.DocumentHighlightWrite
+		}
+		highlights = append(highlights, lsp.DocumentHighlight{
+			Range: refNode.ToLspLocation(uri, mapper).Range,
+			Kind:  kind,
+		})
+	}
+
+	response := lsp.NewDocumentHighlightResponse(request.ID, highlights)
+	return &response
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/matkrin/bashd/internal/lsp"
+)
+
+func Test_handleDocumentHighlight(t *testing.T) {
+	state := mockState(`x=1
+f() {
+	local x
+	echo "$x"
+}
+read x
+for x in a b; do
+	((x++))
+	echo $((x + 1))
+done
+: "${x:=2}"
+`)
+	request := &lsp.DocumentHighlightRequest{}
+	request.Params.TextDocument.URI = "file://workspace/test.sh"
+	request.Params.Position = lsp.Position{Line: 0, Character: 0}
+
+	response := handleDocumentHighlight(request, state)
+	if response == nil {
+		t.Fatal("expected highlights")
+	}
+
+	// The local x of f is a different variable
+	want := []lsp.DocumentHighlight{
+		{Range: lsp.NewRange(0, 0, 0, 1), Kind: lsp.DocumentHighlightWrite},
+		{Range: lsp.NewRange(5, 5, 5, 6), Kind: lsp.DocumentHighlightWrite},
+		{Range: lsp.NewRange(6, 4, 6, 5), Kind: lsp.DocumentHighlightWrite},
+		{Range: lsp.NewRange(7, 3, 7, 4), Kind: lsp.DocumentHighlightWrite},
+		{Range: lsp.NewRange(8, 9, 8, 10), Kind: lsp.DocumentHighlightRead},
+		{Range: lsp.NewRange(10, 5, 10, 6), Kind: lsp.DocumentHighlightWrite},
+	}
+	if len(response.Result) != len(want) {
+		t.Fatalf("expected %d highlights, got %v", len(want), response.Result)
+	}
+	for i, highlight := range response.Result {
+		if highlight != want[i] {
+			t.Errorf("highlight %d = %v, want %v", i, highlight, want[i])
+		}
+	}
+
+	// In the function, the declaration and expansion of the local x
+	request.Params.Position = lsp.Position{Line: 3, Character: 8}
+	response = handleDocumentHighlight(request, state)
+	if response == nil {
+		t.Fatal("expected highlights")
+	}
+	kinds := []lsp.DocumentHighlightKind{lsp.DocumentHighlightWrite, lsp.DocumentHighlightRead}
+	if len(response.Result) != len(kinds) {
+		t.Fatalf("expected %d highlights, got %v", len(kinds), response.Result)
+	}
+	for i, highlight := range response.Result {
+		if highlight.Kind != kinds[i] {
+			t.Errorf("highlight %d kind = %v, want %v", i, highlight.Kind, kinds[i])
+		}
+	}
+}
+
+func Test_handleDocumentHighlightRead(t *testing.T) {
+	state := mockState(`read -r a b
+echo "$a $b"
+`)
+	request := &lsp.DocumentHighlightRequest{}
+	request.Params.TextDocument.URI = "file://workspace/test.sh"
+
+	// Each variable read into is highlighted on its own, the option is not
+	tests := []struct {
+		position lsp.Position
+		want     []lsp.DocumentHighlight
+	}{
+		{lsp.Position{Line: 0, Character: 8}, []lsp.DocumentHighlight{
+			{Range: lsp.NewRange(0, 8, 0, 9), Kind: lsp.DocumentHighlightWrite},
+			{Range: lsp.NewRange(1, 7, 1, 8), Kind: lsp.DocumentHighlightRead},
+		}},
+		{lsp.Position{Line: 0, Character: 10}, []lsp.DocumentHighlight{
+			{Range: lsp.NewRange(0, 10, 0, 11), Kind: lsp.DocumentHighlightWrite},
+			{Range: lsp.NewRange(1, 10, 1, 11), Kind: lsp.DocumentHighlightRead},
+		}},
+	}
+
+	for _, tt := range tests {
+		request.Params.Position = tt.position
+		response := handleDocumentHighlight(request, state)
+		if response == nil {
+			t.Fatal("expected highlights")
+		}
+		if len(response.Result) != len(tt.want) {
+			t.Fatalf("expected %d highlights, got %v", len(tt.want), response.Result)
+		}
+		for i, highlight := range response.Result {
+			if highlight != tt.want[i] {
+				t.Errorf("highlight %d = %v, want %v", i, highlight, tt.want[i])
+			}
+		}
+	}
+}
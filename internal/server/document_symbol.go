@@ -11,20 +11,52 @@ import (
 func handleDocumentSymbol(request *lsp.DocumentSymbolsRequest, state *State) *lsp.DocumentSymbolResponse {
 	uri := request.Params.TextDocument.URI
 	document := state.Documents[uri]
-	fileAst, err := ast.ParseDocument(document.Text, uri, false)
+	fileAst, err := document.TolerantAst()
 	if err != nil {
 		slog.Error("Could not parse document", "document", uri)
 		return nil
 	}
 
-	documentSymbols := findDocumentSymbols(fileAst.DefNodes())
+	documentSymbols := findDocumentSymbols(fileAst.DefNodes(), state.NewMapper(document.Text))
+
+	if !state.ClientCapabilities.HierarchicalDocumentSymbolSupport() {
+		symbolInformation := flattenDocumentSymbols(uri, documentSymbols, nil)
+		response := lsp.NewSymbolInformationResponse(request.ID, symbolInformation)
+		return &response
+	}
 
 	response := lsp.NewDocumentSymbolResponse(request.ID, documentSymbols)
 	return &response
 }
 
-func findDocumentSymbols(defNodes []ast.DefNode) []lsp.DocumentSymbol {
-	locals := findLocals(defNodes)
+// Flatten the symbol hierarchy for clients that only support
+// `SymbolInformation`. The parent of a symbol becomes its container.
+func flattenDocumentSymbols(
+	uri string,
+	documentSymbols []lsp.DocumentSymbol,
+	containerName *string,
+) []lsp.SymbolInformation {
+	var symbolInformation []lsp.SymbolInformation
+	for _, documentSymbol := range documentSymbols {
+		symbolInformation = append(symbolInformation, lsp.SymbolInformation{
+			Name: documentSymbol.Name,
+			Kind: documentSymbol.Kind,
+			Location: lsp.Location{
+				URI:   uri,
+				Range: documentSymbol.Range,
+			},
+			ContainerName: containerName,
+		})
+		symbolInformation = append(
+			symbolInformation,
+			flattenDocumentSymbols(uri, documentSymbol.Children, &documentSymbol.Name)...,
+		)
+	}
+	return symbolInformation
+}
+
+func findDocumentSymbols(defNodes []ast.DefNode, mapper *lsp.Mapper) []lsp.DocumentSymbol {
+	locals := findLocals(defNodes, mapper)
 	var documentSymbols []lsp.DocumentSymbol
 
 	for _, defNode := range defNodes {
@@ -38,13 +70,11 @@ func findDocumentSymbols(defNodes []ast.DefNode) []lsp.DocumentSymbol {
 		case *syntax.FuncDecl:
 			kind = lsp.SymbolFunction
 
-			endLine = n.Body.End().Line() - 1
-			endCol = n.Body.End().Col() - 1
+			endLine, endCol = nodeEnd(n.Body, mapper)
 
 			selectionStartLine = n.Pos().Line() - 1
 			selectionStartCol = n.Pos().Col() - 1
-			selectionEndLine = n.End().Line() - 1
-			selectionEndCol = n.End().Col() - 1
+			selectionEndLine, selectionEndCol = nodeEnd(n, mapper)
 			children = locals[n.Name.Value]
 
 		case *syntax.DeclClause:
@@ -53,8 +83,7 @@ func findDocumentSymbols(defNodes []ast.DefNode) []lsp.DocumentSymbol {
 			}
 			kind = lsp.SymbolVariable
 
-			endLine = n.End().Line() - 1
-			endCol = n.End().Col() - 1
+			endLine, endCol = nodeEnd(n, mapper)
 
 			selectionStartLine = defNode.StartLine - 1
 			selectionStartCol = defNode.StartChar - 1
@@ -65,8 +94,7 @@ func findDocumentSymbols(defNodes []ast.DefNode) []lsp.DocumentSymbol {
 		case *syntax.Assign:
 			kind = lsp.SymbolVariable
 
-			endLine = n.End().Line() - 1
-			endCol = n.End().Col() - 1
+			endLine, endCol = nodeEnd(n, mapper)
 
 			selectionStartLine = n.Name.Pos().Line() - 1
 			selectionStartCol = n.Name.Pos().Col() - 1
@@ -77,8 +105,7 @@ func findDocumentSymbols(defNodes []ast.DefNode) []lsp.DocumentSymbol {
 		case *syntax.ForClause, *syntax.CallExpr:
 			kind = lsp.SymbolVariable
 
-			endLine = n.End().Line() - 1
-			endCol = n.End().Col() - 1
+			endLine, endCol = nodeEnd(n, mapper)
 
 			selectionStartLine = defNode.StartLine - 1
 			selectionStartCol = defNode.StartChar - 1
@@ -92,8 +119,8 @@ func findDocumentSymbols(defNodes []ast.DefNode) []lsp.DocumentSymbol {
 		documentSymbols = append(documentSymbols, lsp.DocumentSymbol{
 			Name:  defNode.Name,
 			Kind:  kind,
-			Range: lsp.NewRange(startLine, startCol, endLine, endCol),
-			SelectionRange: lsp.NewRange(
+			Range: mapper.ByteRange(startLine, startCol, endLine, endCol),
+			SelectionRange: mapper.ByteRange(
 				selectionStartLine, selectionStartCol, selectionEndLine, selectionEndCol,
 			),
 			Children: children,
@@ -102,7 +129,16 @@ func findDocumentSymbols(defNodes []ast.DefNode) []lsp.DocumentSymbol {
 	return documentSymbols
 }
 
-func findLocals(defNodes []ast.DefNode) map[string][]lsp.DocumentSymbol {
+// 0-based line and byte column of the end of node. Ends the parser recovered,
+// like of an unclosed loop, are the end of the document.
+func nodeEnd(node syntax.Node, mapper *lsp.Mapper) (uint, uint) {
+	if !node.End().IsValid() {
+		return mapper.EndByteColumn()
+	}
+	return node.End().Line() - 1, node.End().Col() - 1
+}
+
+func findLocals(defNodes []ast.DefNode, mapper *lsp.Mapper) map[string][]lsp.DocumentSymbol {
 	locals := make(map[string][]lsp.DocumentSymbol)
 
 	for _, defNode := range defNodes {
@@ -124,13 +160,13 @@ func findLocals(defNodes []ast.DefNode) map[string][]lsp.DocumentSymbol {
 		selectionStartCol := defNode.StartChar - 1
 		selectionEndLine := defNode.EndLine - 1
 		selectionEndCol := defNode.EndChar - 1
-		funcName := defNode.Scope.Name.Value
+		funcName := defNode.Scope.Function().Name.Value
 
 		locals[funcName] = append(locals[funcName], lsp.DocumentSymbol{
 			Name:  defNode.Name,
 			Kind:  lsp.SymbolVariable,
-			Range: lsp.NewRange(startLine, startCol, endLine, endCol),
-			SelectionRange: lsp.NewRange(
+			Range: mapper.ByteRange(startLine, startCol, endLine, endCol),
+			SelectionRange: mapper.ByteRange(
 				selectionStartLine, selectionStartCol, selectionEndLine, selectionEndCol,
 			),
 			Children: []lsp.DocumentSymbol{},
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/matkrin/bashd/internal/lsp"
+)
+
+func Test_handleDocumentSymbol(t *testing.T) {
+	state := mockState("a=1\n\nfoo() {\n\tlocal b=2\n}\n")
+	request := &lsp.DocumentSymbolsRequest{
+		Params: lsp.DocumentSymbolsParams{
+			TextDocument: lsp.TextDocumentIdentifier{URI: "file://workspace/test.sh"},
+		},
+	}
+
+	t.Run("Hierarchical", func(t *testing.T) {
+		state.ClientCapabilities = lsp.ClientCapabilities{
+			TextDocument: &lsp.TextDocumentClientCapabilities{
+				DocumentSymbol: &lsp.DocumentSymbolClientCapabilities{
+					HierarchicalDocumentSymbolSupport: true,
+				},
+			},
+		}
+		response := handleDocumentSymbol(request, state)
+		documentSymbols, ok := response.Result.([]lsp.DocumentSymbol)
+		if !ok {
+			t.Fatalf("expected []lsp.DocumentSymbol, got %T", response.Result)
+		}
+		if len(documentSymbols) != 2 || len(documentSymbols[1].Children) != 1 {
+			t.Errorf("unexpected document symbols %v", documentSymbols)
+		}
+	})
+
+	t.Run("Flat", func(t *testing.T) {
+		state.ClientCapabilities = lsp.ClientCapabilities{}
+		response := handleDocumentSymbol(request, state)
+		symbolInformation, ok := response.Result.([]lsp.SymbolInformation)
+		if !ok {
+			t.Fatalf("expected []lsp.SymbolInformation, got %T", response.Result)
+		}
+		if len(symbolInformation) != 3 {
+			t.Fatalf("expected 3 symbols, got %v", symbolInformation)
+		}
+		local := symbolInformation[2]
+		if local.Name != "b" || local.ContainerName == nil || *local.ContainerName != "foo" {
+			t.Errorf("expected local `b` in container `foo`, got %v", local)
+		}
+		if local.Location.URI != "file://workspace/test.sh" || local.Location.Range != lsp.NewRange(3, 1, 3, 10) {
+			t.Errorf("unexpected location %v", local.Location)
+		}
+	})
+}
@@ -2,6 +2,7 @@ package server
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"log/slog"
@@ -10,20 +11,20 @@ import (
 	"strings"
 )
 
-func getDocumentation(command string) string {
+func getDocumentation(ctx context.Context, command string) string {
 	var documentation string
 	if slices.Contains(append(BASH_KEYWORDS[:], BASH_BUILTINS[:]...), command) {
-		documentation = runHelp(command)
+		documentation = runHelp(ctx, command)
 	} else {
-		documentation = runMan(command)
+		documentation = runMan(ctx, command)
 	}
 
 	return strings.Trim(documentation, "\n")
 }
 
-func runMan(command string) string {
-	manCmd := exec.Command("man", "-p", "cat", command)
-	colCmd := exec.Command("col", "-bx")
+func runMan(ctx context.Context, command string) string {
+	manCmd := exec.CommandContext(ctx, "man", "-p", "cat", command)
+	colCmd := exec.CommandContext(ctx, "col", "-bx")
 
 	manOutput, err := runPipe(manCmd, colCmd)
 	if err != nil {
@@ -32,9 +33,9 @@ func runMan(command string) string {
 	return manOutput
 }
 
-func runHelp(command string) string {
-	helpCmd := exec.Command("bash", "-c", fmt.Sprintf("help %s", command))
-	colCmd := exec.Command("col", "-bx")
+func runHelp(ctx context.Context, command string) string {
+	helpCmd := exec.CommandContext(ctx, "bash", "-c", fmt.Sprintf("help %s", command))
+	colCmd := exec.CommandContext(ctx, "col", "-bx")
 
 	helpOutput, err := runPipe(helpCmd, colCmd)
 	if err != nil {
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"github.com/matkrin/bashd/internal/ast"
+)
+
+// Text and syntax tree of files on disk, so that files are not read and parsed
+// again for every request. Entries are invalidated on changes reported by the
+// client and when modification time or size of the file differ, in case the
+// client does not watch files.
+type fileCache struct {
+	mu    sync.Mutex
+	files map[string]*cachedFile
+}
+
+type cachedFile struct {
+	modTime time.Time
+	size    int64
+	text    string
+	// Parsed on first use, per dialect
+	mu     sync.Mutex
+	parsed map[ast.Dialect]*parsedFile
+}
+
+type parsedFile struct {
+	once sync.Once
+	ast  *ast.Ast
+	err  error
+}
+
+func newFileCache() *fileCache {
+	return &fileCache{files: make(map[string]*cachedFile)}
+}
+
+// Text of the file at path
+func (c *fileCache) text(path string) (string, error) {
+	file, err := c.get(path)
+	if err != nil {
+		return "", err
+	}
+	return file.text, nil
+}
+
+// Syntax tree and text of the file at path in dialect, or the dialect
+// detected from the file if empty
+func (c *fileCache) ast(path string, dialect ast.Dialect) (*ast.Ast, string, error) {
+	file, err := c.get(path)
+	if err != nil {
+		return nil, "", err
+	}
+
+	file.mu.Lock()
+	parsed, ok := file.parsed[dialect]
+	if !ok {
+		parsed = &parsedFile{}
+		file.parsed[dialect] = parsed
+	}
+	file.mu.Unlock()
+
+	parsed.once.Do(func() {
+		parsed.ast, parsed.err = ast.ParseDocumentAs(file.text, path, false, dialect)
+	})
+	return parsed.ast, file.text, parsed.err
+}
+
+func (c *fileCache) invalidate(path string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.files, path)
+}
+
+func (c *fileCache) get(path string) (*cachedFile, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		c.invalidate(path)
+		return nil, err
+	}
+
+	c.mu.Lock()
+	file, ok := c.files[path]
+	c.mu.Unlock()
+	if ok && file.modTime.Equal(info.ModTime()) && file.size == info.Size() {
+		return file, nil
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	file = &cachedFile{
+		modTime: info.ModTime(),
+		size:    info.Size(),
+		text:    string(content),
+		parsed:  make(map[ast.Dialect]*parsedFile),
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.files[path] = file
+	return file, nil
+}
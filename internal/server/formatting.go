@@ -14,8 +14,7 @@ import (
 func handleFormatting(request *lsp.FormattingRequest, state *State) *lsp.FormattingResponse {
 	slog.Info("FORMATTING", "params", request.Params)
 	uri := request.Params.TextDocument.URI
-	document := state.Documents[uri].Text
-	fileAst, err := ast.ParseDocument(document, uri, false)
+	fileAst, err := state.Documents[uri].TolerantAst()
 	if err != nil {
 		return nil
 	}
@@ -28,13 +27,25 @@ func handleFormatting(request *lsp.FormattingRequest, state *State) *lsp.Formatt
 	}
 	indent := syntax.Indent(indentWidth)
 
+	formatOptions := state.ConfigFor(uri).FormatOptions
 	printer := syntax.NewPrinter(indent,
-		syntax.SpaceRedirects(state.Config.FormatOptions.SpaceRedirects),
-		syntax.BinaryNextLine(state.Config.FormatOptions.BinaryNextLine),
-		syntax.FunctionNextLine(state.Config.FormatOptions.FuncNextLine),
-		syntax.SwitchCaseIndent(state.Config.FormatOptions.CaseIndent),
+		syntax.SpaceRedirects(formatOptions.SpaceRedirects),
+		syntax.BinaryNextLine(formatOptions.BinaryNextLine),
+		syntax.FunctionNextLine(formatOptions.FuncNextLine),
+		syntax.SwitchCaseIndent(formatOptions.CaseIndent),
 	)
 
+	if len(fileAst.Broken) > 0 {
+		response := lsp.FormattingResponse{
+			Response: lsp.Response{
+				RPC: lsp.RPC_VERSION,
+				ID:  &request.ID,
+			},
+			Result: formatStmts(fileAst, printer, state.NewMapper(state.Documents[uri].Text)),
+		}
+		return &response
+	}
+
 	buffer := bytes.NewBuffer([]byte{})
 	printer.Print(buffer, fileAst.File)
 
@@ -62,6 +73,38 @@ func handleFormatting(request *lsp.FormattingRequest, state *State) *lsp.Formatt
 	return &response
 }
 
+// Edits formatting the top-level statements of a document with syntax errors
+// one by one, leaving the broken regions as they are
+func formatStmts(fileAst *ast.Ast, printer *syntax.Printer, mapper *lsp.Mapper) []lsp.TextEdit {
+	textEdits := []lsp.TextEdit{}
+	for _, stmt := range fileAst.File.Stmts {
+		// Including the comments before and after the statement
+		start, end := stmt.Pos(), stmt.End()
+		for _, comment := range stmt.Comments {
+			if comment.Pos().Offset() < start.Offset() {
+				start = comment.Pos()
+			}
+			if comment.End().Offset() > end.Offset() {
+				end = comment.End()
+			}
+		}
+		// Broken regions consist of whole statements
+		if !start.IsValid() || !end.IsValid() || fileAst.IsBroken(start.Line(), start.Col()) {
+			continue
+		}
+
+		buffer := bytes.NewBuffer([]byte{})
+		if err := printer.Print(buffer, stmt); err != nil {
+			continue
+		}
+		textEdits = append(textEdits, lsp.TextEdit{
+			Range:   mapper.ByteRange(start.Line()-1, start.Col()-1, end.Line()-1, end.Col()-1),
+			NewText: buffer.String(),
+		})
+	}
+	return textEdits
+}
+
 // TODO: Think about determining nodes from AST of entire document that are
 // in range instead of slicing document and parse then. What's better?
 func handleRangeFormatting(request *lsp.RangeFormattingRequest, state *State) *lsp.RangeFormattingResponse {
@@ -76,7 +119,7 @@ func handleRangeFormatting(request *lsp.RangeFormattingRequest, state *State) *l
 	rangeLines := lines[startLine : endLine+1]
 	rangeString := strings.Join(rangeLines, "\n")
 
-	rangeAst, err := ast.ParseDocument(rangeString, uri, false)
+	rangeAst, err := ast.ParseDocumentAs(rangeString, uri, false, state.Documents[uri].Dialect())
 	if err != nil {
 		return nil
 	}
@@ -1,10 +1,9 @@
 package server
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
-	"os"
-	"path/filepath"
 	"strings"
 
 	"github.com/matkrin/bashd/internal/ast"
@@ -13,14 +12,11 @@ import (
 	"mvdan.cc/sh/v3/syntax"
 )
 
-func handleHover(request *lsp.HoverRequest, state *State) *lsp.HoverResponse {
+func handleHover(ctx context.Context, request *lsp.HoverRequest, state *State) *lsp.HoverResponse {
 	uri := request.Params.TextDocument.URI
-	cursor := ast.NewCursor(
-		request.Params.Position.Line,
-		request.Params.Position.Character,
-	)
 	documentText := state.Documents[uri].Text
-	fileAst, err := ast.ParseDocument(documentText, uri, true)
+	cursor := ast.NewCursorAt(state.NewMapper(documentText), request.Params.Position)
+	fileAst, err := state.Documents[uri].FallibleAst()
 	if err != nil {
 		slog.Error(err.Error())
 		return nil
@@ -31,12 +27,13 @@ func handleHover(request *lsp.HoverRequest, state *State) *lsp.HoverResponse {
 		return nil
 	}
 
-	hoverResultValue := hoverFromDefinition(fileAst, cursor, state, uri)
+	markupKind := state.ClientCapabilities.HoverMarkupKind()
+	hoverResultValue := hoverFromDefinition(fileAst, cursor, state, uri, markupKind)
 
 	identifier := ast.ExtractIdentifier(cursorNode)
-	documentation := getDocumentation(identifier)
+	documentation := getDocumentation(ctx, identifier)
 	if documentation != "" {
-		hoverResultValue = fmt.Sprintf("```man\n%s\n```", documentation)
+		hoverResultValue = codeBlock("man", documentation, markupKind)
 	}
 
 	if hoverResultValue == "" {
@@ -50,7 +47,7 @@ func handleHover(request *lsp.HoverRequest, state *State) *lsp.HoverResponse {
 		},
 		Result: lsp.HoverResult{
 			Contents: lsp.MarkupContent{
-				Kind:  lsp.MarkupKindMarkdown,
+				Kind:  markupKind,
 				Value: hoverResultValue,
 			},
 		},
@@ -58,24 +55,48 @@ func handleHover(request *lsp.HoverRequest, state *State) *lsp.HoverResponse {
 	return &response
 }
 
-func defNodeToHoverString(defNode *ast.DefNode, documentText string, documentName string) string {
+func defNodeToHoverString(
+	defNode *ast.DefNode,
+	documentText string,
+	documentName string,
+	markupKind lsp.MarkupKind,
+) string {
 	if n, ok := defNode.Node.(*syntax.FuncDecl); ok {
 		lines := strings.Split(documentText, "\n")
-		functionSnippet := strings.Join(lines[n.Pos().Line()-1:n.End().Line()], "\n")
+		// Functions whose end was recovered by the parser extend to the end
+		endLine := min(n.End().Line(), uint(len(lines)))
+		if !n.End().IsValid() {
+			endLine = uint(len(lines))
+		}
+		functionSnippet := strings.Join(lines[n.Pos().Line()-1:endLine], "\n")
+		defLocation := definitionLocation(documentName, n.Pos().Line(), markupKind)
+
+		return fmt.Sprintf("%s\n\n(%s)", codeBlock("sh", functionSnippet, markupKind), defLocation)
+	}
 
-		defLocation := fmt.Sprintf("defined at `%s` line **%d**", documentName, n.Pos().Line())
+	return definitionLocation(documentName, defNode.StartLine, markupKind)
+}
+
+func definitionLocation(documentName string, line uint, markupKind lsp.MarkupKind) string {
+	if markupKind != lsp.MarkupKindMarkdown {
 		if documentName == "" {
-		defLocation = fmt.Sprintf("defined at line **%d**", n.Pos().Line())
+			return fmt.Sprintf("defined at line %d", line)
 		}
-
-		return fmt.Sprintf("```sh\n%s\n```\n\n(%s)", functionSnippet, defLocation)
+		return fmt.Sprintf("defined at %s line %d", documentName, line)
 	}
 
 	if documentName == "" {
-		return fmt.Sprintf("defined at line **%d**", defNode.StartLine)
+		return fmt.Sprintf("defined at line **%d**", line)
 	}
+	return fmt.Sprintf("defined at `%s` line **%d**", documentName, line)
+}
 
-	return fmt.Sprintf("defined at `%s` line **%d**", documentName, defNode.StartLine)
+// Fenced code block for markdown, the bare code for plain text
+func codeBlock(language, code string, markupKind lsp.MarkupKind) string {
+	if markupKind != lsp.MarkupKindMarkdown {
+		return code
+	}
+	return fmt.Sprintf("```%s\n%s\n```", language, code)
 }
 
 func hoverFromDefinition(
@@ -83,28 +104,32 @@ func hoverFromDefinition(
 	cursor ast.Cursor,
 	state *State,
 	uri string,
+	markupKind lsp.MarkupKind,
 ) string {
-	defNode := ast.FindDefInFile(cursor)
-	if defNode != nil {
-		documentText := state.Documents[uri].Text
-		return defNodeToHoverString(defNode, documentText, "")
-	}
+	var hovers []string
+	for _, definition := range findDefinitions(ast, cursor, uri, state) {
+		if definition.uri == uri {
+			documentText := state.Documents[uri].Text
+			hovers = append(hovers, defNodeToHoverString(&definition.defNode, documentText, "", markupKind))
+			continue
+		}
 
-	filename, err := utils.UriToPath(uri)
-	if err != nil {
-		slog.Error("ERROR: Could not convert uri to path", "uri", uri)
-		return ""
+		file, err := utils.UriToPath(definition.uri)
+		if err != nil {
+			continue
+		}
+		fileContent, err := state.Files.text(file)
+		if err != nil {
+			slog.Error("ERROR: Could not read file", "file", file)
+			continue
+		}
+		hovers = append(hovers, defNodeToHoverString(&definition.defNode, fileContent, file, markupKind))
 	}
-	baseDir := filepath.Dir(filename)
-	sourcedFile, definition := ast.FindDefInSourcedFile(
-		cursor,
-		state.EnvVars,
-		baseDir,
-	)
-	fileContent, err := os.ReadFile(sourcedFile)
-	if err != nil {
-		slog.Error("ERROR: Could not read file", "file", sourcedFile)
-		return ""
+
+	// Candidate definitions are separated by a horizontal rule
+	separator := "\n\n"
+	if markupKind == lsp.MarkupKindMarkdown {
+		separator = "\n\n---\n\n"
 	}
-	return defNodeToHoverString(definition, string(fileContent), sourcedFile)
+	return strings.Join(hovers, separator)
 }
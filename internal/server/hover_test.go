@@ -0,0 +1,24 @@
+package server
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/matkrin/bashd/internal/lsp"
+)
+
+func Test_handleHoverUnclosedFunction(t *testing.T) {
+	state := mockState("#!/bin/bash\nf() {\n  echo hi\n\nf\n")
+
+	request := &lsp.HoverRequest{}
+	request.Params.TextDocument.URI = "file://workspace/test.sh"
+	request.Params.Position = lsp.Position{Line: 4, Character: 0}
+	response := handleHover(context.Background(), request, state)
+	if response == nil {
+		t.Fatal("expected hover of the function")
+	}
+	if value := response.Result.Contents.Value; !strings.Contains(value, "f() {\n  echo hi") {
+		t.Errorf("expected the function up to the end of the document, got %q", value)
+	}
+}
@@ -3,7 +3,6 @@ package server
 import (
 	"strconv"
 	"strings"
-	"unicode/utf8"
 
 	"github.com/matkrin/bashd/internal/lsp"
 )
@@ -12,7 +11,7 @@ func handleInlayHint(request *lsp.InlayHintRequest, state *State) *lsp.InlayHint
 	uri := request.Params.TextDocument.URI
 	documentText := state.Documents[uri].Text
 
-	inlayHints := extractInlayHints(documentText)
+	inlayHints := extractInlayHints(documentText, state.NewMapper(documentText))
 
 	response := &lsp.InlayHintResponse{
 		Response: lsp.Response{
@@ -26,7 +25,7 @@ func handleInlayHint(request *lsp.InlayHintRequest, state *State) *lsp.InlayHint
 	return response
 }
 
-func extractInlayHints(documentText string) []lsp.InlayHint {
+func extractInlayHints(documentText string, mapper *lsp.Mapper) []lsp.InlayHint {
 	var results []lsp.InlayHint
 
 	lines := strings.Split(documentText, "\n")
@@ -46,14 +45,8 @@ func extractInlayHints(documentText string) []lsp.InlayHint {
 				continue
 			}
 
-			// startChar := utf8.RuneCountInString(line[:startByte])
-			endChar := utf8.RuneCountInString(line[:endByte])
-
 			results = append(results, lsp.InlayHint{
-				Position: lsp.Position{
-					Line:      uint(lineNum),
-					Character: uint(endChar),
-				},
+				Position:     mapper.FromByteColumn(uint(lineNum), uint(endByte)),
 				Label:        inlayHintLabel,
 				Kind:         lsp.InlayHintParameter,
 				TextEdits:    nil,
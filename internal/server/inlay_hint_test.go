@@ -11,6 +11,7 @@ func Test_extractInlayHints(t *testing.T) {
 \e[1m
 echo -e "\x1b[7m test \x1b[0m"
 echo -e "\033[2m test \033[0m"
+echo -e "äö 😀 \e[4m"
 `
 
 	tests := []lsp.InlayHint{
@@ -44,9 +45,14 @@ echo -e "\033[2m test \033[0m"
 			Label:    "reset",
 			Kind:     lsp.InlayHintParameter,
 		},
+		{
+			Position: lsp.Position{Line: 4, Character: 20},
+			Label:    "underline",
+			Kind:     lsp.InlayHintParameter,
+		},
 	}
 
-	inlayHints := extractInlayHints(input)
+	inlayHints := extractInlayHints(input, lsp.NewMapper(input, lsp.PositionEncodingUTF16))
 
 	if len(tests) != len(inlayHints) {
 		t.Errorf("Lenth of tests %d is not equal length of inlay hint results %d", len(tests), len(inlayHints))
@@ -1,8 +1,9 @@
 package server
 
 import (
+	"context"
 	"log/slog"
-	"path/filepath"
+	"maps"
 	"slices"
 
 	"github.com/matkrin/bashd/internal/ast"
@@ -10,60 +11,27 @@ import (
 	"github.com/matkrin/bashd/internal/utils"
 )
 
-func handleReferences(request *lsp.ReferencesRequest, state *State) *lsp.ReferencesResponse {
+func handleReferences(ctx context.Context, request *lsp.ReferencesRequest, state *State) *lsp.ReferencesResponse {
 	params := request.Params
 	uri := params.TextDocument.URI
-	cursor := ast.NewCursor(
-		params.Position.Line,
-		params.Position.Character,
-	)
-
-	// In current file
 	documentText := state.Documents[uri].Text
-	fileAst, err := ast.ParseDocument(documentText, uri, false)
+	mapper := state.NewMapper(documentText)
+	cursor := ast.NewCursorAt(mapper, params.Position)
+
+	fileAst, err := state.Documents[uri].TolerantAst()
 	if err != nil {
 		slog.Error("Could not parse document", "err", err.Error())
 		return nil
 	}
-	referenceNodes := fileAst.FindRefsInFile(cursor, params.Context.IncludeDeclaration)
 
 	var locations []lsp.Location
-	for _, refNode := range referenceNodes {
-		locations = append(locations, refNode.ToLspLocation(uri))
-	}
-
-	// In sourced files
-	filename, err := utils.UriToPath(uri)
-	if err != nil {
-		slog.Error(err.Error())
-	}
-	baseDir := filepath.Dir(filename)
-	referenceNodesInSourcedFiles := fileAst.FindRefsinSourcedFile(
-		cursor,
-		state.EnvVars,
-		baseDir,
-		params.Context.IncludeDeclaration,
-	)
-
-	for file, refNodes := range referenceNodesInSourcedFiles {
-		for _, refNode := range refNodes {
-			locations = append(locations, refNode.ToLspLocation(utils.PathToURI(file)))
+	for _, references := range findReferences(ctx, fileAst, cursor, uri, state, params.Context.IncludeDeclaration) {
+		fileMapper := mapper
+		if references.uri != uri {
+			fileMapper = state.FileMapper(references.path)
 		}
-	}
-
-	// In workspace files that source current file
-	refNodesInWorkspaceFile := fileAst.FindRefsInWorkspaceFiles(
-		uri,
-		state.WorkspaceShFiles(),
-		baseDir,
-		cursor,
-		state.EnvVars,
-		params.Context.IncludeDeclaration,
-	)
-
-	for file, refNodes := range refNodesInWorkspaceFile {
-		for _, refNode := range refNodes {
-			location := refNode.ToLspLocation(utils.PathToURI(file))
+		for _, refNode := range references.refNodes {
+			location := refNode.ToLspLocation(references.uri, fileMapper)
 			if !slices.Contains(locations, location) {
 				locations = append(locations, location)
 			}
@@ -81,3 +49,55 @@ func handleReferences(request *lsp.ReferencesRequest, state *State) *lsp.Referen
 	return &response
 }
 
+// References in the document or file with uri, which is at path
+type referencesInFile struct {
+	uri      string
+	path     string
+	refNodes []ast.RefNode
+}
+
+// References of the identifier under cursor in the document with uri, in the
+// document itself, the files it sources and the workspace files sourcing it.
+// Files may occur more than once.
+func findReferences(
+	ctx context.Context,
+	fileAst *ast.Ast,
+	cursor ast.Cursor,
+	uri string,
+	state *State,
+	includeDeclaration bool,
+) []referencesInFile {
+	path, _ := utils.UriToPath(uri)
+	references := []referencesInFile{{uri, path, fileAst.FindRefsInFile(cursor, includeDeclaration)}}
+
+	// In sourced files
+	sourcedFiles := state.SourcedFiles(uri)
+	referenceNodesInSourcedFiles := fileAst.FindRefsinSourcedFile(
+		cursor,
+		sourcedFiles,
+		state.parseFile,
+		includeDeclaration,
+	)
+	for _, file := range slices.Sorted(maps.Keys(referenceNodesInSourcedFiles)) {
+		references = append(references, referencesInFile{
+			utils.PathToURI(file), file, referenceNodesInSourcedFiles[file],
+		})
+	}
+
+	// In workspace files that source current file
+	refNodesInWorkspaceFile := fileAst.FindRefsInWorkspaceFiles(
+		ctx,
+		state.dependentFilesReferencing(uri, fileAst, cursor),
+		sourcedFiles,
+		state.parseFile,
+		cursor,
+		includeDeclaration,
+	)
+	for _, file := range slices.Sorted(maps.Keys(refNodesInWorkspaceFile)) {
+		references = append(references, referencesInFile{
+			utils.PathToURI(file), file, refNodesInWorkspaceFile[file],
+		})
+	}
+
+	return references
+}
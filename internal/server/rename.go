@@ -1,8 +1,10 @@
 package server
 
 import (
+	"context"
+	"fmt"
 	"log/slog"
-	"path/filepath"
+	"maps"
 	"slices"
 
 	"github.com/matkrin/bashd/internal/ast"
@@ -13,31 +15,32 @@ import (
 func handlePrepareRename(
 	request *lsp.PrepareRenameRequest,
 	state *State,
-) *lsp.PrepareRenameResponse {
+) (*lsp.PrepareRenameResponse, error) {
 	params := request.Params
 	uri := params.TextDocument.URI
-	cursor := ast.NewCursor(
-		params.Position.Line,
-		params.Position.Character,
-	)
-
 	document := state.Documents[uri].Text
-	fileAst, err := ast.ParseDocument(document, uri, false)
+	mapper := state.NewMapper(document)
+	cursor := ast.NewCursorAt(mapper, params.Position)
+
+	fileAst, err := state.Documents[uri].TolerantAst()
 	if err != nil {
 		slog.Error(err.Error())
-		return nil
+		return nil, nil
 	}
 	cursorNode := fileAst.FindNodeUnderCursor(cursor)
 	referenceNodes := fileAst.FindRefsInFile(cursor, true)
 
 	slog.Info("Prepare rename", "referenceNodes", referenceNodes)
 	if len(referenceNodes) == 0 {
-		return nil
+		return nil, nil
 	}
 
 	identifier := ast.ExtractIdentifier(cursorNode)
 	if slices.Contains(state.PathItems, identifier) || slices.Contains(BASH_BUILTINS[:], identifier) {
-		return nil
+		return nil, nil
+	}
+	if err := checkRenameable(fileAst, identifier); err != nil {
+		return nil, err
 	}
 
 	response := lsp.PrepareRenameResponse{
@@ -45,46 +48,43 @@ func handlePrepareRename(
 			RPC: lsp.RPC_VERSION,
 			ID:  &request.ID,
 		},
-		Result: lsp.NewRange(
+		Result: mapper.ByteRange(
 			cursorNode.Pos().Line()-1,
 			cursorNode.Pos().Col()-1,
 			cursorNode.End().Line()-1,
 			cursorNode.End().Col()-1,
 		),
 	}
-	return &response
+	return &response, nil
 }
 
-func handleRename(request *lsp.RenameRequest, state *State) *lsp.RenameResponse {
+func handleRename(ctx context.Context, request *lsp.RenameRequest, state *State) (*lsp.RenameResponse, error) {
 	params := request.Params
 	uri := params.TextDocument.URI
-	cursor := ast.NewCursor(
-		params.Position.Line,
-		params.Position.Character,
-	)
-
 	document := state.Documents[uri].Text
-	fileAst, err := ast.ParseDocument(document, uri, false)
+	mapper := state.NewMapper(document)
+	cursor := ast.NewCursorAt(mapper, params.Position)
+
+	fileAst, err := state.Documents[uri].TolerantAst()
 	if err != nil {
 		slog.Error(err.Error())
-		return nil
+		return nil, nil
+	}
+	identifier := ast.ExtractIdentifier(fileAst.FindNodeUnderCursor(cursor))
+	if err := checkRenameable(fileAst, identifier); err != nil {
+		return nil, err
 	}
 	referenceNodes := fileAst.FindRefsInFile(cursor, true)
 
 	changes := make(map[string][]lsp.TextEdit)
-	changes[uri] = findTextEditsInFile(referenceNodes, params.NewName)
+	changes[uri] = findTextEditsInFile(referenceNodes, params.NewName, mapper)
 
 	// In sourced files
-	filename, err := utils.UriToPath(uri)
-	if err != nil {
-		slog.Error("Could not transform URI to path", "err", err.Error())
-		return nil
-	}
-	baseDir := filepath.Dir(filename)
+	sourcedFiles := state.SourcedFiles(uri)
 	referenceNodesInSourcedFiles := fileAst.FindRefsinSourcedFile(
 		cursor,
-		state.EnvVars,
-		baseDir,
+		sourcedFiles,
+		state.parseFile,
 		true,
 	)
 
@@ -92,46 +92,98 @@ func handleRename(request *lsp.RenameRequest, state *State) *lsp.RenameResponse
 		fileUri := utils.PathToURI(file)
 		changes[fileUri] = append(
 			changes[fileUri],
-			findTextEditsInFile(refNodes, params.NewName)...,
+			findTextEditsInFile(refNodes, params.NewName, state.FileMapper(file))...,
 		)
 	}
 
 	// In workspace files that source current file
 	refNodesInWorkspaceFile := fileAst.FindRefsInWorkspaceFiles(
-		uri,
-		state.WorkspaceShFiles(),
-		baseDir,
+		ctx,
+		state.dependentFilesReferencing(uri, fileAst, cursor),
+		sourcedFiles,
+		state.parseFile,
 		cursor,
-		state.EnvVars,
 		true,
 	)
 
 	for file, refNodes := range refNodesInWorkspaceFile {
+		fileMapper := state.FileMapper(file)
 		for _, refNode := range refNodes {
 			fileUri := utils.PathToURI(file)
-			textEdit := refNode.ToLspTextEdit(params.NewName)
+			textEdit := refNode.ToLspTextEdit(params.NewName, fileMapper)
 			if !slices.Contains(changes[fileUri], textEdit) {
 				changes[fileUri] = append(changes[fileUri], textEdit)
 			}
 		}
 	}
 
+	// The other files edited must not have syntax errors around the name either
+	for _, fileUri := range slices.Sorted(maps.Keys(changes)) {
+		if fileUri == uri {
+			continue
+		}
+		path, err := utils.UriToPath(fileUri)
+		if err != nil {
+			continue
+		}
+		changedFileAst, err := state.parseFile(path)
+		if err != nil {
+			continue
+		}
+		if err := checkRenameable(changedFileAst, identifier); err != nil {
+			return nil, err
+		}
+	}
+
 	response := lsp.RenameResponse{
 		Response: lsp.Response{
 			RPC: lsp.RPC_VERSION,
 			ID:  &request.ID,
 		},
-		Result: &lsp.WorkspaceEdit{
-			Changes: changes,
-		},
+		Result: newWorkspaceEdit(changes, state),
+	}
+	return &response, nil
+}
+
+// A name can be renamed unless it occurs in a region of a document with
+// syntax errors, where renaming would miss references
+func checkRenameable(fileAst *ast.Ast, identifier string) error {
+	if identifier != "" && fileAst.IsBrokenName(identifier) {
+		return fmt.Errorf("%w: `%s` occurs in code with syntax errors, fix them to rename it", errRequestFailed, identifier)
+	}
+	return nil
+}
+
+// Workspace edit with versioned document changes if the client supports them,
+// so that edits are not applied to documents that changed in the meantime.
+// Edits of open documents are based on their text, not on the files on disk.
+func newWorkspaceEdit(changes map[string][]lsp.TextEdit, state *State) *lsp.WorkspaceEdit {
+	if !state.ClientCapabilities.DocumentChangesSupport() {
+		return &lsp.WorkspaceEdit{Changes: changes}
+	}
+
+	uris := slices.Sorted(maps.Keys(changes))
+	documentChanges := make([]lsp.TextDocumentEdit, 0, len(uris))
+	for _, uri := range uris {
+		var version *int
+		if document, ok := state.Documents[uri]; ok {
+			version = &document.Version
+		}
+		documentChanges = append(documentChanges, lsp.TextDocumentEdit{
+			TextDocument: lsp.OptionalVersionedTextDocumentIdentifier{
+				TextDocumentIdentifier: lsp.TextDocumentIdentifier{URI: uri},
+				Version:                version,
+			},
+			Edits: changes[uri],
+		})
 	}
-	return &response
+	return &lsp.WorkspaceEdit{DocumentChanges: documentChanges}
 }
 
-func findTextEditsInFile(referenceNodes []ast.RefNode, newText string) []lsp.TextEdit {
+func findTextEditsInFile(referenceNodes []ast.RefNode, newText string, mapper *lsp.Mapper) []lsp.TextEdit {
 	var textEdits []lsp.TextEdit
 	for _, refNode := range referenceNodes {
-		textEdits = append(textEdits, refNode.ToLspTextEdit(newText))
+		textEdits = append(textEdits, refNode.ToLspTextEdit(newText, mapper))
 	}
 	return textEdits
 }
@@ -0,0 +1,153 @@
+package server
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/matkrin/bashd/internal/lsp"
+	"github.com/matkrin/bashd/internal/utils"
+)
+
+func Test_newWorkspaceEdit(t *testing.T) {
+	state := mockState("a=1\n")
+	state.SetDocument("file://workspace/test.sh", "a=1\n", 4)
+	changes := map[string][]lsp.TextEdit{
+		"file://workspace/test.sh":  {{Range: lsp.NewRange(0, 0, 0, 1), NewText: "b"}},
+		"file://workspace/other.sh": {{Range: lsp.NewRange(1, 5, 1, 6), NewText: "b"}},
+	}
+
+	t.Run("Changes", func(t *testing.T) {
+		state.ClientCapabilities = lsp.ClientCapabilities{}
+		edit := newWorkspaceEdit(changes, state)
+		if len(edit.Changes) != 2 || edit.DocumentChanges != nil {
+			t.Errorf("expected changes only, got %v", edit)
+		}
+	})
+
+	t.Run("DocumentChanges", func(t *testing.T) {
+		state.ClientCapabilities = lsp.ClientCapabilities{
+			Workspace: &lsp.WorkspaceClientCapabilities{
+				WorkspaceEdit: &lsp.WorkspaceEditClientCapabilities{DocumentChanges: true},
+			},
+		}
+		edit := newWorkspaceEdit(changes, state)
+		if edit.Changes != nil || len(edit.DocumentChanges) != 2 {
+			t.Fatalf("expected document changes only, got %v", edit)
+		}
+
+		// Sorted by URI, only open documents are versioned
+		other, current := edit.DocumentChanges[0], edit.DocumentChanges[1]
+		if other.TextDocument.URI != "file://workspace/other.sh" || other.TextDocument.Version != nil {
+			t.Errorf("unexpected document change %v", other)
+		}
+		if current.TextDocument.Version == nil || *current.TextDocument.Version != 4 {
+			t.Errorf("expected version 4, got %v", current.TextDocument.Version)
+		}
+	})
+}
+
+func Test_handleRenameBroken(t *testing.T) {
+	state := mockState("a=1\nb=2\nf() {\n  echo $a\n}\necho $a $b\n")
+	state.SetDocument("file://workspace/test.sh", "a=1\nb=2\nf() {\n  if true; then\n  echo $a\n}\necho $a $b\n", 1)
+
+	rename := func(character uint) (*lsp.RenameResponse, error) {
+		request := &lsp.RenameRequest{Params: lsp.RenameParams{NewName: "c"}}
+		request.Params.TextDocument.URI = "file://workspace/test.sh"
+		request.Params.Position = lsp.Position{Line: 6, Character: character}
+		return handleRename(t.Context(), request, state)
+	}
+
+	if _, err := rename(6); !errors.Is(err, errRequestFailed) {
+		t.Errorf("expected renaming a referenced in the broken function to fail, got %v", err)
+	}
+
+	response, err := rename(9)
+	if err != nil {
+		t.Fatalf("expected renaming b to succeed, got %v", err)
+	}
+	if edits := response.Result.Changes["file://workspace/test.sh"]; len(edits) != 2 {
+		t.Errorf("expected 2 edits, got %v", edits)
+	}
+}
+
+func Test_handleRenameOpenSourcedFile(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"lib.sh":  "x=1\n",
+		"main.sh": "source ./lib.sh\necho $x\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	state := NewState(Config{})
+	state.WorkspaceFolders = []lsp.WorkspaceFolder{{URI: utils.PathToURI(dir), Name: "workspace"}}
+	libURI := utils.PathToURI(filepath.Join(dir, "lib.sh"))
+	mainURI := utils.PathToURI(filepath.Join(dir, "main.sh"))
+	state.SetDocument(mainURI, files["main.sh"], 1)
+	// Unsaved changes of lib.sh move the definition
+	state.SetDocument(libURI, "\n\nx=1\n", 3)
+	state.ClientCapabilities = lsp.ClientCapabilities{
+		Workspace: &lsp.WorkspaceClientCapabilities{
+			WorkspaceEdit: &lsp.WorkspaceEditClientCapabilities{DocumentChanges: true},
+		},
+	}
+
+	request := &lsp.RenameRequest{Params: lsp.RenameParams{NewName: "y"}}
+	request.Params.TextDocument.URI = mainURI
+	request.Params.Position = lsp.Position{Line: 1, Character: 6}
+	response, err := handleRename(t.Context(), request, &state)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, documentChange := range response.Result.DocumentChanges {
+		if documentChange.TextDocument.URI != libURI {
+			continue
+		}
+		version := documentChange.TextDocument.Version
+		if version == nil || *version != 3 {
+			t.Errorf("expected version 3, got %v", version)
+		}
+		want := []lsp.TextEdit{{Range: lsp.NewRange(2, 0, 2, 1), NewText: "y"}}
+		if !slices.Equal(documentChange.Edits, want) {
+			t.Errorf("expected edits %v of the open document, got %v", want, documentChange.Edits)
+		}
+		return
+	}
+	t.Errorf("expected edits of lib.sh, got %v", response.Result.DocumentChanges)
+}
+
+func Test_handleRenameBrokenSourcedFile(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"lib.sh":  "a=1\nf() {\n  echo $a\n}\n",
+		"main.sh": "source ./lib.sh\necho $a\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	state := NewState(Config{})
+	state.WorkspaceFolders = []lsp.WorkspaceFolder{{URI: utils.PathToURI(dir), Name: "workspace"}}
+	libURI := utils.PathToURI(filepath.Join(dir, "lib.sh"))
+	mainURI := utils.PathToURI(filepath.Join(dir, "main.sh"))
+	state.SetDocument(mainURI, files["main.sh"], 1)
+	state.SetDocument(libURI, files["lib.sh"], 1)
+	state.SetDocument(libURI, "a=1\nf() {\n  if true; then\n  echo $a\n}\n", 2)
+
+	// a is referenced in the broken function of the sourced file
+	request := &lsp.RenameRequest{Params: lsp.RenameParams{NewName: "c"}}
+	request.Params.TextDocument.URI = mainURI
+	request.Params.Position = lsp.Position{Line: 1, Character: 6}
+	if _, err := handleRename(t.Context(), request, &state); !errors.Is(err, errRequestFailed) {
+		t.Errorf("expected renaming a to fail, got %v", err)
+	}
+}
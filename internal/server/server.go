@@ -1,16 +1,21 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log/slog"
+	"maps"
 	"os"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/matkrin/bashd/internal/lsp"
+	"github.com/matkrin/bashd/internal/utils"
 )
 
 type queuedMessage struct {
@@ -19,24 +24,50 @@ type queuedMessage struct {
 }
 
 type Server struct {
-	name            string
-	version         string
-	state           State
-	writer          io.Writer
-	messageQueue    chan queuedMessage
-	wg              sync.WaitGroup
-	diagnosticTimer *time.Timer
-	mu              sync.Mutex
+	name              string
+	version           string
+	state             State
+	writer            io.Writer
+	messageQueue      chan queuedMessage
+	wg                sync.WaitGroup
+	diagnostics       *diagnosticScheduler
+	mu                sync.Mutex
+	diagnosticResults *diagnosticCache
+	// Whether the client pulls diagnostics instead of getting them pushed.
+	// Clients registering the pull diagnostics provider dynamically get no
+	// diagnostics while the registration is pending.
+	pullDiagnostics        bool
+	registeringDiagnostics bool
+	// Background diagnostics of the workspace files
+	workspaceDiagnostics workspaceDiagnostics
+	// Cancels background work on stop
+	ctx    context.Context
+	cancel context.CancelFunc
+	// Cancels the running build of the workspace index
+	cancelIndexing context.CancelFunc
+	// Cancel functions of requests in flight
+	requests map[lsp.RequestID]context.CancelFunc
+	// Handlers for responses to requests sent to the client
+	pendingResponses map[lsp.RequestID]func(lsp.ClientResponse)
+	nextRequestID    int
+	requestsMu       sync.Mutex
 }
 
 func NewServer(name, version string, state State, writer io.Writer) *Server {
 	s := &Server{
-		name:         name,
-		version:      version,
-		state:        state,
-		writer:       writer,
-		messageQueue: make(chan queuedMessage),
+		name:              name,
+		version:           version,
+		state:             state,
+		writer:            writer,
+		messageQueue:      make(chan queuedMessage),
+		diagnosticResults: newDiagnosticCache(),
+		requests:          make(map[lsp.RequestID]context.CancelFunc),
+		pendingResponses:  make(map[lsp.RequestID]func(lsp.ClientResponse)),
 	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.diagnostics = newDiagnosticScheduler(func(uri string, version int, diagnostics []lsp.Diagnostic) {
+		s.pushDiagnostic(uri, &version, diagnostics)
+	})
 
 	s.wg.Add(1)
 	go s.run()
@@ -47,20 +78,87 @@ func NewServer(name, version string, state State, writer io.Writer) *Server {
 func (s *Server) run() {
 	defer s.wg.Done()
 	for msg := range s.messageQueue {
-		s.dispatchMessage(msg.method, msg.contents)
+		s.handleQueuedMessage(msg)
 	}
 }
 
 func (s *Server) Stop() {
 	close(s.messageQueue)
+	s.cancel()
 	s.wg.Wait()
+	s.workspaceDiagnostics.stop()
+	s.diagnostics.stop()
+	s.state.Index.save(s.state.Config.IndexCacheDir, &s.state)
 }
 
 func (s *Server) HandleMessage(method string, contents []byte) {
 	s.messageQueue <- queuedMessage{method: method, contents: contents}
 }
 
-func (s *Server) dispatchMessage(method string, contents []byte) {
+// Requests that do not modify the state. They are handled concurrently on a
+// snapshot of the state taken when the request is received.
+var concurrentMethods = map[string]bool{
+	"textDocument/hover":                true,
+	"textDocument/definition":           true,
+	"textDocument/declaration":          true,
+	"textDocument/references":           true,
+	"textDocument/documentHighlight":    true,
+	"textDocument/prepareCallHierarchy": true,
+	"callHierarchy/incomingCalls":       true,
+	"callHierarchy/outgoingCalls":       true,
+	"textDocument/completion":           true,
+	"completionItem/resolve":            true,
+	"textDocument/documentSymbol":       true,
+	"textDocument/prepareRename":        true,
+	"textDocument/rename":               true,
+	"workspace/symbol":                  true,
+	"textDocument/formatting":           true,
+	"textDocument/rangeFormatting":      true,
+	"textDocument/codeAction":           true,
+	"textDocument/documentColor":        true,
+	"textDocument/inlayHint":            true,
+	"textDocument/diagnostic":           true,
+	"workspace/diagnostic":              true,
+	"bashd/sourceGraph":                 true,
+}
+
+var (
+	errInvalidParams  = errors.New("ERROR: Could not parse request")
+	errMethodNotFound = errors.New("ERROR: Method not found")
+	errRequestFailed  = errors.New("ERROR: Request failed")
+)
+
+// Messages with an ID are requests that must be answered, messages without ID
+// are notifications
+func (s *Server) handleQueuedMessage(msg queuedMessage) {
+	if !json.Valid(msg.contents) {
+		slog.Error("ERROR", "method", msg.method, "err", "Could not parse message")
+		s.writeResponse(lsp.NewErrorResponse(nil, lsp.ParseError, "Could not parse message"))
+		return
+	}
+
+	var message lsp.BaseMessage
+	if err := json.Unmarshal(msg.contents, &message); err != nil {
+		slog.Error("ERROR", "method", msg.method, "err", err)
+		s.writeResponse(lsp.NewErrorResponse(nil, lsp.InvalidRequest, err.Error()))
+		return
+	}
+
+	switch {
+	case message.ID == nil:
+		s.dispatchNotification(msg.method, msg.contents)
+	case msg.method == "":
+		if err := s.onResponse(*message.ID, msg.contents); err != nil {
+			slog.Error("ERROR", "id", message.ID, "err", err)
+		}
+	case concurrentMethods[msg.method]:
+		s.dispatchConcurrent(*message.ID, msg.method, msg.contents)
+	default:
+		s.dispatchMessage(*message.ID, msg.method, msg.contents)
+	}
+}
+
+func (s *Server) dispatchMessage(id lsp.RequestID, method string, contents []byte) {
 	slog.Info("Received message", "method", method)
 	var err error
 	switch method {
@@ -68,54 +166,244 @@ func (s *Server) dispatchMessage(method string, contents []byte) {
 		err = s.onInitialize(contents)
 	case "shutdown":
 		err = s.onShutdown(contents)
+	default:
+		err = fmt.Errorf("%w: %s", errMethodNotFound, method)
+	}
+
+	if err != nil {
+		s.writeError(id, method, err)
+	}
+}
+
+func (s *Server) dispatchNotification(method string, contents []byte) {
+	slog.Info("Received notification", "method", method)
+	var err error
+	switch method {
 	case "exit":
 		s.onExit()
+	case "initialized":
+		s.onInitialized()
+	case "$/cancelRequest":
+		err = s.onCancelRequest(contents)
+	case "window/workDoneProgress/cancel":
+		err = s.onWorkDoneProgressCancel(contents)
 	case "textDocument/didOpen":
 		err = s.onTextDocumentDidOpen(contents)
 	case "textDocument/didChange":
 		err = s.onTextDocumentDidChange(contents)
+	case "textDocument/didClose":
+		err = s.onTextDocumentDidClose(contents)
+	case "textDocument/didSave":
+		err = s.onTextDocumentDidSave(contents)
 	case "workspace/didChangeConfiguration":
 		err = s.onDidChangeConfiguration(contents)
+	case "workspace/didChangeWatchedFiles":
+		err = s.onDidChangeWatchedFiles(contents)
+	case "workspace/didChangeWorkspaceFolders":
+		err = s.onDidChangeWorkspaceFolders(contents)
+	default:
+		// Notifications starting with `$/` are protocol dependent and may be
+		// ignored
+		if !strings.HasPrefix(method, "$/") {
+			slog.Warn("Unhandled notification", "method", method)
+		}
+	}
+
+	// Notifications can not be answered, so errors only get logged
+	if err != nil {
+		slog.Error("ERROR", "method", method, "err", err)
+	}
+}
+
+func (s *Server) dispatchConcurrent(id lsp.RequestID, method string, contents []byte) {
+	ctx := s.startRequest(id)
+	state := s.state.Snapshot()
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer s.finishRequest(id)
+		defer func() {
+			if r := recover(); r != nil {
+				s.writeError(id, method, fmt.Errorf("ERROR: %v", r))
+			}
+		}()
+
+		if err := s.dispatchRequest(ctx, method, &state, contents); err != nil {
+			s.writeError(id, method, err)
+		}
+	}()
+}
+
+// Answer a failed request with an error response
+func (s *Server) writeError(id lsp.RequestID, method string, err error) {
+	code := lsp.InternalError
+	switch {
+	case errors.Is(err, context.Canceled):
+		slog.Info("Request cancelled", "method", method, "id", id)
+		s.writeResponse(lsp.NewErrorResponse(&id, lsp.RequestCancelled, "Request cancelled"))
+		return
+	case errors.Is(err, errInvalidParams):
+		code = lsp.InvalidParams
+	case errors.Is(err, errMethodNotFound):
+		code = lsp.MethodNotFound
+	case errors.Is(err, errRequestFailed):
+		code = lsp.RequestFailed
+	}
+
+	slog.Error("ERROR", "method", method, "id", id, "err", err)
+	s.writeResponse(lsp.NewErrorResponse(&id, code, err.Error()))
+}
+
+// Answer a request with its result. A missing result is sent as `null`, so that
+// the client does not wait for a response.
+func writeResult[T any](s *Server, id lsp.RequestID, response *T) {
+	if response == nil {
+		s.writeResponse(lsp.NewNullResponse(id))
+		return
+	}
+	s.writeResponse(response)
+}
+
+func (s *Server) dispatchRequest(ctx context.Context, method string, state *State, contents []byte) error {
+	slog.Info("Received request", "method", method)
+	switch method {
 	case "textDocument/hover":
-		err = s.onTextDocumentHover(contents)
+		return s.onTextDocumentHover(ctx, state, contents)
 	case "textDocument/definition":
-		err = s.onTextDocumentDefinition(contents)
+		return s.onTextDocumentDefinition(ctx, state, contents)
+	case "textDocument/declaration":
+		return s.onTextDocumentDeclaration(ctx, state, contents)
 	case "textDocument/references":
-		err = s.onTextDocumentReferences(contents)
+		return s.onTextDocumentReferences(ctx, state, contents)
+	case "textDocument/documentHighlight":
+		return s.onTextDocumentDocumentHighlight(ctx, state, contents)
+	case "textDocument/prepareCallHierarchy":
+		return s.onTextDocumentPrepareCallHierarchy(ctx, state, contents)
+	case "callHierarchy/incomingCalls":
+		return s.onCallHierarchyIncomingCalls(ctx, state, contents)
+	case "callHierarchy/outgoingCalls":
+		return s.onCallHierarchyOutgoingCalls(ctx, state, contents)
 	case "textDocument/completion":
-		err = s.onTextDocumentCompletion(contents)
+		return s.onTextDocumentCompletion(ctx, state, contents)
 	case "completionItem/resolve":
-		err = s.onCompletionItemResolve(contents)
+		return s.onCompletionItemResolve(ctx, state, contents)
 	case "textDocument/documentSymbol":
-		err = s.onTextDocumentDocumentSymbol(contents)
+		return s.onTextDocumentDocumentSymbol(ctx, state, contents)
 	case "textDocument/prepareRename":
-		err = s.onTextDocumentPerpareRename(contents)
+		return s.onTextDocumentPerpareRename(ctx, state, contents)
 	case "textDocument/rename":
-		err = s.onTextDocumentRename(contents)
+		return s.onTextDocumentRename(ctx, state, contents)
 	case "workspace/symbol":
-		err = s.onWorkspaceSymbol(contents)
+		return s.onWorkspaceSymbol(ctx, state, contents)
 	case "textDocument/formatting":
-		err = s.onTextDocumentFormatting(contents)
+		return s.onTextDocumentFormatting(ctx, state, contents)
 	case "textDocument/rangeFormatting":
-		err = s.onTextDocumentRangeFormatting(contents)
+		return s.onTextDocumentRangeFormatting(ctx, state, contents)
 	case "textDocument/codeAction":
-		err = s.onTextDocumentCodeAction(contents)
+		return s.onTextDocumentCodeAction(ctx, state, contents)
 	case "textDocument/documentColor":
-		err = s.onTextDocumentDocumentColor(contents)
+		return s.onTextDocumentDocumentColor(ctx, state, contents)
 	case "textDocument/inlayHint":
-		err = s.onTextDocumentInlayHint(contents)
+		return s.onTextDocumentInlayHint(ctx, state, contents)
+	case "textDocument/diagnostic":
+		return s.onTextDocumentDiagnostic(ctx, state, contents)
+	case "workspace/diagnostic":
+		return s.onWorkspaceDiagnostic(ctx, state, contents)
+	case "bashd/sourceGraph":
+		return s.onSourceGraph(ctx, state, contents)
+	}
+	return fmt.Errorf("%w: %s", errMethodNotFound, method)
+}
+
+// Register a request as in flight. The returned context is cancelled by a
+// `$/cancelRequest` notification for the request.
+func (s *Server) startRequest(id lsp.RequestID) context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.requestsMu.Lock()
+	defer s.requestsMu.Unlock()
+	s.requests[id] = cancel
+	return ctx
+}
+
+func (s *Server) finishRequest(id lsp.RequestID) {
+	s.requestsMu.Lock()
+	defer s.requestsMu.Unlock()
+	if cancel, ok := s.requests[id]; ok {
+		cancel()
+		delete(s.requests, id)
 	}
+}
 
-	if err != nil {
-		slog.Error("ERROR", "method", method, "err", err)
+// Send a request to the client. onResponse, if not nil, gets called with the
+// response of the client from the message loop.
+func (s *Server) sendRequest(method string, params any, onResponse func(lsp.ClientResponse)) {
+	s.requestsMu.Lock()
+	s.nextRequestID++
+	id := lsp.NewNumberID(s.nextRequestID)
+	if onResponse != nil {
+		s.pendingResponses[id] = onResponse
+	}
+	s.requestsMu.Unlock()
+
+	slog.Info("Sending request", "method", method, "id", id)
+	s.writeResponse(lsp.NewServerRequest(id, method, params))
+}
+
+func (s *Server) onResponse(id lsp.RequestID, contents []byte) error {
+	var response lsp.ClientResponse
+	if err := json.Unmarshal(contents, &response); err != nil {
+		return errInvalidParams
+	}
+
+	s.requestsMu.Lock()
+	onResponse, ok := s.pendingResponses[id]
+	delete(s.pendingResponses, id)
+	s.requestsMu.Unlock()
+
+	if response.Error != nil {
+		slog.Error("Request to client failed", "id", id, "err", response.Error.Message)
+	}
+	if ok {
+		onResponse(response)
 	}
+	return nil
 }
 
-func (s *Server) pushDiagnostic(uri string, diagnostics []lsp.Diagnostic) {
-	notification := lsp.NewDiagnosticNotification(uri, diagnostics)
+func (s *Server) pushDiagnostic(uri string, version *int, diagnostics []lsp.Diagnostic) {
+	notification := lsp.NewDiagnosticNotification(uri, version, diagnostics)
 	s.writeResponse(notification)
 }
 
+// Whether diagnostics get pushed to the client, as it does not pull them
+func (s *Server) pushesDiagnostics() bool {
+	return !s.pullDiagnostics && !s.registeringDiagnostics
+}
+
+// Schedule diagnostics for an open document. Diagnostics of previous versions
+// of the document that are still pending or running get discarded.
+func (s *Server) scheduleDiagnostics(uri string, document Document, delay time.Duration) {
+	// Clients pulling diagnostics request them on their own
+	if !s.pushesDiagnostics() {
+		return
+	}
+
+	positionEncoding := s.state.PositionEncoding
+	envVars := s.state.EnvVars
+	shellCheckOptions := s.state.ConfigFor(uri).ShellCheckOptions
+	s.diagnostics.schedule(uri, document.Version, delay, func(ctx context.Context) []lsp.Diagnostic {
+		return findDiagnostics(ctx, document, uri, positionEncoding, envVars, shellCheckOptions)
+	})
+}
+
+// Ask clients pulling diagnostics to pull them again, e.g. because
+// diagnostics depend on changed settings or sourced files
+func (s *Server) refreshDiagnostics() {
+	if s.state.ClientCapabilities.DiagnosticRefreshSupport() {
+		s.sendRequest("workspace/diagnostic/refresh", nil, nil)
+	}
+}
+
 func (s *Server) writeResponse(msg any) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -128,7 +416,7 @@ func (s *Server) writeResponse(msg any) {
 func (s *Server) onInitialize(contents []byte) error {
 	var request lsp.InitializeRequest
 	if err := json.Unmarshal(contents, &request); err != nil {
-		return errors.New("ERROR: Could not parse request")
+		return errInvalidParams
 	}
 
 	if request.Params.ClientInfo != nil {
@@ -142,17 +430,24 @@ func (s *Server) onInitialize(contents []byte) error {
 	s.state.WorkspaceFolders = request.Params.WorkspaceFolders
 	slog.Info("Workspace folders set", "workerspaceFolders", s.state.WorkspaceFolders)
 
-	workspaceDiagnostics := findDiagnosticsWorkspace(&s.state)
-	for uri, diagnostics := range workspaceDiagnostics {
-		s.pushDiagnostic(uri, diagnostics)
-	}
+	s.state.ClientCapabilities = request.Params.Capabilities
+	s.state.PositionEncoding = lsp.NegotiatePositionEncoding(
+		s.state.ClientCapabilities.PositionEncodings(),
+	)
+	slog.Info("Position encoding negotiated", "positionEncoding", s.state.PositionEncoding)
 
 	capabilities := lsp.ServerCapabilities{
-		TextDocumentSync:                1,
+		PositionEncoding: s.state.PositionEncoding,
+		TextDocumentSync: lsp.TextDocumentSyncOptions{
+			OpenClose: true,
+			Change:    lsp.TextDocumentSyncIncremental,
+			Save:      &lsp.SaveOptions{IncludeText: false},
+		},
 		HoverProvider:                   true,
 		DefinitionProvider:              true,
-		DeclarationProvider:             false,
+		DeclarationProvider:             true,
 		ReferencesProvider:              true,
+		DocumentHighlightProvider:       true,
 		DocumentSymbolProvider:          true,
 		WorkspaceSymbolProvider:         true,
 		DocumentFormattingProvider:      true,
@@ -160,19 +455,29 @@ func (s *Server) onInitialize(contents []byte) error {
 		CodeActionProvider:              true,
 		ColorProvider:                   true,
 		InlayHintProvider:               true,
+		CallHierarchyProvider:           true,
 		RenameProvider: lsp.RenameOptions{
-			PrepareProvider: true,
+			PrepareProvider: s.state.ClientCapabilities.PrepareRenameSupport(),
 		},
 		CompletionProvider: lsp.CompletionOptions{
 			TriggerCharacters: []string{"$", "{"},
 			ResolveProvider:   true,
 		},
-		DiagnosticProvider: lsp.DiagnosticOptions{
-			Identifier:            &s.name,
-			InterFileDependencies: false,
-			WorkspaceDiagnostics:  false,
+		Workspace: &lsp.WorkspaceServerCapabilities{
+			WorkspaceFolders: lsp.WorkspaceFoldersServerCapabilities{
+				Supported:           true,
+				ChangeNotifications: true,
+			},
 		},
 	}
+	// Clients registering pull diagnostics dynamically pull them only once
+	// registered, which happens when initialized
+	if s.state.ClientCapabilities.DiagnosticDynamicRegistration() {
+		s.registeringDiagnostics = true
+	} else {
+		capabilities.DiagnosticProvider = s.diagnosticOptions()
+		s.pullDiagnostics = s.state.ClientCapabilities.DiagnosticPullSupport()
+	}
 	info := lsp.ServerInfo{
 		Name:    s.name,
 		Version: s.version,
@@ -184,10 +489,18 @@ func (s *Server) onInitialize(contents []byte) error {
 	return nil
 }
 
+func (s *Server) diagnosticOptions() *lsp.DiagnosticOptions {
+	return &lsp.DiagnosticOptions{
+		Identifier:            &s.name,
+		InterFileDependencies: true,
+		WorkspaceDiagnostics:  true,
+	}
+}
+
 func (s *Server) onShutdown(contents []byte) error {
 	var request lsp.ShutdownRequest
 	if err := json.Unmarshal(contents, &request); err != nil {
-		return errors.New("ERROR: Could not parse request")
+		return errInvalidParams
 	}
 
 	slog.Info("Received shutdown request")
@@ -205,6 +518,116 @@ func (s *Server) onShutdown(contents []byte) error {
 	return nil
 }
 
+// The client is ready to receive requests and progress, so the workspace
+// gets indexed and linted now instead of blocking the initialize response
+func (s *Server) onInitialized() {
+	if s.state.ClientCapabilities.DidChangeWatchedFilesDynamicRegistration() {
+		s.registerFileWatchers()
+	}
+	if s.registeringDiagnostics {
+		s.registerDiagnosticProvider()
+	}
+	// The workspace gets indexed and linted once the configuration is known
+	if s.state.ClientCapabilities.ConfigurationSupport() {
+		s.requestConfiguration()
+		return
+	}
+	s.startIndexing()
+	if s.pushesDiagnostics() {
+		s.startWorkspaceDiagnostics()
+	}
+}
+
+// Build the workspace index in the background, superseding a running build
+func (s *Server) startIndexing() {
+	if s.cancelIndexing != nil {
+		s.cancelIndexing()
+	}
+	ctx, cancel := context.WithCancel(s.ctx)
+	s.cancelIndexing = cancel
+
+	state := s.state.Snapshot()
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer cancel()
+		state.Index.build(ctx, &state, state.Config.IndexCacheDir)
+	}()
+}
+
+// Update the source graph and the index entry of the file with uri from its
+// open document, or else from the file on disk
+func (s *Server) indexFile(uri string) {
+	path, err := utils.UriToPath(uri)
+	if err != nil {
+		return
+	}
+
+	document, open := s.state.Documents[uri]
+	if open {
+		if fileAst, err := document.FallibleAst(); err == nil {
+			sources, dynamic := resolveSources(path, fileAst, &s.state)
+			s.state.Sources.setFromDocument(path, sources, dynamic)
+		}
+	} else {
+		s.state.Sources.invalidate(path)
+	}
+
+	if !s.state.inWorkspace(path) {
+		return
+	}
+	// Files that cannot be parsed keep their previous entry
+	previous := s.state.Index.entry(path)
+	if open {
+		if entry, ok := indexFile(path, document, &s.state); ok || previous == nil {
+			s.state.Index.update(path, entry)
+		}
+		return
+	}
+	if previous == nil && !s.state.isShFile(path) {
+		return
+	}
+	if entry, ok := indexFileOnDisk(path, previous, &s.state); ok {
+		s.state.Index.update(path, entry)
+	} else {
+		s.state.Index.remove(path)
+	}
+}
+
+// Watch all files, since scripts without extension can be sourced as well as
+// files with any extension
+func (s *Server) registerFileWatchers() {
+	params := lsp.RegistrationParams{
+		Registrations: []lsp.Registration{{
+			ID:     "bashd/didChangeWatchedFiles",
+			Method: "workspace/didChangeWatchedFiles",
+			RegisterOptions: lsp.DidChangeWatchedFilesRegistrationOptions{
+				Watchers: []lsp.FileSystemWatcher{{GlobPattern: "**/*"}},
+			},
+		}},
+	}
+	s.sendRequest("client/registerCapability", params, nil)
+}
+
+// Register the pull diagnostics provider. Diagnostics get pushed instead if
+// the client rejects the registration.
+func (s *Server) registerDiagnosticProvider() {
+	params := lsp.RegistrationParams{
+		Registrations: []lsp.Registration{{
+			ID:     "bashd/diagnostic",
+			Method: "textDocument/diagnostic",
+			RegisterOptions: lsp.DiagnosticRegistrationOptions{
+				DiagnosticOptions: *s.diagnosticOptions(),
+			},
+		}},
+	}
+	s.sendRequest("client/registerCapability", params, func(response lsp.ClientResponse) {
+		s.registeringDiagnostics = false
+		s.pullDiagnostics = response.Error == nil
+		s.diagnoseAll()
+	})
+}
+
 func (s *Server) onExit() {
 	slog.Info("Exiting")
 	if s.state.ShutdownRequested {
@@ -215,24 +638,45 @@ func (s *Server) onExit() {
 	}
 }
 
+func (s *Server) onWorkDoneProgressCancel(contents []byte) error {
+	var notification lsp.WorkDoneProgressCancelNotification
+	if err := json.Unmarshal(contents, &notification); err != nil {
+		return errInvalidParams
+	}
+	slog.Info("Cancelling work done progress", "token", notification.Params.Token)
+	s.workspaceDiagnostics.cancelToken(notification.Params.Token)
+	return nil
+}
+
+func (s *Server) onCancelRequest(contents []byte) error {
+	var request lsp.CancelRequestNotification
+	if err := json.Unmarshal(contents, &request); err != nil {
+		return errInvalidParams
+	}
+
+	s.requestsMu.Lock()
+	defer s.requestsMu.Unlock()
+	if cancel, ok := s.requests[request.Params.ID]; ok {
+		slog.Info("Cancelling request", "id", request.Params.ID)
+		cancel()
+	}
+	return nil
+}
+
 func (s *Server) onTextDocumentDidOpen(contents []byte) error {
 	var request lsp.DidOpenTextDocumentNotification
 	if err := json.Unmarshal(contents, &request); err != nil {
-		return errors.New("ERROR: Could not parse request")
+		return errInvalidParams
 	}
 
 	uri := request.Params.TextDocument.URI
 	slog.Info("Opened document", "URI", uri)
 	documentText := request.Params.TextDocument.Text
-	s.state.SetDocument(uri, documentText)
-
-	diagnostics := findDiagnostics(
-		documentText,
-		uri,
-		s.state.EnvVars,
-		s.state.Config.ShellCheckOptions,
-	)
-	s.pushDiagnostic(request.Params.TextDocument.URI, diagnostics)
+	version := request.Params.TextDocument.Version
+	s.state.SetDocument(uri, documentText, version)
+	s.workspaceDiagnostics.markOpen(uri)
+	s.indexFile(uri)
+	s.scheduleDiagnostics(uri, s.state.Documents[uri], 0)
 
 	return nil
 }
@@ -240,281 +684,529 @@ func (s *Server) onTextDocumentDidOpen(contents []byte) error {
 func (s *Server) onTextDocumentDidChange(contents []byte) error {
 	var request lsp.TextDocumentDidChangeNotification
 	if err := json.Unmarshal(contents, &request); err != nil {
-		return errors.New("ERROR: Could not parse request")
+		return errInvalidParams
 	}
 
 	uri := request.Params.TextDocument.URI
-	slog.Info("Changed document", "URI", uri)
+	version := request.Params.TextDocument.Version
+	slog.Info("Changed document", "URI", uri, "version", version)
 
-	for _, change := range request.Params.ContentChanges {
-		s.state.SetDocument(uri, change.Text)
+	s.state.ApplyChanges(uri, version, request.Params.ContentChanges)
+	s.indexFile(uri)
+	s.scheduleDiagnostics(
+		uri,
+		s.state.Documents[uri],
+		s.state.Config.DiagnosticDebounceTime,
+	)
+
+	return nil
+}
+
+func (s *Server) onTextDocumentDidClose(contents []byte) error {
+	var request lsp.DidCloseTextDocumentNotification
+	if err := json.Unmarshal(contents, &request); err != nil {
+		return errInvalidParams
 	}
-	documentText := s.state.Documents[uri].Text
 
-	s.mu.Lock()
-	if s.diagnosticTimer != nil {
-		s.diagnosticTimer.Stop()
-	}
-
-	debounceTime := s.state.Config.DiagnosticDebounceTime
-	s.diagnosticTimer = time.AfterFunc(debounceTime, func() {
-		diagnostics := findDiagnostics(
-			documentText,
-			uri,
-			s.state.EnvVars,
-			s.state.Config.ShellCheckOptions,
-		)
-		s.pushDiagnostic(request.Params.TextDocument.URI, diagnostics)
-	})
-	s.mu.Unlock()
+	uri := request.Params.TextDocument.URI
+	slog.Info("Closed document", "URI", uri)
+	s.state.RemoveDocument(uri)
+	s.indexFile(uri)
+	s.diagnostics.cancel(uri)
+	s.diagnosticResults.remove(uri)
+	if s.pushesDiagnostics() {
+		s.pushDiagnostic(uri, nil, []lsp.Diagnostic{})
+	}
 
 	return nil
 }
 
-type didChangeConfigurationSettings struct {
-	Severity   *string `json:"severity"`
-	Shellcheck *struct {
-		Include *[]string `json:"include"`
-		Exclude *[]string `json:"exclude"`
-		Enable  *[]string `json:"enable"`
-	} `json:"shellcheck"`
-	Format *struct {
-		BinaryNextLine *bool `json:"binary_next_line"` // Binary ops like && and | may start a line
-		CaseIndent     *bool `json:"case_indent"`      // Switch cases will be indented
-		SpaceRedirects *bool `json:"space_redirects"`  // Redirect operators will be followed by a space
-		FuncNextLine   *bool `json:"func_next_line"`   // Function opening braces are placed on a separate line
-	} `json:"format"`
+func (s *Server) onTextDocumentDidSave(contents []byte) error {
+	var request lsp.DidSaveTextDocumentNotification
+	if err := json.Unmarshal(contents, &request); err != nil {
+		return errInvalidParams
+	}
+
+	uri := request.Params.TextDocument.URI
+	slog.Info("Saved document", "URI", uri)
+
+	// Open documents sourcing the saved file might be affected by the changes
+	dependents := findDependentDocuments(&s.state, uri)
+	for dependentUri, document := range dependents {
+		s.scheduleDiagnostics(dependentUri, document, 0)
+	}
+	if len(dependents) > 0 {
+		s.refreshDiagnostics()
+	}
+
+	return nil
 }
 
 func (s *Server) onDidChangeConfiguration(contents []byte) error {
 	var request lsp.DidChangeConfigurationRequest
 	if err := json.Unmarshal(contents, &request); err != nil {
-		return errors.New("ERROR: Could not parse request")
+		return errInvalidParams
 	}
 
-	paramsSettings, ok := request.Params.Settings.(map[string]any)
-	if !ok {
-		return errors.New("ERROR: Unknown settings type")
-	}
+	// Clients supporting `workspace/configuration` may send no settings at all
+	// and get asked for them instead
+	paramsSettings, _ := request.Params.Settings.(map[string]any)
 	slog.Info("onDidChangeConfiguration", "params.settings", paramsSettings)
-
 	rawSettings, ok := paramsSettings["bashd"]
+	if ok {
+		settings, err := parseSettings(rawSettings)
+		if err != nil {
+			return err
+		}
+		slog.Info("onDidChangeConfiguration", "settings", settings)
+		s.state.Config = s.state.Config.withSettings(settings)
+	}
+
+	if s.state.ClientCapabilities.ConfigurationSupport() {
+		s.requestConfiguration()
+		return nil
+	}
 	if !ok {
 		return errors.New("ERROR: Settings did not contain key 'bashd'")
 	}
 
-	data, err := json.Marshal(rawSettings)
-	if err != nil {
-		return fmt.Errorf("ERROR: Could not marshal settings: %w", err)
-	}
+	s.rediagnose()
+	return nil
+}
 
-	var settings didChangeConfigurationSettings
-	if err := json.Unmarshal(data, &settings); err != nil {
-		return fmt.Errorf("ERROR: Could not unmarshal bashd settings: %w", err)
+func (s *Server) onDidChangeWorkspaceFolders(contents []byte) error {
+	var notification lsp.DidChangeWorkspaceFoldersNotification
+	if err := json.Unmarshal(contents, &notification); err != nil {
+		return errInvalidParams
 	}
 
-	slog.Info("onDidChangeConfiguration", "settings", settings)
-	if settings.Format != nil {
-		if settings.Format.BinaryNextLine != nil {
-			s.state.Config.FormatOptions.BinaryNextLine = *settings.Format.BinaryNextLine
+	added := notification.Params.Event.Added
+	removed := notification.Params.Event.Removed
+	slog.Info("Workspace folders changed", "added", added, "removed", removed)
+
+	s.state.WorkspaceFolders = slices.DeleteFunc(
+		s.state.WorkspaceFolders,
+		func(folder lsp.WorkspaceFolder) bool {
+			return slices.ContainsFunc(removed, func(r lsp.WorkspaceFolder) bool {
+				return r.URI == folder.URI
+			})
+		},
+	)
+	for _, folder := range removed {
+		delete(s.state.FolderConfigs, folder.URI)
+		if path, err := utils.UriToPath(folder.URI); err == nil {
+			s.state.Index.removeDir(path)
 		}
-		if settings.Format.CaseIndent != nil {
-			s.state.Config.FormatOptions.CaseIndent = *settings.Format.CaseIndent
+	}
+	s.state.WorkspaceFolders = append(s.state.WorkspaceFolders, added...)
+
+	// The workspace gets indexed again once the configuration of the added
+	// folders is known
+	if s.state.ClientCapabilities.ConfigurationSupport() {
+		s.requestConfiguration()
+	} else {
+		s.rediagnose()
+	}
+
+	// Files of removed folders are no longer linted, so their diagnostics
+	// get cleared, unless they are open
+	if s.pushesDiagnostics() {
+		for _, shFile := range s.state.ShFiles(removed) {
+			uri := utils.PathToURI(shFile)
+			if _, ok := s.state.Documents[uri]; !ok {
+				s.pushDiagnostic(uri, nil, []lsp.Diagnostic{})
+			}
 		}
-		if settings.Format.SpaceRedirects != nil {
-			s.state.Config.FormatOptions.SpaceRedirects = *settings.Format.SpaceRedirects
+	}
+
+	return nil
+}
+
+// Ask the client for the settings of the server and of each workspace folder
+// and diagnose everything again with the new configuration
+func (s *Server) requestConfiguration() {
+	folders := slices.Clone(s.state.WorkspaceFolders)
+	items := []lsp.ConfigurationItem{{Section: "bashd"}}
+	for _, folder := range folders {
+		items = append(items, lsp.ConfigurationItem{ScopeURI: &folder.URI, Section: "bashd"})
+	}
+
+	params := lsp.ConfigurationParams{Items: items}
+	s.sendRequest("workspace/configuration", params, func(response lsp.ClientResponse) {
+		var results []any
+		if response.Error == nil {
+			if err := json.Unmarshal(response.Result, &results); err != nil || len(results) != len(items) {
+				slog.Error("ERROR: Unexpected configuration", "result", string(response.Result))
+				results = nil
+			}
 		}
-		if settings.Format.FuncNextLine != nil {
-			s.state.Config.FormatOptions.FuncNextLine = *settings.Format.FuncNextLine
+
+		if len(results) > 0 {
+			if settings, err := parseSettings(results[0]); err == nil {
+				s.state.Config = s.state.Config.withSettings(settings)
+			}
+			for i, folder := range folders {
+				settings, err := parseSettings(results[i+1])
+				if err != nil {
+					slog.Error("ERROR", "folder", folder.URI, "err", err)
+					continue
+				}
+				s.state.FolderConfigs[folder.URI] = s.state.Config.withSettings(settings)
+			}
 		}
+		s.rediagnose()
+	})
+}
+
+// Parse, resolve sourced files, index and diagnose all documents and workspace
+// files again, e.g. after the configuration changed
+func (s *Server) rediagnose() {
+	// The configured dialect and source paths may have changed
+	s.state.ResetDocuments()
+	s.state.Sources.invalidateAll()
+	for uri := range s.state.Documents {
+		s.indexFile(uri)
 	}
-	if settings.Severity != nil {
-		s.state.Config.ShellCheckOptions.Severity = *settings.Severity
+	s.startIndexing()
+
+	s.diagnoseAll()
+}
+
+// Diagnose all documents and workspace files again
+func (s *Server) diagnoseAll() {
+	if s.pullDiagnostics {
+		s.refreshDiagnostics()
+		return
 	}
-	if settings.Shellcheck != nil {
-		if settings.Shellcheck.Include != nil {
-			s.state.Config.ShellCheckOptions.Include = *settings.Shellcheck.Include
-		}
-		if settings.Shellcheck.Exclude != nil {
-			s.state.Config.ShellCheckOptions.Exclude = *settings.Shellcheck.Exclude
+	// Diagnosed once the registration of pull diagnostics is answered
+	if s.registeringDiagnostics {
+		return
+	}
+
+	s.startWorkspaceDiagnostics()
+	for uri, document := range s.state.Documents {
+		s.scheduleDiagnostics(uri, document, 0)
+	}
+}
+
+func (s *Server) onDidChangeWatchedFiles(contents []byte) error {
+	var notification lsp.DidChangeWatchedFilesNotification
+	if err := json.Unmarshal(contents, &notification); err != nil {
+		return errInvalidParams
+	}
+
+	// Open documents sourcing a changed file directly or indirectly
+	dependents := make(map[string]Document)
+	for _, change := range notification.Params.Changes {
+		slog.Info("Changed watched file", "URI", change.URI, "type", change.Type)
+		path, err := utils.UriToPath(change.URI)
+		if err != nil {
+			continue
 		}
-		if settings.Shellcheck.Enable != nil {
-			s.state.Config.ShellCheckOptions.Enable = *settings.Shellcheck.Enable
+		s.state.Files.invalidate(path)
+		if change.Type == lsp.FileDeleted {
+			s.diagnosticResults.remove(change.URI)
 		}
+		s.indexFile(change.URI)
+		maps.Copy(dependents, findDependentDocuments(&s.state, change.URI))
 	}
 
-	workspaceDiagnostics := findDiagnosticsWorkspace(&s.state)
-	for uri, diagnostics := range workspaceDiagnostics {
-		s.pushDiagnostic(uri, diagnostics)
+	for uri, document := range dependents {
+		s.scheduleDiagnostics(uri, document, 0)
+	}
+	// Workspace diagnostics include the changed files themselves
+	if len(notification.Params.Changes) > 0 {
+		s.refreshDiagnostics()
 	}
 
 	return nil
 }
 
-func (s *Server) onTextDocumentHover(contents []byte) error {
+func (s *Server) onTextDocumentHover(ctx context.Context, state *State, contents []byte) error {
 	var request lsp.HoverRequest
 	if err := json.Unmarshal(contents, &request); err != nil {
-		return errors.New("ERROR: Could not parse request")
+		return errInvalidParams
 	}
-	response := handleHover(&request, &s.state)
-	if response != nil {
-		s.writeResponse(response)
+	response := handleHover(ctx, &request, state)
+	if ctx.Err() != nil {
+		return ctx.Err()
 	}
+	writeResult(s, request.ID, response)
 	return nil
 }
 
-func (s *Server) onTextDocumentDefinition(contents []byte) error {
+func (s *Server) onTextDocumentDefinition(ctx context.Context, state *State, contents []byte) error {
 	var request lsp.DefinitionRequest
 	if err := json.Unmarshal(contents, &request); err != nil {
-		return errors.New("ERROR: Could not parse request")
+		return errInvalidParams
 	}
-	response := handleDefinition(&request, &s.state)
-	if response != nil {
-		s.writeResponse(response)
+	response := handleDefinition(&request, state)
+	if ctx.Err() != nil {
+		return ctx.Err()
 	}
+	writeResult(s, request.ID, response)
 	return nil
 }
 
-func (s *Server) onTextDocumentReferences(contents []byte) error {
+func (s *Server) onTextDocumentDeclaration(ctx context.Context, state *State, contents []byte) error {
+	var request lsp.DeclarationRequest
+	if err := json.Unmarshal(contents, &request); err != nil {
+		return errInvalidParams
+	}
+	response := handleDeclaration(&request, state)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	writeResult(s, request.ID, response)
+	return nil
+}
+
+func (s *Server) onTextDocumentReferences(ctx context.Context, state *State, contents []byte) error {
 	var request lsp.ReferencesRequest
 	if err := json.Unmarshal(contents, &request); err != nil {
-		return errors.New("ERROR: Could not parse request")
+		return errInvalidParams
+	}
+	response := handleReferences(ctx, &request, state)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	writeResult(s, request.ID, response)
+	return nil
+}
+
+func (s *Server) onTextDocumentDocumentHighlight(ctx context.Context, state *State, contents []byte) error {
+	var request lsp.DocumentHighlightRequest
+	if err := json.Unmarshal(contents, &request); err != nil {
+		return errInvalidParams
 	}
-	response := handleReferences(&request, &s.state)
-	if response != nil {
-		s.writeResponse(response)
+	response := handleDocumentHighlight(&request, state)
+	if ctx.Err() != nil {
+		return ctx.Err()
 	}
+	writeResult(s, request.ID, response)
 	return nil
 }
 
-func (s *Server) onTextDocumentCompletion(contents []byte) error {
+func (s *Server) onTextDocumentPrepareCallHierarchy(ctx context.Context, state *State, contents []byte) error {
+	var request lsp.PrepareCallHierarchyRequest
+	if err := json.Unmarshal(contents, &request); err != nil {
+		return errInvalidParams
+	}
+	response := handlePrepareCallHierarchy(&request, state)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	writeResult(s, request.ID, response)
+	return nil
+}
+
+func (s *Server) onCallHierarchyIncomingCalls(ctx context.Context, state *State, contents []byte) error {
+	var request lsp.CallHierarchyIncomingCallsRequest
+	if err := json.Unmarshal(contents, &request); err != nil {
+		return errInvalidParams
+	}
+	response := handleIncomingCalls(ctx, &request, state)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	writeResult(s, request.ID, response)
+	return nil
+}
+
+func (s *Server) onCallHierarchyOutgoingCalls(ctx context.Context, state *State, contents []byte) error {
+	var request lsp.CallHierarchyOutgoingCallsRequest
+	if err := json.Unmarshal(contents, &request); err != nil {
+		return errInvalidParams
+	}
+	response := handleOutgoingCalls(ctx, &request, state)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	writeResult(s, request.ID, response)
+	return nil
+}
+
+func (s *Server) onTextDocumentCompletion(ctx context.Context, state *State, contents []byte) error {
 	var request lsp.CompletionRequest
 	if err := json.Unmarshal(contents, &request); err != nil {
-		return errors.New("ERROR: Could not parse request")
+		return errInvalidParams
 	}
-	response := handleCompletion(&request, &s.state)
-	if response != nil {
-		s.writeResponse(response)
+	response := handleCompletion(&request, state)
+	if ctx.Err() != nil {
+		return ctx.Err()
 	}
+	writeResult(s, request.ID, response)
 	return nil
 }
 
-func (s *Server) onCompletionItemResolve(contents []byte) error {
+func (s *Server) onCompletionItemResolve(ctx context.Context, state *State, contents []byte) error {
 	var request lsp.CompletionItemResolveRequest
 	if err := json.Unmarshal(contents, &request); err != nil {
-		return errors.New("ERROR: Could not parse request")
+		return errInvalidParams
 	}
-	response := handleCompletionItemResolve(&request)
-	if response != nil {
-		s.writeResponse(response)
+	response := handleCompletionItemResolve(ctx, &request, state)
+	if ctx.Err() != nil {
+		return ctx.Err()
 	}
+	writeResult(s, request.ID, response)
 	return nil
 }
 
-func (s *Server) onTextDocumentDocumentSymbol(contents []byte) error {
+func (s *Server) onTextDocumentDocumentSymbol(ctx context.Context, state *State, contents []byte) error {
 	var request lsp.DocumentSymbolsRequest
 	if err := json.Unmarshal(contents, &request); err != nil {
-		return errors.New("ERROR: Could not parse request")
+		return errInvalidParams
 	}
-	response := handleDocumentSymbol(&request, &s.state)
-	if response != nil {
-		s.writeResponse(response)
+	response := handleDocumentSymbol(&request, state)
+	if ctx.Err() != nil {
+		return ctx.Err()
 	}
+	writeResult(s, request.ID, response)
 	return nil
 }
 
-func (s *Server) onTextDocumentPerpareRename(contents []byte) error {
+func (s *Server) onTextDocumentPerpareRename(ctx context.Context, state *State, contents []byte) error {
 	var request lsp.PrepareRenameRequest
 	if err := json.Unmarshal(contents, &request); err != nil {
-		return errors.New("ERROR: Could not parse request")
+		return errInvalidParams
 	}
-	response := handlePrepareRename(&request, &s.state)
-	if response != nil {
-		s.writeResponse(response)
+	response, err := handlePrepareRename(&request, state)
+	if ctx.Err() != nil {
+		return ctx.Err()
 	}
+	if err != nil {
+		return err
+	}
+	writeResult(s, request.ID, response)
 	return nil
 }
 
-func (s *Server) onTextDocumentRename(contents []byte) error {
+func (s *Server) onTextDocumentRename(ctx context.Context, state *State, contents []byte) error {
 	var request lsp.RenameRequest
 	if err := json.Unmarshal(contents, &request); err != nil {
-		return errors.New("ERROR: Could not parse request")
+		return errInvalidParams
 	}
-	response := handleRename(&request, &s.state)
-	if response != nil {
-		s.writeResponse(response)
+	response, err := handleRename(ctx, &request, state)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err != nil {
+		return err
 	}
+	writeResult(s, request.ID, response)
 	return nil
 }
 
-func (s *Server) onWorkspaceSymbol(contents []byte) error {
+func (s *Server) onWorkspaceSymbol(ctx context.Context, state *State, contents []byte) error {
 	var request lsp.WorkspaceSymbolRequest
 	if err := json.Unmarshal(contents, &request); err != nil {
-		return errors.New("ERROR: Could not parse request")
+		return errInvalidParams
 	}
-	response := handleWorkspaceSymbol(&request, &s.state)
-	if response != nil {
-		s.writeResponse(response)
+	response := handleWorkspaceSymbol(ctx, &request, state)
+	if ctx.Err() != nil {
+		return ctx.Err()
 	}
+	writeResult(s, request.ID, response)
 	return nil
 }
 
-func (s *Server) onTextDocumentFormatting(contents []byte) error {
+func (s *Server) onTextDocumentFormatting(ctx context.Context, state *State, contents []byte) error {
 	var request lsp.FormattingRequest
 	if err := json.Unmarshal(contents, &request); err != nil {
-		return errors.New("ERROR: Could not parse request")
+		return errInvalidParams
 	}
-	response := handleFormatting(&request, &s.state)
-	if response != nil {
-		s.writeResponse(response)
+	response := handleFormatting(&request, state)
+	if ctx.Err() != nil {
+		return ctx.Err()
 	}
+	writeResult(s, request.ID, response)
 	return nil
 }
 
-func (s *Server) onTextDocumentRangeFormatting(contents []byte) error {
+func (s *Server) onTextDocumentRangeFormatting(ctx context.Context, state *State, contents []byte) error {
 	var request lsp.RangeFormattingRequest
 	if err := json.Unmarshal(contents, &request); err != nil {
-		return errors.New("ERROR: Could not parse request")
+		return errInvalidParams
 	}
-	response := handleRangeFormatting(&request, &s.state)
-	if response != nil {
-		s.writeResponse(response)
+	response := handleRangeFormatting(&request, state)
+	if ctx.Err() != nil {
+		return ctx.Err()
 	}
+	writeResult(s, request.ID, response)
 	return nil
 }
 
-func (s *Server) onTextDocumentCodeAction(contents []byte) error {
+func (s *Server) onTextDocumentCodeAction(ctx context.Context, state *State, contents []byte) error {
 	var request lsp.CodeActionRequest
 	if err := json.Unmarshal(contents, &request); err != nil {
-		return errors.New("ERROR: Could not parse request")
+		return errInvalidParams
 	}
-	response := handleCodeAction(&request, &s.state)
-	if response != nil {
-		s.writeResponse(response)
+	response := handleCodeAction(&request, state)
+	if ctx.Err() != nil {
+		return ctx.Err()
 	}
+	writeResult(s, request.ID, response)
 	return nil
 }
 
-func (s *Server) onTextDocumentDocumentColor(contents []byte) error {
+func (s *Server) onTextDocumentDocumentColor(ctx context.Context, state *State, contents []byte) error {
 	var request lsp.DocumentColorRequest
 	if err := json.Unmarshal(contents, &request); err != nil {
-		return errors.New("ERROR: Could not parse request")
+		return errInvalidParams
 	}
-	response := handleDocumentColor(&request, &s.state)
-	if response != nil {
-		s.writeResponse(response)
+	response := handleDocumentColor(&request, state)
+	if ctx.Err() != nil {
+		return ctx.Err()
 	}
+	writeResult(s, request.ID, response)
 	return nil
 }
 
-func (s *Server) onTextDocumentInlayHint(contents []byte) error {
+func (s *Server) onTextDocumentInlayHint(ctx context.Context, state *State, contents []byte) error {
 	var request lsp.InlayHintRequest
 	if err := json.Unmarshal(contents, &request); err != nil {
-		return errors.New("ERROR: Could not parse request")
+		return errInvalidParams
 	}
-	response := handleInlayHint(&request, &s.state)
-	if response != nil {
-		s.writeResponse(response)
+	response := handleInlayHint(&request, state)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	writeResult(s, request.ID, response)
+	return nil
+}
+
+func (s *Server) onTextDocumentDiagnostic(ctx context.Context, state *State, contents []byte) error {
+	var request lsp.DocumentDiagnosticRequest
+	if err := json.Unmarshal(contents, &request); err != nil {
+		return errInvalidParams
+	}
+	response := handleDocumentDiagnostic(ctx, &request, state, s.diagnosticResults)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	writeResult(s, request.ID, response)
+	return nil
+}
+
+func (s *Server) onWorkspaceDiagnostic(ctx context.Context, state *State, contents []byte) error {
+	var request lsp.WorkspaceDiagnosticRequest
+	if err := json.Unmarshal(contents, &request); err != nil {
+		return errInvalidParams
+	}
+	response := handleWorkspaceDiagnostic(ctx, &request, state, s.diagnosticResults)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	writeResult(s, request.ID, response)
+	return nil
+}
+
+func (s *Server) onSourceGraph(ctx context.Context, state *State, contents []byte) error {
+	var request lsp.SourceGraphRequest
+	if err := json.Unmarshal(contents, &request); err != nil {
+		return errInvalidParams
 	}
+	response := handleSourceGraph(&request, state)
+	writeResult(s, request.ID, response)
 	return nil
 }
@@ -2,15 +2,20 @@ package server
 
 import (
 	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/matkrin/bashd/internal/lsp"
+	"github.com/matkrin/bashd/internal/utils"
 )
 
 func mockState1(documentText string) *State {
 	state := NewState(Config{ExcludeDirs: nil})
-	state.SetDocument("file://workspace/test.sh", documentText)
+	state.SetDocument("file://workspace/test.sh", documentText, 0)
 	state.WorkspaceFolders = []lsp.WorkspaceFolder{
 		{URI: "file://workspace", Name: "workspace"},
 	}
@@ -79,7 +84,7 @@ echo "hello world"
 				}
 
 			case "shutdown":
-				expectedIn := []string{"Content-Length: 24", `"jsonrpc"`}
+				expectedIn := []string{"Content-Length: 38", `"jsonrpc"`, `"result":null`}
 				response := writer.String()
 				for _, exp := range expectedIn {
 					if !strings.Contains(response, exp) {
@@ -90,3 +95,286 @@ echo "hello world"
 		})
 	}
 }
+
+func Test_onTextDocumentDidClose(t *testing.T) {
+	var buf bytes.Buffer
+	state := mockState1("echo\n")
+	server := NewServer("", "", *state, &buf)
+
+	uri := "file://workspace/test.sh"
+	server.scheduleDiagnostics(uri, state.Documents[uri], time.Hour)
+	server.diagnosticResults.set(uri, "result", []lsp.Diagnostic{})
+	server.HandleMessage(
+		"textDocument/didClose",
+		[]byte(`{"method": "textDocument/didClose", "params": {"textDocument": {"uri": "file://workspace/test.sh"}}}`),
+	)
+	server.Stop()
+
+	response := buf.String()
+	if !strings.Contains(response, `"diagnostics":[]`) {
+		t.Errorf("expected diagnostics to be cleared on close, got '%s'", response)
+	}
+	if _, ok := server.diagnostics.runs[uri]; ok {
+		t.Errorf("expected scheduled diagnostics to be cancelled on close")
+	}
+	if _, ok := server.diagnosticResults.results[uri]; ok {
+		t.Errorf("expected pulled diagnostics to be removed on close")
+	}
+}
+
+func Test_concurrentRequest(t *testing.T) {
+	var buf bytes.Buffer
+	state := mockState1("a=1\necho \"$a\"\n")
+	server := NewServer("", "", *state, &buf)
+
+	server.HandleMessage(
+		"textDocument/definition",
+		[]byte(`{"id": 7, "method": "textDocument/definition", "params": {"textDocument": {"uri": "file://workspace/test.sh"}, "position": {"line": 1, "character": 7}}}`),
+	)
+	// Changes after the request was received must not affect it
+	server.HandleMessage(
+		"textDocument/didChange",
+		[]byte(`{"method": "textDocument/didChange", "params": {"textDocument": {"uri": "file://workspace/test.sh", "version": 2}, "contentChanges": [{"text": ""}]}}`),
+	)
+	server.Stop()
+
+	response := buf.String()
+	expected := `"id":7,"result":{"uri":"file://workspace/test.sh","range":{"start":{"line":0,"character":0},"end":{"line":0,"character":1}}}`
+	if !strings.Contains(response, expected) {
+		t.Errorf("expected '%s' in '%s'", expected, response)
+	}
+}
+
+func Test_onCancelRequest(t *testing.T) {
+	var buf bytes.Buffer
+	state := mockState1("echo\n")
+	server := NewServer("", "", *state, &buf)
+
+	ctx := server.startRequest(lsp.NewNumberID(3))
+	otherCtx := server.startRequest(lsp.NewStringID("3"))
+	server.HandleMessage("$/cancelRequest", []byte(`{"method": "$/cancelRequest", "params": {"id": 3}}`))
+	server.Stop()
+
+	if ctx.Err() == nil {
+		t.Errorf("expected request 3 to be cancelled")
+	}
+	if otherCtx.Err() != nil {
+		t.Errorf("expected request \"3\" not to be cancelled")
+	}
+}
+
+func Test_errorResponses(t *testing.T) {
+	tests := []struct {
+		name     string
+		method   string
+		contents string
+		expected string
+	}{
+		{
+			"Invalid JSON",
+			"",
+			`{"id": 1, "method": `,
+			`{"jsonrpc":"2.0","id":null,"error":{"code":-32700`,
+		},
+		{
+			"Invalid ID",
+			"shutdown",
+			`{"id": {}, "method": "shutdown"}`,
+			`{"jsonrpc":"2.0","id":null,"error":{"code":-32600`,
+		},
+		{
+			"Unknown request",
+			"textDocument/unknown",
+			`{"id": 1, "method": "textDocument/unknown"}`,
+			`{"jsonrpc":"2.0","id":1,"error":{"code":-32601`,
+		},
+		{
+			"Invalid params",
+			"textDocument/hover",
+			`{"id": "abc", "method": "textDocument/hover", "params": {"position": "0:0"}}`,
+			`{"jsonrpc":"2.0","id":"abc","error":{"code":-32602`,
+		},
+		{
+			"String ID",
+			"shutdown",
+			`{"id": "abc", "method": "shutdown"}`,
+			`{"jsonrpc":"2.0","id":"abc","result":null}`,
+		},
+		{
+			"Request without result",
+			"textDocument/definition",
+			`{"id": 2, "method": "textDocument/definition", "params": {"textDocument": {"uri": "file://workspace/test.sh"}, "position": {"line": 0, "character": 0}}}`,
+			`{"jsonrpc":"2.0","id":2,"result":null}`,
+		},
+		{
+			"Protocol notification",
+			"$/setTrace",
+			`{"method": "$/setTrace", "params": {"value": "off"}}`,
+			``,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			state := mockState1("echo\n")
+			server := NewServer("", "", *state, &buf)
+			server.HandleMessage(tt.method, []byte(tt.contents))
+			server.Stop()
+
+			response := buf.String()
+			if tt.expected == "" && response != "" {
+				t.Errorf("expected no response, got '%s'", response)
+			}
+			if !strings.Contains(response, tt.expected) {
+				t.Errorf("expected '%s' in '%s'", tt.expected, response)
+			}
+		})
+	}
+}
+
+func Test_onDidChangeWatchedFiles(t *testing.T) {
+	dir := t.TempDir()
+	libPath := filepath.Join(dir, "lib.sh")
+	if err := os.WriteFile(libPath, []byte("echo\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(libPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	state := NewState(Config{})
+	if _, err := state.Files.text(libPath); err != nil {
+		t.Fatal(err)
+	}
+	// Same size and modification time, so only the notification tells the
+	// cache that the file changed
+	if err := os.WriteFile(libPath, []byte("exit\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(libPath, info.ModTime(), info.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+	server := NewServer("", "", state, &buf)
+	server.HandleMessage(
+		"initialize",
+		[]byte(`{"id": 1, "method": "initialize", "params": {"capabilities": {"textDocument": {"diagnostic": {}}, "workspace": {"didChangeWatchedFiles": {"dynamicRegistration": true}, "diagnostics": {"refreshSupport": true}}}}}`),
+	)
+	server.HandleMessage("initialized", []byte(`{"method": "initialized", "params": {}}`))
+	server.HandleMessage(
+		"workspace/didChangeWatchedFiles",
+		[]byte(fmt.Sprintf(`{"method": "workspace/didChangeWatchedFiles", "params": {"changes": [{"uri": %q, "type": 2}]}}`, utils.PathToURI(libPath))),
+	)
+	server.Stop()
+
+	response := buf.String()
+	if !strings.Contains(response, `"method":"client/registerCapability","params":{"registrations":[{"id":"bashd/didChangeWatchedFiles","method":"workspace/didChangeWatchedFiles"`) {
+		t.Errorf("expected registration of file watchers in '%s'", response)
+	}
+	if !strings.Contains(response, `"method":"workspace/diagnostic/refresh"`) {
+		t.Errorf("expected refresh request in '%s'", response)
+	}
+	if text, _ := state.Files.text(libPath); text != "exit\n" {
+		t.Errorf("expected cached file %s to be invalidated, got %q", libPath, text)
+	}
+}
+
+func Test_workspaceFolderConfiguration(t *testing.T) {
+	var buf bytes.Buffer
+	server := NewServer("", "", NewState(Config{}), &buf)
+	server.HandleMessage(
+		"initialize",
+		[]byte(`{"id": 1, "method": "initialize", "params": {"workspaceFolders": [{"uri": "file:///a", "name": "a"}, {"uri": "file:///b", "name": "b"}], "capabilities": {"textDocument": {"diagnostic": {}}, "workspace": {"configuration": true, "workspaceFolders": true}}}}`),
+	)
+	server.HandleMessage("initialized", []byte(`{"method": "initialized", "params": {}}`))
+	server.HandleMessage("", []byte(`{"jsonrpc": "2.0", "id": 1, "result": [{"severity": "error"}, {"severity": "warning"}, {"format": {"case_indent": true}}]}`))
+	server.HandleMessage(
+		"workspace/didChangeWorkspaceFolders",
+		[]byte(`{"method": "workspace/didChangeWorkspaceFolders", "params": {"event": {"added": [{"uri": "file:///c", "name": "c"}], "removed": [{"uri": "file:///a", "name": "a"}]}}}`),
+	)
+	server.Stop()
+
+	response := buf.String()
+	if !strings.Contains(response, `"id":1,"method":"workspace/configuration","params":{"items":[{"section":"bashd"},{"scopeUri":"file:///a","section":"bashd"},{"scopeUri":"file:///b","section":"bashd"}]}`) {
+		t.Errorf("expected configuration request in '%s'", response)
+	}
+	if !strings.Contains(response, `"id":2,"method":"workspace/configuration","params":{"items":[{"section":"bashd"},{"scopeUri":"file:///b","section":"bashd"},{"scopeUri":"file:///c","section":"bashd"}]}`) {
+		t.Errorf("expected configuration request for changed folders in '%s'", response)
+	}
+
+	state := &server.state
+	if got := state.ConfigFor("file:///b/sub/test.sh"); got.ShellCheckOptions.Severity != "error" || !got.FormatOptions.CaseIndent {
+		t.Errorf("ConfigFor() = %+v, want severity error and case indent", got)
+	}
+	if got := state.ConfigFor("file:///other/test.sh"); got.ShellCheckOptions.Severity != "error" || got.FormatOptions.CaseIndent {
+		t.Errorf("ConfigFor() = %+v, want configuration of the server", got)
+	}
+	if _, ok := state.FolderConfigs["file:///a"]; ok {
+		t.Errorf("expected configuration of removed folder to be dropped")
+	}
+	if len(state.WorkspaceFolders) != 2 || state.WorkspaceFolders[1].URI != "file:///c" {
+		t.Errorf("WorkspaceFolders = %v, want b and c", state.WorkspaceFolders)
+	}
+}
+
+func Test_sourcePathsConfiguration(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"main.sh":  "source lib.sh\ngreet\n",
+		"a/lib.sh": "greet() {\n\techo a\n}\n",
+		"b/lib.sh": "\ngreet() {\n\techo b\n}\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mainURI := utils.PathToURI(filepath.Join(dir, "main.sh"))
+
+	var buf bytes.Buffer
+	server := NewServer("", "", NewState(Config{}), &buf)
+	defer server.Stop()
+	server.HandleMessage(
+		"initialize",
+		[]byte(fmt.Sprintf(`{"id": 1, "method": "initialize", "params": {"workspaceFolders": [{"uri": %q, "name": "workspace"}], "capabilities": {"textDocument": {"diagnostic": {}}}}}`, utils.PathToURI(dir))),
+	)
+	server.HandleMessage("initialized", []byte(`{"method": "initialized", "params": {}}`))
+	server.HandleMessage(
+		"textDocument/didOpen",
+		[]byte(fmt.Sprintf(`{"method": "textDocument/didOpen", "params": {"textDocument": {"uri": %q, "version": 1, "text": %q}}}`, mainURI, files["main.sh"])),
+	)
+
+	definitionWith := func(sourcePath string) string {
+		t.Helper()
+		server.HandleMessage(
+			"workspace/didChangeConfiguration",
+			[]byte(fmt.Sprintf(`{"method": "workspace/didChangeConfiguration", "params": {"settings": {"bashd": {"shellcheck": {"sourcePaths": [%q]}}}}}`, sourcePath)),
+		)
+		// The next message is received after the configuration was handled
+		server.HandleMessage("$/sync", []byte(`{"method": "$/sync"}`))
+
+		state := server.state.Snapshot()
+		request := &lsp.DefinitionRequest{}
+		request.Params.TextDocument.URI = mainURI
+		request.Params.Position = lsp.Position{Line: 1, Character: 0}
+		response := handleDefinition(request, &state)
+		if response == nil || len(response.Result) != 1 {
+			t.Fatalf("expected one definition, got %v", response)
+		}
+		return response.Result[0].URI
+	}
+
+	if got, want := definitionWith("SCRIPTDIR/a"), utils.PathToURI(filepath.Join(dir, "a/lib.sh")); got != want {
+		t.Errorf("expected definition in %s, got %s", want, got)
+	}
+	if got, want := definitionWith("SCRIPTDIR/b"), utils.PathToURI(filepath.Join(dir, "b/lib.sh")); got != want {
+		t.Errorf("expected definition in %s after changing source paths, got %s", want, got)
+	}
+}
@@ -0,0 +1,301 @@
+package server
+
+import (
+	"maps"
+	"os"
+	"slices"
+	"sync"
+
+	"github.com/matkrin/bashd/internal/ast"
+	"github.com/matkrin/bashd/internal/lsp"
+	"github.com/matkrin/bashd/internal/utils"
+)
+
+// Which files source which files, by resolved paths. Edges of open documents
+// come from their current text, edges of other files from disk. Files on disk
+// are loaded on first use or, for workspace files, when the index is built.
+type sourceGraph struct {
+	mu    sync.RWMutex
+	files map[string]sourceNode
+	// Files sourcing a file directly
+	sourcedBy map[string]map[string]bool
+}
+
+type sourceNode struct {
+	// Resolved paths of the files sourced directly, in source order
+	sources []string
+	// Paths as written in the file of the files sourced directly, which cannot
+	// be evaluated statically
+	dynamic []string
+	// Edges from the text of an open document, which are not overwritten by
+	// edges from disk
+	fromDocument bool
+}
+
+func newSourceGraph() *sourceGraph {
+	return &sourceGraph{
+		files:     make(map[string]sourceNode),
+		sourcedBy: make(map[string]map[string]bool),
+	}
+}
+
+// Resolved paths of the files sourced by the file at path, with the source
+// paths configured for the file, and the paths as written of the sourced files
+// that cannot be resolved statically
+func resolveSources(path string, fileAst *ast.Ast, state *State) ([]string, []string) {
+	sources := []string{}
+	searchPaths := state.ConfigFor(utils.PathToURI(path)).ShellCheckOptions.SourcePaths
+	for _, sourceStatement := range fileAst.FindSourceStatments(state.EnvVars, path, searchPaths) {
+		sourcedFile := sourceStatement.ResolvedFile
+		if !slices.Contains(sources, sourcedFile) {
+			sources = append(sources, sourcedFile)
+		}
+	}
+	dynamic := []string{}
+	for _, sourceStatement := range fileAst.FindDynamicSourceStatements(state.EnvVars, path, searchPaths) {
+		if !slices.Contains(dynamic, sourceStatement.SourcedFile) {
+			dynamic = append(dynamic, sourceStatement.SourcedFile)
+		}
+	}
+	return sources, dynamic
+}
+
+// Set the edges of an open document
+func (g *sourceGraph) setFromDocument(path string, sources, dynamic []string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.setLocked(path, sourceNode{sources: sources, dynamic: dynamic, fromDocument: true})
+}
+
+// Set the edges of a file on disk, unless the file is open
+func (g *sourceGraph) setFromDisk(path string, sources, dynamic []string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.files[path].fromDocument {
+		return
+	}
+	g.setLocked(path, sourceNode{sources: sources, dynamic: dynamic})
+}
+
+// Forget the edges of the file at path, they are loaded from disk again on
+// next use
+func (g *sourceGraph) invalidate(path string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.removeEdgesLocked(path)
+	delete(g.files, path)
+}
+
+// Forget the edges of all files, e.g. after the configured source paths
+// changed
+func (g *sourceGraph) invalidateAll() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	clear(g.files)
+	clear(g.sourcedBy)
+}
+
+func (g *sourceGraph) setLocked(path string, node sourceNode) {
+	g.removeEdgesLocked(path)
+	g.files[path] = node
+	for _, source := range node.sources {
+		if g.sourcedBy[source] == nil {
+			g.sourcedBy[source] = make(map[string]bool)
+		}
+		g.sourcedBy[source][path] = true
+	}
+}
+
+func (g *sourceGraph) removeEdgesLocked(path string) {
+	for _, source := range g.files[path].sources {
+		delete(g.sourcedBy[source], path)
+		if len(g.sourcedBy[source]) == 0 {
+			delete(g.sourcedBy, source)
+		}
+	}
+}
+
+// Files sourced by the file at path directly, loaded from disk if unknown
+func (g *sourceGraph) sources(path string, state *State) []string {
+	return g.node(path, state).sources
+}
+
+// Edges of the file at path, loaded from disk if unknown
+func (g *sourceGraph) node(path string, state *State) sourceNode {
+	g.mu.RLock()
+	node, ok := g.files[path]
+	g.mu.RUnlock()
+	if ok {
+		return node
+	}
+
+	sources, dynamic := []string{}, []string{}
+	if fileAst, _, err := state.FileAst(path); err == nil {
+		sources, dynamic = resolveSources(path, fileAst, state)
+	}
+	g.setFromDisk(path, sources, dynamic)
+	return sourceNode{sources: sources, dynamic: dynamic}
+}
+
+// Files sourced by the file at path directly or indirectly, in source order
+func (g *sourceGraph) sourcedFiles(path string, state *State) []string {
+	var sourcedFiles []string
+	visited := map[string]bool{path: true}
+	var visit func(path string)
+	visit = func(path string) {
+		for _, source := range g.sources(path, state) {
+			if visited[source] {
+				continue
+			}
+			visited[source] = true
+			sourcedFiles = append(sourcedFiles, source)
+			visit(source)
+		}
+	}
+	visit(path)
+	return sourcedFiles
+}
+
+// Known files sourcing the file at path directly or indirectly, sorted
+func (g *sourceGraph) dependents(path string) []string {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	visited := map[string]bool{path: true}
+	queue := []string{path}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		for dependent := range g.sourcedBy[current] {
+			if !visited[dependent] {
+				visited[dependent] = true
+				queue = append(queue, dependent)
+			}
+		}
+	}
+	delete(visited, path)
+	return slices.Sorted(maps.Keys(visited))
+}
+
+// Known edges, by sourcing file
+func (g *sourceGraph) edges() map[string]sourceNode {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	return maps.Clone(g.files)
+}
+
+// Files sourced by the document or file with uri directly or indirectly, in
+// source order
+func (s *State) SourcedFiles(uri string) []string {
+	path, err := utils.UriToPath(uri)
+	if err != nil {
+		return nil
+	}
+	return s.Sources.sourcedFiles(path, s)
+}
+
+// Workspace files sourcing the document or file with uri directly or
+// indirectly. Until the index is built, the workspace files are loaded first.
+func (s *State) DependentFiles(uri string) []string {
+	path, err := utils.UriToPath(uri)
+	if err != nil {
+		return nil
+	}
+	if !s.Index.ready() {
+		for _, shFile := range s.WorkspaceShFiles() {
+			s.Sources.sources(shFile, s)
+		}
+	}
+	return s.Sources.dependents(path)
+}
+
+// Files providing the context of the document with uri at runtime: the files
+// sourcing it and the other files these source, sorted
+func (s *State) ContextFiles(uri string) []string {
+	path, err := utils.UriToPath(uri)
+	if err != nil {
+		return nil
+	}
+
+	excluded := append([]string{path}, s.SourcedFiles(uri)...)
+	context := make(map[string]bool)
+	for _, dependent := range s.DependentFiles(uri) {
+		context[dependent] = true
+		for _, sourcedFile := range s.Sources.sourcedFiles(dependent, s) {
+			context[sourcedFile] = true
+		}
+	}
+	for _, file := range excluded {
+		delete(context, file)
+	}
+	return slices.Sorted(maps.Keys(context))
+}
+
+// Workspace files sourcing the document with uri that might reference the
+// identifier under cursor
+func (s *State) dependentFilesReferencing(uri string, fileAst *ast.Ast, cursor ast.Cursor) []string {
+	referencing := s.workspaceFilesReferencing(fileAst, cursor)
+	var files []string
+	for _, dependent := range s.DependentFiles(uri) {
+		if slices.Contains(referencing, dependent) {
+			files = append(files, dependent)
+		}
+	}
+	return files
+}
+
+// Source graph of the files connected to the document with uri, or of all
+// known files if uri is empty
+func (s *State) sourceGraph(uri string) lsp.SourceGraph {
+	edges := s.Sources.edges()
+	if uri != "" {
+		path, err := utils.UriToPath(uri)
+		if err != nil {
+			return lsp.SourceGraph{Nodes: []lsp.SourceGraphNode{}, Edges: []lsp.SourceGraphEdge{}}
+		}
+		connected := append([]string{path}, s.SourcedFiles(uri)...)
+		connected = append(connected, s.DependentFiles(uri)...)
+		edges = make(map[string]sourceNode)
+		for _, file := range connected {
+			edges[file] = s.Sources.node(file, s)
+		}
+	}
+
+	graph := lsp.SourceGraph{Nodes: []lsp.SourceGraphNode{}, Edges: []lsp.SourceGraphEdge{}}
+	nodes := make(map[string]bool)
+	for _, path := range slices.Sorted(maps.Keys(edges)) {
+		nodes[path] = true
+		for _, source := range edges[path].sources {
+			nodes[source] = true
+			_, err := os.Stat(source)
+			graph.Edges = append(graph.Edges, lsp.SourceGraphEdge{
+				From:     utils.PathToURI(path),
+				To:       utils.PathToURI(source),
+				Resolved: err == nil,
+			})
+		}
+		for _, text := range edges[path].dynamic {
+			graph.Edges = append(graph.Edges, lsp.SourceGraphEdge{
+				From: utils.PathToURI(path),
+				Text: text,
+			})
+		}
+	}
+	for _, path := range slices.Sorted(maps.Keys(nodes)) {
+		uri := utils.PathToURI(path)
+		_, open := s.Documents[uri]
+		graph.Nodes = append(graph.Nodes, lsp.SourceGraphNode{URI: uri, Open: open})
+	}
+	return graph
+}
+
+// Handler for the custom request `bashd/sourceGraph`
+func handleSourceGraph(request *lsp.SourceGraphRequest, state *State) *lsp.SourceGraphResponse {
+	uri := ""
+	if request.Params.TextDocument != nil {
+		uri = request.Params.TextDocument.URI
+	}
+	response := lsp.NewSourceGraphResponse(request.ID, state.sourceGraph(uri))
+	return &response
+}
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/matkrin/bashd/internal/lsp"
+	"github.com/matkrin/bashd/internal/utils"
+)
+
+func Test_sourceGraph(t *testing.T) {
+	dir := t.TempDir()
+	mainPath := filepath.Join(dir, "main.sh")
+	libPath := filepath.Join(dir, "lib.sh")
+	utilPath := filepath.Join(dir, "util.sh")
+	missingPath := filepath.Join(dir, "missing.sh")
+	writeFile := func(path, content string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile(mainPath, "source ./lib.sh\nsource ./missing.sh\nsource \"$(find_lib)\"\n")
+	writeFile(libPath, "source ./util.sh\nsource ./main.sh\n")
+	writeFile(utilPath, "greet() {\n\techo hello\n}\n")
+
+	state := NewState(Config{})
+	state.WorkspaceFolders = []lsp.WorkspaceFolder{{URI: utils.PathToURI(dir), Name: "workspace"}}
+
+	// Sourced files are followed transitively in source order, cycles stop
+	want := []string{libPath, utilPath, missingPath}
+	if got := state.SourcedFiles(utils.PathToURI(mainPath)); !slices.Equal(got, want) {
+		t.Errorf("SourcedFiles() = %v, want %v", got, want)
+	}
+	want = []string{libPath, mainPath}
+	if got := state.DependentFiles(utils.PathToURI(utilPath)); !slices.Equal(got, want) {
+		t.Errorf("DependentFiles() = %v, want %v", got, want)
+	}
+
+	// The path of the last source statement of main.sh cannot be evaluated
+	graph := state.sourceGraph(utils.PathToURI(mainPath))
+	for _, edge := range graph.Edges {
+		wantResolved := edge.To != utils.PathToURI(missingPath) && edge.To != ""
+		if edge.Resolved != wantResolved {
+			t.Errorf("edge %s -> %s resolved = %v, want %v", edge.From, edge.To, edge.Resolved, wantResolved)
+		}
+	}
+	if len(graph.Edges) != 5 {
+		t.Errorf("len(Edges) = %d, want 5", len(graph.Edges))
+	}
+	dynamicEdge := lsp.SourceGraphEdge{From: utils.PathToURI(mainPath), Text: `"$(find_lib)"`}
+	if !slices.Contains(graph.Edges, dynamicEdge) {
+		t.Errorf("expected edge %v, got %v", dynamicEdge, graph.Edges)
+	}
+
+	// Edges of an open document are not overwritten from disk
+	state.Sources.setFromDocument(libPath, []string{}, []string{})
+	state.Sources.setFromDisk(libPath, []string{utilPath}, []string{})
+	if got := state.DependentFiles(utils.PathToURI(utilPath)); len(got) != 0 {
+		t.Errorf("DependentFiles() = %v, want none", got)
+	}
+	want = []string{libPath, missingPath}
+	if got := state.SourcedFiles(utils.PathToURI(mainPath)); !slices.Equal(got, want) {
+		t.Errorf("SourcedFiles() = %v, want %v", got, want)
+	}
+}
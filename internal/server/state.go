@@ -4,6 +4,7 @@ import (
 	"io"
 	"io/fs"
 	"log/slog"
+	"maps"
 	"os"
 	"path/filepath"
 	"slices"
@@ -11,6 +12,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/matkrin/bashd/internal/ast"
 	"github.com/matkrin/bashd/internal/lsp"
 	"github.com/matkrin/bashd/internal/shellcheck"
 	"github.com/matkrin/bashd/internal/utils"
@@ -18,15 +20,122 @@ import (
 )
 
 type Document struct {
-	Text         string
-	SourcedFiles []Document
+	Text    string
+	Version int
+	// Syntax trees of the text, shared by all copies of the document
+	syntax *documentSyntax
+}
+
+// Parsed on first use, once per document version
+type documentSyntax struct {
+	uri          string
+	dialect      ast.Dialect
+	strictOnce   sync.Once
+	strict       *ast.Ast
+	strictErr    error
+	fallibleOnce sync.Once
+	fallible     *ast.Ast
+	fallibleErr  error
+	tolerantOnce sync.Once
+	tolerant     *ast.Ast
+	tolerantErr  error
+	// Last version of the document that parsed without errors, if any
+	lastGood *goodSyntax
+}
+
+// Syntax tree of a document version without parse errors
+type goodSyntax struct {
+	text string
+	ast  *ast.Ast
+}
+
+// Document with uri in dialect, or the dialect detected from the document if
+// empty
+func NewDocument(uri, documentText string, version int, dialect ast.Dialect) Document {
+	if dialect == "" {
+		dialect = ast.DetectDialect(documentText, uri)
+	}
+	return Document{
+		Text:    documentText,
+		Version: version,
+		syntax:  &documentSyntax{uri: uri, dialect: dialect},
+	}
+}
+
+// Dialect the document is parsed and checked in, empty if it is unknown
+func (d Document) Dialect() ast.Dialect {
+	if d.syntax == nil {
+		return ast.DetectDialect(d.Text, "")
+	}
+	return d.syntax.dialect
+}
+
+// Syntax tree of the document, if it can be parsed without errors
+func (d Document) Ast() (*ast.Ast, error) {
+	if d.syntax == nil {
+		return ast.ParseDocument(d.Text, "", false)
+	}
+	d.syntax.strictOnce.Do(func() {
+		d.syntax.strict, d.syntax.strictErr = ast.ParseDocumentAs(d.Text, d.syntax.uri, false, d.syntax.dialect)
+	})
+	return d.syntax.strict, d.syntax.strictErr
+}
+
+// Syntax tree of the document, recovering from parse errors
+func (d Document) FallibleAst() (*ast.Ast, error) {
+	if d.syntax == nil {
+		return ast.ParseDocument(d.Text, "", true)
+	}
+	d.syntax.fallibleOnce.Do(func() {
+		d.syntax.fallible, d.syntax.fallibleErr = ast.ParseDocumentAs(d.Text, d.syntax.uri, true, d.syntax.dialect)
+	})
+	return d.syntax.fallible, d.syntax.fallibleErr
+}
+
+// Syntax tree of the document for navigation, which is available despite
+// parse errors in most cases. Regions with errors are marked as broken.
+func (d Document) TolerantAst() (*ast.Ast, error) {
+	if fileAst, err := d.Ast(); err == nil || d.syntax == nil {
+		return fileAst, err
+	}
+	d.syntax.tolerantOnce.Do(func() {
+		var goodText string
+		var goodAst *ast.Ast
+		if d.syntax.lastGood != nil {
+			goodText, goodAst = d.syntax.lastGood.text, d.syntax.lastGood.ast
+		}
+		d.syntax.tolerant, d.syntax.tolerantErr = ast.ParseTolerant(
+			d.Text,
+			d.syntax.uri,
+			d.syntax.dialect,
+			goodText,
+			goodAst,
+		)
+	})
+	return d.syntax.tolerant, d.syntax.tolerantErr
+}
+
+// The document itself if it parses without errors, else the last version
+// that did
+func (d Document) goodSyntax() *goodSyntax {
+	if d.syntax == nil {
+		return nil
+	}
+	if fileAst, err := d.Ast(); err == nil {
+		return &goodSyntax{text: d.Text, ast: fileAst}
+	}
+	return d.syntax.lastGood
 }
 
 type Config struct {
 	ExcludeDirs            []string
 	DiagnosticDebounceTime time.Duration
-	ShellCheckOptions      shellcheck.Options
-	FormatOptions          FormatOptions
+	// Directory to persist the workspace index in, not persisted if empty
+	IndexCacheDir string
+	// Dialect of all scripts, detected per script if empty
+	Dialect           ast.Dialect
+	ShellCheckOptions shellcheck.Options
+	FormatOptions     FormatOptions
 }
 
 type FormatOptions struct {
@@ -37,11 +146,22 @@ type FormatOptions struct {
 }
 
 type State struct {
-	Documents         map[string]Document
-	EnvVars           map[string]string
-	WorkspaceFolders  []lsp.WorkspaceFolder
-	PathItems         []string
-	Config            Config
+	Documents        map[string]Document
+	EnvVars          map[string]string
+	WorkspaceFolders []lsp.WorkspaceFolder
+	PathItems        []string
+	Config           Config
+	// Configuration of workspace folders by folder URI, for clients providing
+	// settings per folder
+	FolderConfigs      map[string]Config
+	ClientCapabilities lsp.ClientCapabilities
+	PositionEncoding   lsp.PositionEncodingKind
+	// Files on disk, shared by all snapshots
+	Files *fileCache
+	// Files sourcing each other, shared by all snapshots
+	Sources *sourceGraph
+	// Index of the workspace files, shared by all snapshots
+	Index             *workspaceIndex
 	ShutdownRequested bool
 }
 
@@ -57,34 +177,152 @@ func NewState(config Config) State {
 		EnvVars:           envVars,
 		PathItems:         pathItems,
 		Config:            config,
+		FolderConfigs:     make(map[string]Config),
+		PositionEncoding:  lsp.PositionEncodingUTF16,
+		Files:             newFileCache(),
+		Sources:           newSourceGraph(),
+		Index:             newWorkspaceIndex(),
 		ShutdownRequested: false,
 	}
 }
 
-func (s *State) SetDocument(uri, documentText string) {
-	s.Documents[uri] = Document{
-		Text:         documentText,
-		SourcedFiles: []Document{},
+// Copy of the state for handling requests concurrently to changes of the
+// state. Documents are immutable, so only the containers need to be copied.
+func (s *State) Snapshot() State {
+	snapshot := *s
+	snapshot.Documents = maps.Clone(s.Documents)
+	snapshot.WorkspaceFolders = slices.Clone(s.WorkspaceFolders)
+	snapshot.FolderConfigs = maps.Clone(s.FolderConfigs)
+	return snapshot
+}
+
+// Configuration of the innermost workspace folder containing the document or
+// file with uri, or else the configuration of the server
+func (s *State) ConfigFor(uri string) Config {
+	path, err := utils.UriToPath(uri)
+	if err != nil {
+		return s.Config
 	}
+
+	config := s.Config
+	longest := -1
+	for folderURI, folderConfig := range s.FolderConfigs {
+		folderPath, err := utils.UriToPath(folderURI)
+		if err != nil {
+			continue
+		}
+		if path != folderPath && !strings.HasPrefix(path, folderPath+string(filepath.Separator)) {
+			continue
+		}
+		if len(folderPath) > longest {
+			config = folderConfig
+			longest = len(folderPath)
+		}
+	}
+	return config
 }
 
-// Find sh-files and return their filepaths
+func (s *State) SetDocument(uri, documentText string, version int) {
+	document := NewDocument(uri, documentText, version, s.ConfigFor(uri).Dialect)
+	if previous, ok := s.Documents[uri]; ok {
+		document.syntax.lastGood = previous.goodSyntax()
+	}
+	s.Documents[uri] = document
+}
+
+// Parse the open documents again, e.g. after the configured dialect changed
+func (s *State) ResetDocuments() {
+	for uri, document := range s.Documents {
+		s.SetDocument(uri, document.Text, document.Version)
+	}
+}
+
+func (s *State) RemoveDocument(uri string) {
+	delete(s.Documents, uri)
+}
+
+// Apply the content changes of a `textDocument/didChange` notification in the
+// given order. A change without range replaces the entire document.
+func (s *State) ApplyChanges(uri string, version int, changes []lsp.TextDocumentContentChangeEvent) {
+	documentText := s.Documents[uri].Text
+	for _, change := range changes {
+		documentText = applyChange(s.NewMapper(documentText), documentText, change)
+	}
+	s.SetDocument(uri, documentText, version)
+}
+
+func applyChange(mapper *lsp.Mapper, documentText string, change lsp.TextDocumentContentChangeEvent) string {
+	if change.Range == nil {
+		return change.Text
+	}
+
+	start := mapper.Offset(change.Range.Start)
+	end := mapper.Offset(change.Range.End)
+	if end < start {
+		start, end = end, start
+	}
+	return documentText[:start] + change.Text + documentText[end:]
+}
+
+// Mapper for positions in text using the negotiated position encoding
+func (s *State) NewMapper(text string) *lsp.Mapper {
+	return lsp.NewMapper(text, s.PositionEncoding)
+}
+
+// Syntax tree and text of the file on disk at path, parsed in the dialect
+// configured for it
+func (s *State) FileAst(path string) (*ast.Ast, string, error) {
+	return s.Files.ast(path, s.ConfigFor(utils.PathToURI(path)).Dialect)
+}
+
+// Syntax tree of the open document at path, else of the file on disk, for
+// finding definitions and references across files. Positions found in it
+// belong to the text of FileMapper.
+func (s *State) parseFile(path string) (*ast.Ast, error) {
+	if document, ok := s.Documents[utils.PathToURI(path)]; ok {
+		return document.TolerantAst()
+	}
+	fileAst, _, err := s.FileAst(path)
+	return fileAst, err
+}
+
+// Mapper for positions in the open document at path, else in the file on
+// disk. If the file can not be read, byte columns are passed through
+// unchanged.
+func (s *State) FileMapper(path string) *lsp.Mapper {
+	if document, ok := s.Documents[utils.PathToURI(path)]; ok {
+		return s.NewMapper(document.Text)
+	}
+	content, err := s.Files.text(path)
+	if err != nil {
+		slog.Error("Could not read file", "file", path)
+	}
+	return s.NewMapper(content)
+}
+
+// Find sh-files in the workspace and return their filepaths
 func (s *State) WorkspaceShFiles() []string {
+	return s.ShFiles(s.WorkspaceFolders)
+}
+
+// Find sh-files in folders and return their filepaths
+func (s *State) ShFiles(folders []lsp.WorkspaceFolder) []string {
 	var shFiles []string
 	var mu sync.Mutex
 	var wg sync.WaitGroup
 
-	for _, folder := range s.WorkspaceFolders {
+	for _, folder := range folders {
 		dirpath, err := utils.UriToPath(folder.URI)
 		if err != nil {
 			continue
 		}
+		excludeDirs := s.ConfigFor(folder.URI).ExcludeDirs
 
 		filepath.WalkDir(dirpath, func(path string, d fs.DirEntry, err error) error {
 			if err != nil {
 				return err
 			}
-			if d.IsDir() && slices.Contains(s.Config.ExcludeDirs, d.Name()) {
+			if d.IsDir() && slices.Contains(excludeDirs, d.Name()) {
 				return fs.SkipDir
 			}
 
@@ -121,6 +359,30 @@ func (s *State) WorkspaceShFiles() []string {
 	return shFiles
 }
 
+// Whether the file at path is a shell script, like the files found by
+// WorkspaceShFiles
+func (s *State) isShFile(path string) bool {
+	excludeDirs := s.ConfigFor(utils.PathToURI(path)).ExcludeDirs
+	for _, dir := range strings.Split(filepath.Dir(path), string(filepath.Separator)) {
+		if slices.Contains(excludeDirs, dir) {
+			return false
+		}
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	switch fileutil.CouldBeScript2(fs.FileInfoToDirEntry(info)) {
+	case fileutil.ConfIsScript:
+		return true
+	case fileutil.ConfIfShebang:
+		data, err := os.ReadFile(path)
+		return err == nil && fileutil.HasShebang(data)
+	}
+	return false
+}
+
 func getEnvVars() map[string]string {
 	env := os.Environ()
 	envVars := make(map[string]string)
@@ -0,0 +1,183 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/matkrin/bashd/internal/ast"
+	"github.com/matkrin/bashd/internal/lsp"
+)
+
+func rangeChange(startLine, startChar, endLine, endChar uint, text string) lsp.TextDocumentContentChangeEvent {
+	r := lsp.NewRange(startLine, startChar, endLine, endChar)
+	return lsp.TextDocumentContentChangeEvent{Range: &r, Text: text}
+}
+
+func Test_ApplyChanges(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		changes []lsp.TextDocumentContentChangeEvent
+		want    string
+	}{
+		{
+			"Full replacement",
+			"echo a\n",
+			[]lsp.TextDocumentContentChangeEvent{{Text: "echo b\n"}},
+			"echo b\n",
+		},
+		{
+			"Insert",
+			"echo a\n",
+			[]lsp.TextDocumentContentChangeEvent{rangeChange(0, 6, 0, 6, "bc")},
+			"echo abc\n",
+		},
+		{
+			"Delete across lines",
+			"a=1\nb=2\nc=3\n",
+			[]lsp.TextDocumentContentChangeEvent{rangeChange(0, 3, 1, 3, "")},
+			"a=1\nc=3\n",
+		},
+		{
+			"Multiple edits applied in order",
+			"foo() {\n\techo foo\n}\n",
+			[]lsp.TextDocumentContentChangeEvent{
+				rangeChange(0, 0, 0, 3, "bar"),
+				rangeChange(1, 6, 1, 9, "bar"),
+				rangeChange(2, 1, 2, 1, "\n\nbar"),
+			},
+			"bar() {\n\techo bar\n}\n\nbar\n",
+		},
+		{
+			"Edit depending on previous edit",
+			"echo\n",
+			[]lsp.TextDocumentContentChangeEvent{
+				rangeChange(0, 4, 0, 4, " hello"),
+				rangeChange(0, 10, 0, 10, " world"),
+			},
+			"echo hello world\n",
+		},
+		{
+			"Full replacement followed by range edit",
+			"old\n",
+			[]lsp.TextDocumentContentChangeEvent{
+				{Text: "a=1\n"},
+				rangeChange(0, 2, 0, 3, "2"),
+			},
+			"a=2\n",
+		},
+		{
+			"CRLF line endings",
+			"a=1\r\nb=2\r\nc=3\r\n",
+			[]lsp.TextDocumentContentChangeEvent{
+				rangeChange(1, 0, 1, 1, "x"),
+				rangeChange(2, 3, 2, 3, "4"),
+			},
+			"a=1\r\nx=2\r\nc=34\r\n",
+		},
+		{
+			"CRLF position beyond line end is clamped",
+			"a=1\r\nb=2\r\n",
+			[]lsp.TextDocumentContentChangeEvent{rangeChange(0, 99, 0, 99, "0")},
+			"a=10\r\nb=2\r\n",
+		},
+		{
+			"CRLF delete line break",
+			"a=1\r\nb=2\r\n",
+			[]lsp.TextDocumentContentChangeEvent{rangeChange(0, 3, 1, 0, "; ")},
+			"a=1; b=2\r\n",
+		},
+		{
+			"UTF-16 code units",
+			"echo \"äö 😀 x\"\n",
+			[]lsp.TextDocumentContentChangeEvent{rangeChange(0, 12, 0, 13, "y")},
+			"echo \"äö 😀 y\"\n",
+		},
+		{
+			"Position beyond end of document",
+			"echo",
+			[]lsp.TextDocumentContentChangeEvent{rangeChange(5, 0, 5, 0, "\n")},
+			"echo\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state := NewState(Config{})
+			state.SetDocument("file://workspace/test.sh", tt.text, 1)
+			state.ApplyChanges("file://workspace/test.sh", 2, tt.changes)
+
+			got := state.Documents["file://workspace/test.sh"].Text
+			if got != tt.want {
+				t.Errorf("ApplyChanges() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_ApplyChangesPositionEncoding(t *testing.T) {
+	tests := []struct {
+		encoding lsp.PositionEncodingKind
+		change   lsp.TextDocumentContentChangeEvent
+	}{
+		{lsp.PositionEncodingUTF8, rangeChange(0, 16, 0, 17, "y")},
+		{lsp.PositionEncodingUTF16, rangeChange(0, 12, 0, 13, "y")},
+		{lsp.PositionEncodingUTF32, rangeChange(0, 11, 0, 12, "y")},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.encoding), func(t *testing.T) {
+			state := NewState(Config{})
+			state.PositionEncoding = tt.encoding
+			state.SetDocument("file://workspace/test.sh", "echo \"äö 😀 x\"\n", 1)
+			state.ApplyChanges("file://workspace/test.sh", 2, []lsp.TextDocumentContentChangeEvent{tt.change})
+
+			got := state.Documents["file://workspace/test.sh"].Text
+			if got != "echo \"äö 😀 y\"\n" {
+				t.Errorf("ApplyChanges() = %q, want %q", got, "echo \"äö 😀 y\"\n")
+			}
+		})
+	}
+}
+
+func Test_DocumentAst(t *testing.T) {
+	state := NewState(Config{})
+	state.SetDocument("file://workspace/test.sh", "echo \"$(\n", 1)
+	snapshot := state.Snapshot()
+
+	if _, err := state.Documents["file://workspace/test.sh"].Ast(); err == nil {
+		t.Errorf("expected parse error")
+	}
+	first, err := state.Documents["file://workspace/test.sh"].FallibleAst()
+	if err != nil {
+		t.Fatalf("expected error recovering parse, got %v", err)
+	}
+	second, _ := snapshot.Documents["file://workspace/test.sh"].FallibleAst()
+	if first != second {
+		t.Errorf("expected syntax tree to be parsed once per version")
+	}
+
+	state.ApplyChanges("file://workspace/test.sh", 2, []lsp.TextDocumentContentChangeEvent{{Text: "echo\n"}})
+	changed, err := state.Documents["file://workspace/test.sh"].Ast()
+	if err != nil || changed == first {
+		t.Errorf("expected new syntax tree for new version, got %v", err)
+	}
+}
+
+func Test_FileAstDialect(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lib.bash")
+	if err := os.WriteFile(path, []byte("arr=(1 2)\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	state := NewState(Config{Dialect: ast.DialectSh})
+	if _, _, err := state.FileAst(path); err == nil {
+		t.Errorf("expected parse error in configured dialect")
+	}
+
+	state.Config.Dialect = ""
+	if _, _, err := state.FileAst(path); err != nil {
+		t.Errorf("expected file to parse in detected dialect, got %v", err)
+	}
+}
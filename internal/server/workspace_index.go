@@ -0,0 +1,403 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/matkrin/bashd/internal/ast"
+	"github.com/matkrin/bashd/internal/lsp"
+	"github.com/matkrin/bashd/internal/utils"
+)
+
+// Bump when the format of indexed files changes, so that persisted indexes of
+// older versions are discarded
+const indexFormatVersion = 2
+
+// Index of the workspace files: their definitions, the names they reference
+// and the files they source. The index is built once in the background,
+// optionally from a persisted index, and afterwards updated per file.
+type workspaceIndex struct {
+	// Only one build at a time
+	buildMu sync.Mutex
+	mu      sync.RWMutex
+	files   map[string]indexedFile
+	built   bool
+	// Files updated while the index is built, whose entries are newer than
+	// the ones of the build
+	building bool
+	updated  map[string]bool
+}
+
+type indexedFile struct {
+	// Modification time in nanoseconds and size of the file on disk. Zero for
+	// files indexed from the text of an open document.
+	ModTime int64 `json:"modTime"`
+	Size    int64 `json:"size"`
+	// Definitions, located in the position encoding of the index
+	Symbols []lsp.WorkspaceSymbol `json:"symbols"`
+	// Sorted names of the variables and functions referenced
+	Names []string `json:"names"`
+	// Resolved paths of the files sourced directly
+	Sources []string `json:"sources"`
+	// Paths of the files sourced directly that cannot be evaluated statically
+	DynamicSources []string `json:"dynamicSources"`
+}
+
+type persistedIndex struct {
+	Version          int                      `json:"version"`
+	PositionEncoding lsp.PositionEncodingKind `json:"positionEncoding"`
+	// Hash of the inputs the sourced files were resolved with
+	SourcesKey string                 `json:"sourcesKey"`
+	Files      map[string]indexedFile `json:"files"`
+}
+
+func newWorkspaceIndex() *workspaceIndex {
+	return &workspaceIndex{
+		files:   make(map[string]indexedFile),
+		updated: make(map[string]bool),
+	}
+}
+
+// Index the file at path with the text of document. Parse errors are
+// tolerated, false is returned if it cannot be parsed at all, together with an
+// empty entry.
+func indexFile(path string, document Document, state *State) (indexedFile, bool) {
+	entry := indexedFile{
+		Symbols:        []lsp.WorkspaceSymbol{},
+		Names:          []string{},
+		Sources:        []string{},
+		DynamicSources: []string{},
+	}
+
+	fileAst, err := document.TolerantAst()
+	if err != nil {
+		slog.Error("Could not parse file", "file", path)
+		return entry, false
+	}
+
+	mapper := state.NewMapper(document.Text)
+	for _, defNode := range fileAst.DefNodes() {
+		entry.Symbols = append(entry.Symbols, findWorkSpaceSymbol(&defNode, path, mapper))
+	}
+
+	names := make(map[string]bool)
+	for _, refNode := range fileAst.RefNodes(true) {
+		names[refNode.Name] = true
+	}
+	entry.Names = slices.Sorted(maps.Keys(names))
+
+	entry.Sources, entry.DynamicSources = resolveSources(path, fileAst, state)
+
+	return entry, true
+}
+
+// Index the workspace files of state. Files unchanged since the persisted
+// index in cacheDir was saved are not parsed again.
+func (i *workspaceIndex) build(ctx context.Context, state *State, cacheDir string) {
+	i.buildMu.Lock()
+	defer i.buildMu.Unlock()
+
+	i.mu.Lock()
+	i.building = true
+	clear(i.updated)
+	i.mu.Unlock()
+
+	persisted := loadIndex(cacheDir, state)
+	shFiles := state.WorkspaceShFiles()
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	files := make(map[string]indexedFile, len(shFiles))
+	semaphore := make(chan struct{}, runtime.NumCPU())
+	for _, shFile := range shFiles {
+		if ctx.Err() != nil {
+			break
+		}
+
+		semaphore <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+
+			var previous *indexedFile
+			if entry, ok := persisted[shFile]; ok {
+				previous = &entry
+			}
+			entry, ok := indexFileOnDisk(shFile, previous, state)
+			if !ok {
+				return
+			}
+			state.Sources.setFromDisk(shFile, entry.Sources, entry.DynamicSources)
+			mu.Lock()
+			files[shFile] = entry
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.building = false
+	if ctx.Err() != nil {
+		return
+	}
+	for path, entry := range files {
+		if !i.updated[path] {
+			i.files[path] = entry
+		}
+	}
+	i.built = true
+
+	if cacheDir != "" {
+		saveIndex(cacheDir, state, i.files)
+	}
+}
+
+// Index the file at path, unless it did not change since previous was indexed.
+// Previous, which may be nil, is kept if the file cannot be parsed.
+func indexFileOnDisk(path string, previous *indexedFile, state *State) (indexedFile, bool) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return indexedFile{}, false
+	}
+	if previous != nil &&
+		previous.ModTime != 0 &&
+		previous.ModTime == info.ModTime().UnixNano() &&
+		previous.Size == info.Size() {
+		return *previous, true
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		slog.Error("Could not read file", "file", path)
+		return indexedFile{}, false
+	}
+	uri := utils.PathToURI(path)
+	entry, ok := indexFile(path, NewDocument(uri, string(content), 0, state.ConfigFor(uri).Dialect), state)
+	if !ok && previous != nil {
+		// Its modification time is kept, so that it is parsed again later
+		return *previous, true
+	}
+	entry.ModTime = info.ModTime().UnixNano()
+	entry.Size = info.Size()
+	return entry, true
+}
+
+func (i *workspaceIndex) update(path string, entry indexedFile) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.files[path] = entry
+	if i.building {
+		i.updated[path] = true
+	}
+}
+
+func (i *workspaceIndex) remove(path string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	delete(i.files, path)
+	if i.building {
+		i.updated[path] = true
+	}
+}
+
+// Remove the files within the directory dir
+func (i *workspaceIndex) removeDir(dir string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	for path := range i.files {
+		if strings.HasPrefix(path, dir+string(filepath.Separator)) {
+			delete(i.files, path)
+		}
+	}
+}
+
+// Entry of the file at path, nil if it is not indexed
+func (i *workspaceIndex) entry(path string) *indexedFile {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	entry, ok := i.files[path]
+	if !ok {
+		return nil
+	}
+	return &entry
+}
+
+// Whether the index is built and can answer queries
+func (i *workspaceIndex) ready() bool {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	return i.built
+}
+
+// Definitions of all indexed files, ordered by file
+func (i *workspaceIndex) symbols() []lsp.WorkspaceSymbol {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	var symbols []lsp.WorkspaceSymbol
+	for _, path := range slices.Sorted(maps.Keys(i.files)) {
+		symbols = append(symbols, i.files[path].Symbols...)
+	}
+	return symbols
+}
+
+// Indexed files that define or reference name
+func (i *workspaceIndex) filesReferencing(name string) []string {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	var paths []string
+	for path, entry := range i.files {
+		if _, found := slices.BinarySearch(entry.Names, name); found {
+			paths = append(paths, path)
+		}
+	}
+	slices.Sort(paths)
+	return paths
+}
+
+func (i *workspaceIndex) save(cacheDir string, state *State) {
+	if cacheDir == "" {
+		return
+	}
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	if i.built {
+		saveIndex(cacheDir, state, i.files)
+	}
+}
+
+// The persisted index of a workspace is identified by its folders
+func indexCachePath(cacheDir string, state *State) string {
+	hash := sha256.New()
+	for _, folder := range state.WorkspaceFolders {
+		hash.Write([]byte(folder.URI + "\x00"))
+	}
+	return filepath.Join(cacheDir, "index-"+hex.EncodeToString(hash.Sum(nil)[:16])+".json")
+}
+
+func loadIndex(cacheDir string, state *State) map[string]indexedFile {
+	if cacheDir == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(indexCachePath(cacheDir, state))
+	if err != nil {
+		return nil
+	}
+	var persisted persistedIndex
+	if err := json.Unmarshal(data, &persisted); err != nil {
+		slog.Error("Could not load workspace index", "err", err)
+		return nil
+	}
+	if persisted.Version != indexFormatVersion ||
+		persisted.PositionEncoding != state.PositionEncoding ||
+		persisted.SourcesKey != sourcesKey(state, persisted.Files) {
+		return nil
+	}
+	return persisted.Files
+}
+
+// Hash of the inputs the sourced files of files are resolved with, besides
+// the files themselves: the configured source paths and the environment
+// variables referenced in files
+func sourcesKey(state *State, files map[string]indexedFile) string {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%q\x00", state.Config.ShellCheckOptions.SourcePaths)
+	for _, folder := range state.WorkspaceFolders {
+		fmt.Fprintf(hash, "%s\x00%q\x00", folder.URI, state.ConfigFor(folder.URI).ShellCheckOptions.SourcePaths)
+	}
+
+	names := make(map[string]bool)
+	for _, entry := range files {
+		for _, name := range entry.Names {
+			if _, ok := state.EnvVars[name]; ok {
+				names[name] = true
+			}
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(names)) {
+		fmt.Fprintf(hash, "%s=%s\x00", name, state.EnvVars[name])
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func saveIndex(cacheDir string, state *State, files map[string]indexedFile) {
+	persisted := persistedIndex{
+		Version:          indexFormatVersion,
+		PositionEncoding: state.PositionEncoding,
+		Files:            make(map[string]indexedFile, len(files)),
+	}
+	for path, entry := range files {
+		// Entries of open documents may not match the files on disk
+		if entry.ModTime != 0 {
+			persisted.Files[path] = entry
+		}
+	}
+	persisted.SourcesKey = sourcesKey(state, persisted.Files)
+
+	data, err := json.Marshal(persisted)
+	if err != nil {
+		slog.Error("Could not save workspace index", "err", err)
+		return
+	}
+	if err := os.MkdirAll(cacheDir, 0o755); err != nil {
+		slog.Error("Could not save workspace index", "err", err)
+		return
+	}
+	// Written to a temporary file first, so that concurrent servers do not
+	// read a partially written index
+	path := indexCachePath(cacheDir, state)
+	tmp, err := os.CreateTemp(cacheDir, filepath.Base(path)+".*")
+	if err != nil {
+		slog.Error("Could not save workspace index", "err", err)
+		return
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		slog.Error("Could not save workspace index", "err", err)
+		return
+	}
+	tmp.Close()
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		slog.Error("Could not save workspace index", "err", err)
+	}
+}
+
+// Workspace files that might reference the identifier under cursor: the indexed
+// files referencing it or, until the index is built, all workspace files
+func (s *State) workspaceFilesReferencing(fileAst *ast.Ast, cursor ast.Cursor) []string {
+	if !s.Index.ready() {
+		return s.WorkspaceShFiles()
+	}
+	name := ast.ExtractIdentifier(fileAst.FindNodeUnderCursor(cursor))
+	return s.Index.filesReferencing(name)
+}
+
+// Whether path is within one of the workspace folders
+func (s *State) inWorkspace(path string) bool {
+	for _, folder := range s.WorkspaceFolders {
+		folderPath, err := utils.UriToPath(folder.URI)
+		if err != nil {
+			continue
+		}
+		if strings.HasPrefix(path, folderPath+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
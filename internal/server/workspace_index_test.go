@@ -0,0 +1,128 @@
+package server
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/matkrin/bashd/internal/lsp"
+	"github.com/matkrin/bashd/internal/shellcheck"
+	"github.com/matkrin/bashd/internal/utils"
+)
+
+func Test_workspaceIndex(t *testing.T) {
+	dir := t.TempDir()
+	cacheDir := t.TempDir()
+	mainPath := filepath.Join(dir, "main.sh")
+	libPath := filepath.Join(dir, "lib.sh")
+	writeFile := func(path, content string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile(mainPath, "source ./lib.sh\ngreet\n")
+	writeFile(libPath, "greet() {\n\techo hello\n}\n")
+
+	state := NewState(Config{})
+	state.WorkspaceFolders = []lsp.WorkspaceFolder{{URI: utils.PathToURI(dir), Name: "workspace"}}
+
+	index := newWorkspaceIndex()
+	if index.ready() {
+		t.Fatalf("expected index not to be ready before it is built")
+	}
+	index.build(context.Background(), &state, cacheDir)
+
+	if got := index.filesReferencing("greet"); !slices.Equal(got, []string{libPath, mainPath}) {
+		t.Errorf("filesReferencing() = %v, want %v", got, []string{libPath, mainPath})
+	}
+	if got := index.files[mainPath].Sources; !slices.Equal(got, []string{libPath}) {
+		t.Errorf("Sources = %v, want %v", got, []string{libPath})
+	}
+	symbols := index.symbols()
+	if len(symbols) != 1 || symbols[0].Name != "greet" || symbols[0].Location.URI != utils.PathToURI(libPath) {
+		t.Errorf("symbols() = %v, want greet in lib.sh", symbols)
+	}
+
+	// Updated from an open document
+	entry, _ := indexFile(mainPath, NewDocument(utils.PathToURI(mainPath), "echo\n", 1, ""), &state)
+	index.update(mainPath, entry)
+	if got := index.filesReferencing("greet"); !slices.Equal(got, []string{libPath}) {
+		t.Errorf("filesReferencing() = %v, want %v", got, []string{libPath})
+	}
+
+	// Unchanged files are taken from the persisted index, changed ones are
+	// parsed again
+	persisted := loadIndex(cacheDir, &state)
+	if _, ok := persisted[libPath]; !ok {
+		t.Fatalf("expected %s in persisted index", libPath)
+	}
+	writeFile(libPath, "welcome() {\n\techo hello\n}\n")
+	os.Chtimes(libPath, time.Now(), time.Now().Add(time.Minute))
+
+	restarted := newWorkspaceIndex()
+	restarted.build(context.Background(), &state, cacheDir)
+	symbols = restarted.symbols()
+	if len(symbols) != 1 || symbols[0].Name != "welcome" {
+		t.Errorf("symbols() = %v, want welcome", symbols)
+	}
+}
+
+func Test_loadIndexSourcesKey(t *testing.T) {
+	dir := t.TempDir()
+	cacheDir := t.TempDir()
+	mainPath := filepath.Join(dir, "main.sh")
+	if err := os.WriteFile(mainPath, []byte("source \"$LIB_DIR/lib.sh\"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	newState := func() State {
+		state := NewState(Config{})
+		state.WorkspaceFolders = []lsp.WorkspaceFolder{{URI: utils.PathToURI(dir), Name: "workspace"}}
+		state.EnvVars = map[string]string{"LIB_DIR": "/lib", "EDITOR": "vi"}
+		return state
+	}
+	state := newState()
+	newWorkspaceIndex().build(context.Background(), &state, cacheDir)
+
+	tests := []struct {
+		name   string
+		change func(state *State)
+		want   bool
+	}{
+		{"unchanged", func(state *State) {}, true},
+		{"unreferenced variable", func(state *State) { state.EnvVars["EDITOR"] = "nano" }, true},
+		{"referenced variable", func(state *State) { state.EnvVars["LIB_DIR"] = "/usr/lib" }, false},
+		{"source paths", func(state *State) {
+			state.FolderConfigs[utils.PathToURI(dir)] = Config{
+				ShellCheckOptions: shellcheck.Options{SourcePaths: []string{"SCRIPTDIR/lib"}},
+			}
+		}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state := newState()
+			tt.change(&state)
+			if _, got := loadIndex(cacheDir, &state)[mainPath]; got != tt.want {
+				t.Errorf("expected persisted index to be loaded: %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func Test_indexFileSyntaxError(t *testing.T) {
+	state := NewState(Config{})
+	path := "/workspace/lib.sh"
+	document := NewDocument(utils.PathToURI(path), "greet() {\n\techo hello\n}\nif true; then\n", 1, "")
+
+	entry, ok := indexFile(path, document, &state)
+	if !ok {
+		t.Fatal("expected file with syntax error to be indexed")
+	}
+	if len(entry.Symbols) != 1 || entry.Symbols[0].Name != "greet" {
+		t.Errorf("Symbols = %v, want greet", entry.Symbols)
+	}
+}
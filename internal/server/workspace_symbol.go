@@ -1,8 +1,8 @@
 package server
 
 import (
+	"context"
 	"log/slog"
-	"os"
 
 	"github.com/matkrin/bashd/internal/ast"
 	"github.com/matkrin/bashd/internal/lsp"
@@ -10,27 +10,32 @@ import (
 	"mvdan.cc/sh/v3/syntax"
 )
 
-func handleWorkspaceSymbol(request *lsp.WorkspaceSymbolRequest, state *State) *lsp.WorkspaceSymbolResponse {
+func handleWorkspaceSymbol(ctx context.Context, request *lsp.WorkspaceSymbolRequest, state *State) *lsp.WorkspaceSymbolResponse {
+	if state.Index.ready() {
+		response := lsp.NewWorkspaceSymbolResponse(request.ID, state.Index.symbols())
+		return &response
+	}
+
+	// Until the index is built, the workspace files are parsed on request
 	shFiles := state.WorkspaceShFiles()
 
 	var workspaceSymbols []lsp.WorkspaceSymbol
 	for _, shFile := range shFiles {
-		fileContent, err := os.ReadFile(shFile)
-		if err != nil {
-			slog.Error("Could not read file", "file", shFile)
-			continue
+		if ctx.Err() != nil {
+			return nil
 		}
 
-		fileAst, err := ast.ParseDocument(string(fileContent), shFile, false)
+		fileAst, fileContent, err := state.FileAst(shFile)
 		if err != nil {
 			slog.Error("Could not parse file", "file", shFile)
 			continue
 		}
 
+		mapper := state.NewMapper(fileContent)
 		for _, defNode := range fileAst.DefNodes() {
 			workspaceSymbols = append(
 				workspaceSymbols,
-				findWorkSpaceSymbol(&defNode, shFile),
+				findWorkSpaceSymbol(&defNode, shFile, mapper),
 			)
 		}
 	}
@@ -38,7 +43,7 @@ func handleWorkspaceSymbol(request *lsp.WorkspaceSymbolRequest, state *State) *l
 	return &response
 }
 
-func findWorkSpaceSymbol(defNode *ast.DefNode, filePath string) lsp.WorkspaceSymbol {
+func findWorkSpaceSymbol(defNode *ast.DefNode, filePath string, mapper *lsp.Mapper) lsp.WorkspaceSymbol {
 	var kind lsp.SymbolKind
 	var  endLine, endCol uint
 	switch n := defNode.Node.(type) {
@@ -70,7 +75,7 @@ func findWorkSpaceSymbol(defNode *ast.DefNode, filePath string) lsp.WorkspaceSym
 		Kind: kind,
 		Location: lsp.Location{
 			URI: utils.PathToURI(filePath),
-			Range: lsp.NewRange(
+			Range: mapper.ByteRange(
 				startLine,
 				startCol,
 				endLine,
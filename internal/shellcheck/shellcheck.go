@@ -1,12 +1,14 @@
 package shellcheck
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"os/exec"
+	"path/filepath"
 	"strings"
 
 	"github.com/matkrin/bashd/internal/lsp"
@@ -14,11 +16,34 @@ import (
 )
 
 type Options struct {
-	Include  []string
-	Exclude  []string
-	Enable   []string // See `shellcheck --list-optional`
-	Dialect  string   // sh, bash, dash, ksh, busybox
-	Severity string   // error, warning, info, style
+	Include     []string
+	Exclude     []string
+	Enable      []string // See `shellcheck --list-optional`
+	Dialect     string   // sh, bash, dash, ksh, busybox
+	Severity    string   // error, warning, info, style
+	SourcePaths []string // Searched for sourced files, SCRIPTDIR is the directory of the script
+}
+
+// Options for checking the script at path in dialect, which shellcheck
+// detects itself if empty. The script is passed on stdin, so SCRIPTDIR and
+// relative source paths are replaced by the directory of the script, which is
+// searched last like bashd does.
+func (o Options) ForScript(path, dialect string) Options {
+	if dialect != "" {
+		o.Dialect = dialect
+	}
+	scriptDir := filepath.Dir(path)
+	sourcePaths := make([]string, 0, len(o.SourcePaths)+1)
+	for _, sourcePath := range o.SourcePaths {
+		if sourcePath == "SCRIPTDIR" || strings.HasPrefix(sourcePath, "SCRIPTDIR/") {
+			sourcePath = scriptDir + strings.TrimPrefix(sourcePath, "SCRIPTDIR")
+		} else if !filepath.IsAbs(sourcePath) {
+			sourcePath = filepath.Join(scriptDir, sourcePath)
+		}
+		sourcePaths = append(sourcePaths, sourcePath)
+	}
+	o.SourcePaths = append(sourcePaths, scriptDir)
+	return o
 }
 
 // https://github.com/koalaman/shellcheck/wiki/Integration
@@ -49,19 +74,21 @@ type Fix struct {
 	} `json:"replacements"`
 }
 
-func (s *ShellCheckResult) ToDiagnostics() []lsp.Diagnostic {
+// The mapper of the checked file converts the columns of shellcheck, which are
+// counted in characters, to the negotiated position encoding
+func (s *ShellCheckResult) ToDiagnostics(mapper *lsp.Mapper) []lsp.Diagnostic {
 	var diagnostics []lsp.Diagnostic
 	for _, comment := range s.Comments {
-		diagnostics = append(diagnostics, comment.ToDiagnostic())
+		diagnostics = append(diagnostics, comment.ToDiagnostic(mapper))
 	}
 	return diagnostics
 }
 
-func (s *ShellCheckResult) ToCodeActionFlat(uri string) lsp.CodeAction {
+func (s *ShellCheckResult) ToCodeActionFlat(uri string, mapper *lsp.Mapper) lsp.CodeAction {
 	textEdits := []lsp.TextEdit{}
 	for _, comment := range s.Comments {
 		if comment.Fix != nil {
-			textEdits = append(textEdits, comment.Fix.toTextEdits()...)
+			textEdits = append(textEdits, comment.Fix.toTextEdits(mapper)...)
 		}
 	}
 	action := lsp.CodeAction{
@@ -84,7 +111,7 @@ func (s *ShellCheckResult) ContainsFixable() bool {
 	return false
 }
 
-func (c *Comment) ToDiagnostic() lsp.Diagnostic {
+func (c *Comment) ToDiagnostic(mapper *lsp.Mapper) lsp.Diagnostic {
 	code := fmt.Sprintf("SC%d", c.Code)
 	severity := c.levelToSeverity()
 	codeActionAvailable := ""
@@ -96,7 +123,7 @@ func (c *Comment) ToDiagnostic() lsp.Diagnostic {
 	message := fmt.Sprintf("%s%s", c.Message, codeActionAvailable)
 
 	return lsp.Diagnostic{
-		Range: lsp.NewRange(
+		Range: mapper.RuneRange(
 			c.Line-1,
 			c.Column-1,
 			c.EndLine-1,
@@ -109,12 +136,12 @@ func (c *Comment) ToDiagnostic() lsp.Diagnostic {
 	}
 }
 
-func (c *Comment) ToCodeActionFixLint(uri string) *lsp.CodeAction {
+func (c *Comment) ToCodeActionFixLint(uri string, mapper *lsp.Mapper) *lsp.CodeAction {
 	if c.Fix == nil {
 		return nil
 	}
 
-	textEdits := c.Fix.toTextEdits()
+	textEdits := c.Fix.toTextEdits(mapper)
 	action := &lsp.CodeAction{
 		Title: fmt.Sprintf("Fix shellcheck lint %d", c.Code),
 		Edit: lsp.WorkspaceEdit{
@@ -165,11 +192,11 @@ func (c *Comment) levelToSeverity() lsp.DiagnosticSeverity {
 	return severity
 }
 
-func (f *Fix) toTextEdits() []lsp.TextEdit {
+func (f *Fix) toTextEdits(mapper *lsp.Mapper) []lsp.TextEdit {
 	textEdits := []lsp.TextEdit{}
 	for _, rep := range f.Replacements {
 		textEdit := lsp.TextEdit{
-			Range: lsp.NewRange(
+			Range: mapper.RuneRange(
 				rep.Line-1,
 				rep.Column-1,
 				rep.Line-1,
@@ -183,7 +210,9 @@ func (f *Fix) toTextEdits() []lsp.TextEdit {
 	return textEdits
 }
 
-func Run(filecontent string, options Options) (*ShellCheckResult, error) {
+// Run shellcheck on filecontent. The shellcheck process is killed when ctx is
+// done.
+func Run(ctx context.Context, filecontent string, options Options) (*ShellCheckResult, error) {
 	optionalLints := options.Enable
 
 	args := []string{
@@ -205,8 +234,11 @@ func Run(filecontent string, options Options) (*ShellCheckResult, error) {
 	if options.Severity != "" {
 		args = append(args, fmt.Sprintf("--severity=%s", options.Severity))
 	}
+	for _, sourcePath := range options.SourcePaths {
+		args = append(args, fmt.Sprintf("--source-path=%s", sourcePath))
+	}
 	args = append(args, "-")
-	cmd := exec.Command("shellcheck", args...)
+	cmd := exec.CommandContext(ctx, "shellcheck", args...)
 	stdin, err := cmd.StdinPipe()
 	if err != nil {
 		return nil, errors.New("Could not acquire stdin")
@@ -218,6 +250,9 @@ func Run(filecontent string, options Options) (*ShellCheckResult, error) {
 	}()
 
 	shOutput, err := cmd.CombinedOutput()
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
 	if err != nil {
 		// shellcheck exists with non-zero exit code if lints were founD
 		// https://github.com/koalaman/shellcheck/wiki/Integration#exit-codes